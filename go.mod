@@ -4,14 +4,17 @@ go 1.22.2
 
 require (
 	github.com/andybalholm/brotli v1.1.0
+	github.com/fxamacker/cbor/v2 v2.7.0
 	github.com/mitchellh/mapstructure v1.5.0
 	github.com/savsgio/gotils v0.0.0-20240704082632-aef3928b8a38
 	github.com/valyala/fasthttp v1.55.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	github.com/zishang520/engine.io-go-parser v1.2.5
 	github.com/zishang520/engine.io-server-go-fasthttp/v2 v2.1.2
 	github.com/zishang520/engine.io/v2 v2.1.1
 	github.com/zishang520/socket.io-go-parser/v2 v2.1.0
 	github.com/zishang520/socket.io/v2 v2.2.0
+	google.golang.org/protobuf v1.34.2
 )
 
 require (
@@ -26,8 +29,8 @@ require (
 	github.com/quic-go/quic-go v0.44.0 // indirect
 	github.com/quic-go/webtransport-go v0.8.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
-	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
 	go.uber.org/mock v0.4.0 // indirect
 	golang.org/x/crypto v0.24.0 // indirect
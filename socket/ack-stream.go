@@ -0,0 +1,298 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zishang520/engine.io/v2/types"
+	"github.com/zishang520/engine.io/v2/utils"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+type (
+	// The acknowledgement of a socket, see [BroadcastOperator.EmitWithAckResults].
+	//
+	// A cluster adapter relaying an AckResult between servers serializes Error, as Err is not serializable.
+	AckResult struct {
+		SocketId SocketId `json:"socketId" mapstructure:"socketId" msgpack:"socketId"`
+		Args     []any    `json:"args" mapstructure:"args" msgpack:"args"`
+		// Set if the socket did not acknowledge the event in time
+		Err error `json:"-" mapstructure:"-" msgpack:"-"`
+		// The message of Err, if any
+		Error string `json:"error,omitempty" mapstructure:"error,omitempty" msgpack:"error,omitempty"`
+	}
+
+	// The progress of an emission with [BroadcastOperator.EmitWithAckStream].
+	AckStreamProgress struct {
+		// The number of Socket.IO servers in the cluster
+		ExpectedServerCount int64
+		// The number of servers which reported the sockets they notified
+		ServerCount int64
+		// The number of notified sockets reported so far
+		ExpectedClientCount uint64
+		// The number of acknowledgements received so far
+		ResponseCount uint64
+	}
+
+	// The outcome of an emission with [BroadcastOperator.EmitWithAckStream].
+	AckStreamSummary struct {
+		AckStreamProgress
+
+		// The notified sockets which did not acknowledge the event
+		NonResponders []SocketId
+		// Set if the operation timed out (or the event could not be emitted)
+		Err error
+	}
+
+	// The callbacks of an emission with [BroadcastOperator.EmitWithAckStream]. They are never called concurrently,
+	// and `OnDone` is called last.
+	AckStreamHandler struct {
+		// Called with each acknowledgement, as it arrives.
+		OnResponse func(SocketId, []any)
+		// Called with each acknowledgement, as it arrives, along with the error reported by the adapter if any.
+		OnResult func(*AckResult)
+		// Called each time the expected number of servers or sockets is updated.
+		OnProgress func(*AckStreamProgress)
+		// Called once every notified socket acknowledged the event, or upon timeout.
+		OnDone func(*AckStreamSummary)
+	}
+
+	ackStream struct {
+		nsp     Namespace
+		handler *AckStreamHandler
+
+		mu        sync.Mutex
+		progress  AckStreamProgress
+		targets   []SocketId
+		responded *types.Set[SocketId]
+		done      bool
+		timer     *utils.Timer
+	}
+)
+
+var (
+	// Reported when some sockets did not acknowledge an event in time.
+	ErrAckTimeout = errors.New("operation has timed out")
+)
+
+// Emits an event and processes the acknowledgements of the clients as they arrive, instead of waiting for all of
+// them.
+//
+//	io.To("room-101").Timeout(5*time.Second).EmitWithAckStream("poll", "yes or no?")(&socket.AckStreamHandler{
+//		OnResponse: func(id socket.SocketId, args []any) {
+//			fmt.Println(id, args)
+//		},
+//		OnDone: func(summary *socket.AckStreamSummary) {
+//			fmt.Println(summary.NonResponders)
+//		},
+//	})
+//
+// Param: ev - the event name
+//
+// Param: args - the arguments
+//
+// Return: a function which emits the event with the given handler
+func (b *BroadcastOperator) EmitWithAckStream(ev string, args ...any) func(*AckStreamHandler) {
+	return func(handler *AckStreamHandler) {
+		if handler == nil {
+			handler = &AckStreamHandler{}
+		}
+		stream := &ackStream{
+			nsp:       b.adapter.Nsp(),
+			handler:   handler,
+			responded: types.NewSet[SocketId](),
+		}
+		stream.progress.ExpectedServerCount = -1
+
+		if SOCKET_RESERVED_EVENTS.Has(ev) {
+			stream.finish(errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev)))
+			return
+		}
+
+		opts, err := b.targetOptions()
+		if err != nil {
+			stream.finish(err)
+			return
+		}
+		data := append([]any{ev}, args...)
+		b.appendHistory(data)
+		if opts == nil {
+			stream.finish(nil)
+			return
+		}
+
+		var timeout time.Duration
+		if time := b.flags.Timeout; time != nil {
+			timeout = *time
+		}
+		stream.mu.Lock()
+		stream.timer = utils.SetTimeout(func() {
+			stream.finish(ErrAckTimeout)
+		}, timeout)
+		stream.mu.Unlock()
+
+		broadcastWithSocketAck(b.adapter, &parser.Packet{
+			Type: parser.EVENT,
+			Data: data,
+		}, opts, stream.ontargets, stream.onresponse)
+
+		stream.mu.Lock()
+		stream.progress.ExpectedServerCount = b.adapter.ServerCount()
+		stream.notifyProgress()
+		stream.check()
+		stream.mu.Unlock()
+	}
+}
+
+// Emits an event and waits for an acknowledgement from all clients, like [BroadcastOperator.EmitWithAck], but each
+// acknowledgement is attributed to the socket which sent it. Upon timeout, the sockets which did not answer are
+// included with the [ErrAckTimeout] error.
+//
+// With a cluster adapter, the acknowledgements of the sockets connected to the other servers are included, provided
+// the adapter implements [SocketAckAdapter].
+//
+//	io.Timeout(5*time.Second).EmitWithAckResults("vote", "yes or no?")(func(results []*socket.AckResult, err error) {
+//		for _, result := range results {
+//			if result.Err == nil {
+//				fmt.Println(result.SocketId, result.Args)
+//			}
+//		}
+//	})
+//
+// Param: ev - the event name
+//
+// Param: args - the arguments
+//
+// Return: a function which emits the event with the given callback
+func (b *BroadcastOperator) EmitWithAckResults(ev string, args ...any) func(func([]*AckResult, error)) {
+	return func(ack func([]*AckResult, error)) {
+		// the callbacks of the stream are never called concurrently
+		results := []*AckResult{}
+		b.EmitWithAckStream(ev, args...)(&AckStreamHandler{
+			OnResult: func(result *AckResult) {
+				results = append(results, result)
+			},
+			OnDone: func(summary *AckStreamSummary) {
+				for _, id := range summary.NonResponders {
+					results = append(results, NewAckResult(id, nil, summary.Err))
+				}
+				ack(results, summary.Err)
+			},
+		})
+	}
+}
+
+// Returns the acknowledgement of a socket, with Error set to the message of err.
+func NewAckResult(id SocketId, args []any, err error) *AckResult {
+	r := &AckResult{SocketId: id, Args: args, Err: err}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Returns the error of the acknowledgement, which is rebuilt from Error if the result was deserialized.
+func (r *AckResult) GetErr() error {
+	if r.Err == nil && r.Error != "" {
+		return errors.New(r.Error)
+	}
+	return r.Err
+}
+
+// Called by each Socket.IO server of the cluster, with the sockets which were notified.
+func (s *ackStream) ontargets(sids []SocketId) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return
+	}
+	s.targets = append(s.targets, sids...)
+	s.progress.ServerCount++
+	s.progress.ExpectedClientCount += uint64(len(sids))
+	s.notifyProgress()
+	s.check()
+}
+
+// Called with each acknowledgement, the id is empty if the adapter does not attribute the acknowledgements (see
+// [SocketAckAdapter]).
+func (s *ackStream) onresponse(id SocketId, args []any, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done || (id != "" && s.responded.Has(id)) {
+		return
+	}
+	if id != "" {
+		s.responded.Add(id)
+	}
+	s.progress.ResponseCount++
+	if s.handler.OnResponse != nil {
+		s.call(func() { s.handler.OnResponse(id, args) })
+	}
+	if s.handler.OnResult != nil {
+		s.call(func() { s.handler.OnResult(NewAckResult(id, args, err)) })
+	}
+	s.check()
+}
+
+// Must be called with the lock held.
+func (s *ackStream) notifyProgress() {
+	if s.handler.OnProgress != nil {
+		progress := s.progress
+		s.call(func() { s.handler.OnProgress(&progress) })
+	}
+}
+
+// Completes the stream once every server reported its sockets and every socket acknowledged the event.
+//
+// Must be called with the lock held.
+func (s *ackStream) check() {
+	if s.done || s.progress.ExpectedServerCount != s.progress.ServerCount || s.progress.ResponseCount != s.progress.ExpectedClientCount {
+		return
+	}
+	s.complete(nil)
+}
+
+func (s *ackStream) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.complete(err)
+}
+
+// Must be called with the lock held.
+func (s *ackStream) complete(err error) {
+	if s.done {
+		return
+	}
+	s.done = true
+	if s.timer != nil {
+		utils.ClearTimeout(s.timer)
+	}
+
+	summary := &AckStreamSummary{
+		AckStreamProgress: s.progress,
+		NonResponders:     []SocketId{},
+		Err:               err,
+	}
+	for _, id := range s.targets {
+		// the sockets notified by an adapter which does not attribute the acknowledgements are unknown
+		if id != "" && !s.responded.Has(id) {
+			summary.NonResponders = append(summary.NonResponders, id)
+		}
+	}
+	if s.handler.OnDone != nil {
+		s.call(func() { s.handler.OnDone(summary) })
+	}
+}
+
+// Calls a callback of the handler. The callbacks run on the goroutine of the timer or of the adapter, so their panics
+// are recovered there.
+func (s *ackStream) call(callback func()) {
+	defer recoverNamespacePanic(s.nsp, "ack")
+
+	callback()
+}
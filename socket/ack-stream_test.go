@@ -0,0 +1,36 @@
+package socket
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAckResultSerialization(t *testing.T) {
+	data, err := json.Marshal(NewAckResult("a", nil, ErrAckTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result *AckResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.SocketId != "a" {
+		t.Fatalf("unexpected socket id %q", result.SocketId)
+	}
+	if err := result.GetErr(); err == nil || err.Error() != ErrAckTimeout.Error() {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	data, err = json.Marshal(NewAckResult("b", []any{"yes"}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = nil
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if err := result.GetErr(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package socket
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/zishang520/engine.io-go-parser/packet"
@@ -21,11 +23,24 @@ type (
 	// Related: https://github.com/socketio/socket.io-redis-adapter/issues/418
 	Room string
 
+	// At most one packet emitted with a given key is written to a socket per window: the first packet is written
+	// right away, and only the last of the following ones once the window expires, the previous ones being discarded.
+	CoalesceOptions struct {
+		Key    string        `json:"key" mapstructure:"key" msgpack:"key"`
+		Window time.Duration `json:"window" mapstructure:"window" msgpack:"window"`
+	}
+
 	WriteOptions struct {
 		packet.Options
 
 		Volatile   bool `json:"volatile" mapstructure:"volatile" msgpack:"volatile"`
 		PreEncoded bool `json:"preEncoded" mapstructure:"preEncoded" msgpack:"preEncoded"`
+
+		Coalesce *CoalesceOptions `json:"coalesce,omitempty" mapstructure:"coalesce,omitempty" msgpack:"coalesce,omitempty"`
+
+		// whether the packet is written by a worker of the fan-out executor, which must not call user code (the
+		// slow consumer events are then emitted on another goroutine)
+		fromExecutor bool
 	}
 
 	BroadcastFlags struct {
@@ -37,12 +52,50 @@ type (
 		Timeout   *time.Duration `json:"timeout,omitempty" mapstructure:"timeout,omitempty" msgpack:"timeout,omitempty"`
 
 		ExpectSingleResponse bool `json:"expectSingleResponse" mapstructure:"expectSingleResponse" msgpack:"expectSingleResponse"`
+
+		// Whether the event is retained in the history of the targeted rooms
+		History bool `json:"history,omitempty" mapstructure:"history,omitempty" msgpack:"history,omitempty"`
 	}
 
 	BroadcastOptions struct {
 		Rooms  *types.Set[Room]
 		Except *types.Set[Room]
 		Flags  *BroadcastFlags `json:"flags,omitempty" mapstructure:"flags,omitempty" msgpack:"flags,omitempty"`
+		// Rooms that every targeted socket must have joined (intersection)
+		InAll *types.Set[Room] `json:"inAll,omitempty" mapstructure:"inAll,omitempty" msgpack:"inAll,omitempty"`
+		// Room patterns (e.g. "user:*") to broadcast to, in addition to `Rooms`
+		RoomPatterns []string `json:"roomPatterns,omitempty" mapstructure:"roomPatterns,omitempty" msgpack:"roomPatterns,omitempty"`
+		// Room patterns whose sockets should be excluded, in addition to `Except`
+		ExceptPatterns []string `json:"exceptPatterns,omitempty" mapstructure:"exceptPatterns,omitempty" msgpack:"exceptPatterns,omitempty"`
+
+		// Serializable conditions that every targeted socket must satisfy, shipped to the other nodes of the cluster
+		Predicates []*SocketPredicate `json:"predicates,omitempty" mapstructure:"predicates,omitempty" msgpack:"predicates,omitempty"`
+		// A condition that every targeted socket must satisfy, only evaluated on the current node
+		Filter func(SocketDetails) bool `json:"-" mapstructure:"-" msgpack:"-"`
+	}
+
+	// Metadata attached to a room, independently of its members.
+	RoomMeta struct {
+		// The creator of the room
+		Owner string `json:"owner,omitempty" mapstructure:"owner,omitempty" msgpack:"owner,omitempty"`
+		// The topic of the room
+		Topic string `json:"topic,omitempty" mapstructure:"topic,omitempty" msgpack:"topic,omitempty"`
+		// The maximum number of sockets in the room (0 means no limit)
+		MaxMembers int `json:"maxMembers,omitempty" mapstructure:"maxMembers,omitempty" msgpack:"maxMembers,omitempty"`
+		// The date of creation (as unix timestamp in milliseconds)
+		CreatedAt int64 `json:"createdAt" mapstructure:"createdAt" msgpack:"createdAt"`
+		// Additional application-specific information
+		Data any `json:"data,omitempty" mapstructure:"data,omitempty" msgpack:"data,omitempty"`
+	}
+
+	// A hook which is called before a socket joins a room, returning an error rejects the join. The metadata is nil
+	// when none was registered for the room.
+	JoinGuard func(SocketDetails, Room, *RoomMeta) error
+
+	// The error returned by [Socket.JoinE] when a room cannot be joined.
+	JoinError struct {
+		Room Room
+		Err  error
 	}
 
 	SessionToPersist struct {
@@ -50,6 +103,9 @@ type (
 		Pid   PrivateSessionId `json:"pid" mapstructure:"pid" msgpack:"pid"`
 		Rooms *types.Set[Room]
 		Data  any `json:"data" mapstructure:"data" msgpack:"data"`
+		// The handshake of the socket, so that the broadcasts filtered on it (see [BroadcastOperator.Where]) can be
+		// replayed to the recovered session.
+		Handshake *Handshake `json:"handshake,omitempty" mapstructure:"handshake,omitempty" msgpack:"handshake,omitempty"`
 	}
 
 	Session struct {
@@ -79,6 +135,8 @@ type (
 		Prototype(Adapter)
 		Proto() Adapter
 
+		// Returns the rooms and their members. The in-memory adapter returns a copy of its (sharded) index, built upon
+		// each call, see [Adapter.Sockets] and [Adapter.SocketRooms] for the hot paths.
 		Rooms() *types.Map[Room, *types.Set[SocketId]]
 		Sids() *types.Map[SocketId, *types.Set[Room]]
 		Nsp() Namespace
@@ -110,6 +168,9 @@ type (
 		//  - `Flags` {*BroadcastFlags} flags for this packet
 		//  - `Except` {*types.Set[Room]} sids that should be excluded
 		//  - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+		//  - `InAll` {*types.Set[Room]} list of rooms every recipient must have joined
+		//  - `RoomPatterns` {[]string} list of room patterns to broadcast to
+		//  - `ExceptPatterns` {[]string} list of room patterns that should be excluded
 		Broadcast(*parser.Packet, *BroadcastOptions)
 
 		// Broadcasts a packet and expects multiple acknowledgements.
@@ -118,6 +179,9 @@ type (
 		//  - `Flags` {*BroadcastFlags} flags for this packet
 		//  - `Except` {*types.Set[Room]} sids that should be excluded
 		//  - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+		//  - `InAll` {*types.Set[Room]} list of rooms every recipient must have joined
+		//  - `RoomPatterns` {[]string} list of room patterns to broadcast to
+		//  - `ExceptPatterns` {[]string} list of room patterns that should be excluded
 		BroadcastWithAck(*parser.Packet, *BroadcastOptions, func(uint64), func([]any, error))
 
 		// Gets a list of sockets by sid.
@@ -126,6 +190,9 @@ type (
 		// Gets the list of rooms a given socket has joined.
 		SocketRooms(SocketId) *types.Set[Room]
 
+		// Gets the list of rooms matching the given patterns (e.g. "user:*" or "doc:?:cursor").
+		MatchRooms(...string) *types.Set[Room]
+
 		// Returns the matching socket instances
 		FetchSockets(*BroadcastOptions) func(func([]SocketDetails, error))
 
@@ -148,6 +215,64 @@ type (
 		RestoreSession(PrivateSessionId, string) (*Session, error)
 	}
 
+	// An [Adapter] which attributes the acknowledgements of a broadcast to the sockets which sent them, see
+	// [BroadcastOperator.EmitWithAckStream].
+	//
+	// With the adapters which do not implement it, the packet is broadcast with [Adapter.BroadcastWithAck], and the
+	// acknowledgements are reported with an empty socket id.
+	SocketAckAdapter interface {
+		// Broadcasts a packet and expects multiple acknowledgements, which are attributed to the sockets.
+		//
+		// The first callback is called once per Socket.IO server of the cluster, with the ids of the sockets which
+		// were notified, and the second one is called for each acknowledgement.
+		//
+		// A cluster adapter must forward the packet to the other servers, and relay the ids of their notified
+		// sockets and their acknowledgements (see [AckResult]) to the callbacks, so that the acknowledgements can be
+		// attributed.
+		BroadcastWithSocketAck(*parser.Packet, *BroadcastOptions, func([]SocketId), func(SocketId, []any, error))
+	}
+
+	// An [Adapter] which makes the matching socket instances leave the rooms matching patterns, the patterns being
+	// resolved against the rooms of each socket by the server which owns it (a cluster adapter must forward the
+	// operation to the other servers).
+	//
+	// With the adapters which do not implement it, the patterns are resolved against the rooms known to the current
+	// server.
+	RoomPatternAdapter interface {
+		// Makes the matching socket instances leave the rooms matching the specified patterns
+		DelSocketsPattern(*BroadcastOptions, []string)
+	}
+
+	// An [Adapter] which keeps a registry of the metadata of the rooms, enforcing their capacity along with the addition
+	// of the sockets (a cluster adapter must replicate the metadata to the other servers).
+	//
+	// With the adapters which do not implement it, the metadata is kept by the namespace on the current server only,
+	// and the capacity of the rooms is checked before the addition, which is not atomic.
+	RoomRegistryAdapter interface {
+		// Attaches metadata to a room.
+		SetRoomMeta(Room, *RoomMeta)
+
+		// Gets the metadata of a room.
+		RoomMeta(Room) (*RoomMeta, bool)
+
+		// Removes the metadata of a room.
+		DelRoomMeta(Room)
+
+		// Checks whether a socket is allowed to join a room (capacity and join guard).
+		CheckJoin(SocketDetails, Room) error
+	}
+
+	// An [Adapter] which honours the `InAll`, `RoomPatterns` and `ExceptPatterns` options of the operations (a cluster
+	// adapter must forward them to the other servers).
+	//
+	// With the adapters which do not implement it, the patterns are resolved against the rooms known to the current
+	// server, and the operations with the `InAll` option are rejected with [ErrRoomTargetingUnsupported], so that they
+	// are never widened to the whole namespace.
+	RoomTargetingAdapter interface {
+		// Whether the `InAll`, `RoomPatterns` and `ExceptPatterns` options are honoured.
+		SupportsRoomTargeting() bool
+	}
+
 	SessionAwareAdapter interface {
 		Adapter
 	}
@@ -160,3 +285,19 @@ type (
 		New(Namespace) Adapter
 	}
 )
+
+var (
+	ErrRoomFull = errors.New("room is full")
+
+	// Returned by the operations targeting the sockets which have joined all the given rooms (see
+	// [BroadcastOperator.InAll]) when the adapter does not implement [RoomTargetingAdapter].
+	ErrRoomTargetingUnsupported = errors.New("the adapter does not support the InAll option")
+)
+
+func (e *JoinError) Error() string {
+	return fmt.Sprintf(`cannot join room "%s": %v`, e.Room, e.Err)
+}
+
+func (e *JoinError) Unwrap() error {
+	return e.Err
+}
@@ -2,14 +2,27 @@ package socket
 
 import (
 	"sync/atomic"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	_types "github.com/zishang520/engine.io-go-parser/types"
 	"github.com/zishang520/engine.io/v2/events"
+	"github.com/zishang520/engine.io/v2/log"
 	"github.com/zishang520/engine.io/v2/types"
 	"github.com/zishang520/engine.io/v2/utils"
 	"github.com/zishang520/socket.io-go-parser/v2/parser"
 )
 
+var adapter_log = log.NewLog("socket.io:adapter")
+
+// The number of broadcasts of an adapter which can dedup their recipients with epoch stamps concurrently, see
+// [adapter.collect].
+const adapterDedupSlots = 8
+
+// The server-side event carrying the metadata of the rooms between the Socket.IO servers of the cluster, see
+// [RoomRegistryAdapter.SetRoomMeta].
+const ROOM_META_EVENT = "socket.io:room-meta"
+
 type (
 	AdapterBuilder struct {
 		AdapterConstructor
@@ -22,9 +35,14 @@ type (
 		_proto_ Adapter
 
 		nsp     Namespace
-		rooms   *types.Map[Room, *types.Set[SocketId]]
+		rooms   *roomIndex
 		sids    *types.Map[SocketId, *types.Set[Room]]
+		metas   *types.Map[Room, *RoomMeta]
 		encoder parser.Encoder
+
+		// the epoch of the last broadcast targeting several rooms, and the free dedup slots, see [adapter.collect]
+		epoch      atomic.Uint64
+		dedupSlots chan int
 	}
 )
 
@@ -36,8 +54,14 @@ func MakeAdapter() Adapter {
 	a := &adapter{
 		EventEmitter: events.New(),
 
-		rooms: &types.Map[Room, *types.Set[SocketId]]{},
+		rooms: newRoomIndex(),
 		sids:  &types.Map[SocketId, *types.Set[Room]]{},
+		metas: &types.Map[Room, *RoomMeta]{},
+
+		dedupSlots: make(chan int, adapterDedupSlots),
+	}
+	for slot := 0; slot < adapterDedupSlots; slot++ {
+		a.dedupSlots <- slot
 	}
 
 	a.Prototype(a)
@@ -61,8 +85,12 @@ func (a *adapter) Proto() Adapter {
 	return a._proto_
 }
 
+// Returns a snapshot of the rooms and of their members.
+//
+// The snapshot is a copy of the whole index, built upon each call: the changes made to it are not applied to the
+// adapter, and it should not be used on hot paths (see [adapter.Sockets] and [adapter.SocketRooms] instead).
 func (a *adapter) Rooms() *types.Map[Room, *types.Set[SocketId]] {
-	return a.rooms
+	return a.rooms.Snapshot()
 }
 
 func (a *adapter) Sids() *types.Map[SocketId, *types.Set[Room]] {
@@ -76,6 +104,8 @@ func (a *adapter) Nsp() Namespace {
 func (a *adapter) Construct(nsp Namespace) {
 	a.nsp = nsp
 	a.encoder = nsp.Server().Encoder()
+
+	a.On(ROOM_META_EVENT, a.onroommeta)
 }
 
 // To be overridden
@@ -91,17 +121,24 @@ func (a *adapter) ServerCount() int64 {
 	return 1
 }
 
-// Adds a socket to a list of room.
+// Adds a socket to a list of room. The rooms which are full (see [RoomMeta.MaxMembers]) are not joined, the capacity
+// being checked along with the addition.
 func (a *adapter) AddAll(id SocketId, rooms *types.Set[Room]) {
 	_rooms, _ := a.sids.LoadOrStore(id, types.NewSet[Room]())
 	for _, room := range rooms.Keys() {
+		maxMembers := 0
+		if meta, ok := a.roomMeta(room); ok && meta != nil && room != Room(id) {
+			maxMembers = meta.MaxMembers
+		}
+		created, added, full := a.rooms.AddBounded(room, id, maxMembers)
+		if full {
+			continue
+		}
 		_rooms.Add(room)
-		ids, ok := a.rooms.LoadOrStore(room, types.NewSet[SocketId]())
-		if !ok {
+		if created {
 			a.Emit("create-room", room)
 		}
-		if !ids.Has(id) {
-			ids.Add(id)
+		if added {
 			a.Emit("join-room", room, id)
 		}
 	}
@@ -116,15 +153,12 @@ func (a *adapter) Del(id SocketId, room Room) {
 }
 
 func (a *adapter) _del(room Room, id SocketId) {
-	if ids, ok := a.rooms.Load(room); ok {
-		if ids.Delete(id) {
-			a.Emit("leave-room", room, id)
-		}
-		if ids.Len() == 0 {
-			if _, ok := a.rooms.LoadAndDelete(room); ok {
-				a.Emit("delete-room", room)
-			}
-		}
+	removed, deleted := a.rooms.Delete(room, id)
+	if removed {
+		a.Emit("leave-room", room, id)
+	}
+	if deleted {
+		a.Emit("delete-room", room)
 	}
 }
 
@@ -144,6 +178,9 @@ func (a *adapter) DelAll(id SocketId) {
 //   - `Flags` {*BroadcastFlags} flags for this packet
 //   - `Except` {*types.Set[Room]} sids that should be excluded
 //   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+//   - `InAll` {*types.Set[Room]} list of rooms every recipient must have joined
+//   - `RoomPatterns` {[]string} list of room patterns to broadcast to
+//   - `ExceptPatterns` {[]string} list of room patterns that should be excluded
 func (a *adapter) Broadcast(packet *parser.Packet, opts *BroadcastOptions) {
 	flags := &BroadcastFlags{}
 	if opts != nil && opts.Flags != nil {
@@ -154,23 +191,49 @@ func (a *adapter) Broadcast(packet *parser.Packet, opts *BroadcastOptions) {
 	packetOpts.PreEncoded = true
 	packetOpts.Volatile = flags.Volatile
 	packetOpts.Compress = flags.Compress
+	packetOpts.Coalesce = flags.Coalesce
+
+	executor := a.nsp.Server().executor
+	packetOpts.fromExecutor = executor != nil
 
 	packet.Nsp = a.nsp.Name()
-	encodedPackets := a._encode(packet, packetOpts)
+	encode := a._encodeBroadcast(packet, packetOpts)
+	write := func(socket *Socket) {
+		encodedPackets, packetOpts := encode(socket.Client())
+		socket.Client().writeToEngine(packet.Nsp, encodedPackets, packetOpts, false)
+	}
+	if executor != nil {
+		batch := executor.batch()
+		a.apply(opts, func(socket *Socket) {
+			// the listeners are called by the broadcasting goroutine, the workers must not call user code
+			notifyOutgoing(socket, packet)
+			batch.Add(socket)
+		})
+		batch.Dispatch(write)
+		return
+	}
 	a.apply(opts, func(socket *Socket) {
-		if notifyOutgoingListeners := socket.NotifyOutgoingListeners(); notifyOutgoingListeners != nil {
-			notifyOutgoingListeners(packet)
-		}
-		socket.Client().WriteToEngine(encodedPackets, packetOpts)
+		notifyOutgoing(socket, packet)
+		write(socket)
 	})
 }
 
+// Calls the listeners of the outgoing packets of the socket, see [Socket.OnAnyOutgoing].
+func notifyOutgoing(socket *Socket, packet *parser.Packet) {
+	if notifyOutgoingListeners := socket.NotifyOutgoingListeners(); notifyOutgoingListeners != nil {
+		notifyOutgoingListeners(packet)
+	}
+}
+
 // Broadcasts a packet and expects multiple acknowledgements.
 //
 // Options:
-//   - `Flags` {*BroadcastFlags} flags for this packet
+//   - `Flags` {*BroadcastFlags} flags for this packet (the `Coalesce` flag is ignored)
 //   - `Except` {*types.Set[Room]} sids that should be excluded
 //   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+//   - `InAll` {*types.Set[Room]} list of rooms every recipient must have joined
+//   - `RoomPatterns` {[]string} list of room patterns to broadcast to
+//   - `ExceptPatterns` {[]string} list of room patterns that should be excluded
 func (a *adapter) BroadcastWithAck(packet *parser.Packet, opts *BroadcastOptions, clientCountCallback func(uint64), ack func([]any, error)) {
 	flags := &BroadcastFlags{}
 	if opts != nil && opts.Flags != nil {
@@ -186,23 +249,109 @@ func (a *adapter) BroadcastWithAck(packet *parser.Packet, opts *BroadcastOptions
 	// we can use the same id for each packet, since the _ids counter is common (no duplicate)
 	id := a.nsp.Ids()
 	packet.Id = &id
-	encodedPackets := a._encode(packet, packetOpts)
+	executor := a.nsp.Server().executor
+	packetOpts.fromExecutor = executor != nil
+	encode := a._encodeBroadcast(packet, packetOpts)
+	write := func(socket *Socket) {
+		socket.Client().WriteToEngine(encode(socket.Client()))
+	}
+	if executor != nil {
+		batch := executor.batch()
+		a.apply(opts, func(socket *Socket) {
+			// call the ack callback for each client response
+			socket.Acks().Store(*packet.Id, ack)
+			notifyOutgoing(socket, packet)
+			batch.Add(socket)
+		})
+		// the total number of acknowledgements that are expected
+		clientCountCallback(uint64(batch.Len()))
+		batch.Dispatch(write)
+		return
+	}
 	var clientCount atomic.Uint64
 	a.apply(opts, func(socket *Socket) {
 		// track the total number of acknowledgements that are expected
 		clientCount.Add(1)
-		// call the ack callback for each client response
 		socket.Acks().Store(*packet.Id, ack)
-		if notifyOutgoingListeners := socket.NotifyOutgoingListeners(); notifyOutgoingListeners != nil {
-			notifyOutgoingListeners(packet)
-		}
-		socket.Client().WriteToEngine(encodedPackets, packetOpts)
+		notifyOutgoing(socket, packet)
+		write(socket)
 	})
 	clientCountCallback(clientCount.Load())
 }
 
-func (a *adapter) _encode(packet *parser.Packet, packetOpts *WriteOptions) []_types.BufferInterface {
-	encodedPackets := a.encoder.Encode(packet)
+// Broadcasts a packet and expects multiple acknowledgements, which are attributed to the sockets.
+//
+// Options:
+//   - `Flags` {*BroadcastFlags} flags for this packet (the `Coalesce` flag is ignored)
+//   - `Except` {*types.Set[Room]} sids that should be excluded
+//   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+//   - `InAll` {*types.Set[Room]} list of rooms every recipient must have joined
+//   - `RoomPatterns` {[]string} list of room patterns to broadcast to
+//   - `ExceptPatterns` {[]string} list of room patterns that should be excluded
+func (a *adapter) BroadcastWithSocketAck(packet *parser.Packet, opts *BroadcastOptions, targetsCallback func([]SocketId), ack func(SocketId, []any, error)) {
+	flags := &BroadcastFlags{}
+	if opts != nil && opts.Flags != nil {
+		flags = opts.Flags
+	}
+
+	packetOpts := &WriteOptions{}
+	packetOpts.PreEncoded = true
+	packetOpts.Volatile = flags.Volatile
+	packetOpts.Compress = flags.Compress
+
+	packet.Nsp = a.nsp.Name()
+	// we can use the same id for each packet, since the _ids counter is common (no duplicate)
+	id := a.nsp.Ids()
+	packet.Id = &id
+	executor := a.nsp.Server().executor
+	packetOpts.fromExecutor = executor != nil
+	encode := a._encodeBroadcast(packet, packetOpts)
+	sids := []SocketId{}
+	register := func(socket *Socket) {
+		sid := socket.Id()
+		sids = append(sids, sid)
+		socket.Acks().Store(*packet.Id, func(args []any, err error) {
+			ack(sid, args, err)
+		})
+		notifyOutgoing(socket, packet)
+	}
+	write := func(socket *Socket) {
+		socket.Client().WriteToEngine(encode(socket.Client()))
+	}
+	if executor != nil {
+		batch := executor.batch()
+		a.apply(opts, func(socket *Socket) {
+			register(socket)
+			batch.Add(socket)
+		})
+		targetsCallback(sids)
+		batch.Dispatch(write)
+		return
+	}
+	a.apply(opts, func(socket *Socket) {
+		register(socket)
+		write(socket)
+	})
+	targetsCallback(sids)
+}
+
+// Broadcasts a packet and expects multiple acknowledgements, which are attributed to the sockets if the adapter
+// implements [SocketAckAdapter]. Otherwise, the notified sockets and their acknowledgements are reported with an
+// empty socket id.
+func broadcastWithSocketAck(adapter Adapter, packet *parser.Packet, opts *BroadcastOptions, targetsCallback func([]SocketId), ack func(SocketId, []any, error)) {
+	if socketAckAdapter, ok := adapter.(SocketAckAdapter); ok {
+		socketAckAdapter.BroadcastWithSocketAck(packet, opts, targetsCallback, ack)
+		return
+	}
+	adapter.BroadcastWithAck(packet, opts, func(clientCount uint64) {
+		targetsCallback(make([]SocketId, clientCount))
+	}, func(args []any, err error) {
+		ack("", args, err)
+	})
+}
+
+func (a *adapter) _encode(encoder parser.Encoder, packet *parser.Packet, packetOpts *WriteOptions) []_types.BufferInterface {
+	encodedPackets := encoder.Encode(packet)
 
 	if len(encodedPackets) == 1 {
 		if p, ok := encodedPackets[0].(*_types.StringBuffer); ok {
@@ -234,6 +383,131 @@ func (a *adapter) SocketRooms(id SocketId) *types.Set[Room] {
 	return nil
 }
 
+// Gets the list of rooms matching the given patterns (e.g. "user:*" or "doc:?:cursor").
+func (a *adapter) MatchRooms(patterns ...string) *types.Set[Room] {
+	rooms := types.NewSet[Room]()
+	for _, pattern := range patterns {
+		rooms.Add(a.rooms.Match(pattern)...)
+	}
+	return rooms
+}
+
+// Attaches metadata to a room. The metadata is kept until [RoomRegistryAdapter.DelRoomMeta] is called, even if the room has no
+// member.
+//
+// The metadata is sent to the other Socket.IO servers of the cluster with [Adapter.ServerSideEmit], so the capacity of
+// the room applies on every server (to the members of the room on that server).
+func (a *adapter) SetRoomMeta(room Room, meta *RoomMeta) {
+	if meta == nil {
+		if registry, ok := a.Proto().(RoomRegistryAdapter); ok {
+			registry.DelRoomMeta(room)
+		} else {
+			a.DelRoomMeta(room)
+		}
+		return
+	}
+	if meta.CreatedAt == 0 {
+		meta.CreatedAt = time.Now().UnixMilli()
+	}
+	a.storeRoomMeta(room, meta)
+	a.replicateRoomMeta(room, meta)
+}
+
+func (a *adapter) storeRoomMeta(room Room, meta *RoomMeta) {
+	if meta == nil {
+		if _, ok := a.metas.LoadAndDelete(room); ok {
+			a.Emit("del-room-meta", room)
+		}
+		return
+	}
+	a.metas.Store(room, meta)
+	a.Emit("set-room-meta", room, meta)
+}
+
+// Sends the metadata of a room (nil once removed) to the other Socket.IO servers of the cluster.
+func (a *adapter) replicateRoomMeta(room Room, meta *RoomMeta) {
+	if a.Proto().ServerCount() <= 1 {
+		return
+	}
+	if err := a.Proto().ServerSideEmit([]any{ROOM_META_EVENT, room, meta}); err != nil {
+		adapter_log.Debug("error while sending the metadata of room %s: %v", room, err)
+	}
+}
+
+// Stores the metadata of a room received from another Socket.IO server, see [Namespace.OnServerSideEmit].
+func (a *adapter) onroommeta(args ...any) {
+	if len(args) < 2 {
+		return
+	}
+	var room Room
+	switch r := args[0].(type) {
+	case Room:
+		room = r
+	case string:
+		room = Room(r)
+	default:
+		adapter_log.Debug("invalid room metadata: %v", args)
+		return
+	}
+	var meta *RoomMeta
+	switch m := args[1].(type) {
+	case nil:
+	case *RoomMeta:
+		meta = m
+	default:
+		meta = &RoomMeta{}
+		if err := mapstructure.Decode(m, meta); err != nil {
+			adapter_log.Debug("invalid metadata of room %s: %v", room, err)
+			return
+		}
+	}
+	a.storeRoomMeta(room, meta)
+}
+
+// Gets the metadata of a room.
+func (a *adapter) RoomMeta(room Room) (*RoomMeta, bool) {
+	return a.metas.Load(room)
+}
+
+// Removes the metadata of a room, on every Socket.IO server of the cluster.
+func (a *adapter) DelRoomMeta(room Room) {
+	a.storeRoomMeta(room, nil)
+	a.replicateRoomMeta(room, nil)
+}
+
+// Checks whether a socket is allowed to join a room, based on the capacity of the room and on the [JoinGuard] of the
+// namespace.
+func (a *adapter) CheckJoin(socket SocketDetails, room Room) error {
+	if a.rooms.Has(room, socket.Id()) {
+		// already a member
+		return nil
+	}
+	meta, _ := a.roomMeta(room)
+	size, _ := a.rooms.Len(room)
+	return checkRoomJoin(a.nsp, socket, room, meta, size)
+}
+
+// Gets the metadata of a room through the prototype, which may override the registry.
+func (a *adapter) roomMeta(room Room) (*RoomMeta, bool) {
+	if registry, ok := a.Proto().(RoomRegistryAdapter); ok {
+		return registry.RoomMeta(room)
+	}
+	return a.RoomMeta(room)
+}
+
+// Checks whether a socket which is not a member of a room (of the given size) is allowed to join it.
+func checkRoomJoin(nsp Namespace, socket SocketDetails, room Room, meta *RoomMeta, size int) error {
+	if meta != nil && meta.MaxMembers > 0 && size >= meta.MaxMembers {
+		return &JoinError{Room: room, Err: ErrRoomFull}
+	}
+	if guard := nsp.JoinGuard(); guard != nil {
+		if err := guard(socket, room, meta); err != nil {
+			return &JoinError{Room: room, Err: err}
+		}
+	}
+	return nil
+}
+
 // Returns the matching socket instances
 func (a *adapter) FetchSockets(opts *BroadcastOptions) func(func([]SocketDetails, error)) {
 	return func(callback func([]SocketDetails, error)) {
@@ -261,6 +535,75 @@ func (a *adapter) DelSockets(opts *BroadcastOptions, rooms []Room) {
 	})
 }
 
+// Makes the matching socket instances leave the rooms matching the specified patterns
+func (a *adapter) DelSocketsPattern(opts *BroadcastOptions, patterns []string) {
+	a.apply(opts, func(socket *Socket) {
+		for _, room := range socket.Rooms().Keys() {
+			if matchAnyRoomPattern(patterns, room) {
+				socket.Leave(room)
+			}
+		}
+	})
+}
+
+// Makes the matching socket instances leave the rooms matching the patterns, through the adapter if it resolves the
+// patterns itself (see [RoomPatternAdapter]).
+func delSocketsPattern(adapter Adapter, opts *BroadcastOptions, patterns []string) {
+	if patternAdapter, ok := adapter.(RoomPatternAdapter); ok {
+		patternAdapter.DelSocketsPattern(opts, patterns)
+		return
+	}
+	if rooms := adapter.MatchRooms(patterns...); rooms.Len() > 0 {
+		adapter.DelSockets(opts, rooms.Keys())
+	}
+}
+
+// The in-memory adapter honours the `InAll`, `RoomPatterns` and `ExceptPatterns` options.
+func (a *adapter) SupportsRoomTargeting() bool {
+	return true
+}
+
+func supportsRoomTargeting(adapter Adapter) bool {
+	targetingAdapter, ok := adapter.(RoomTargetingAdapter)
+	return ok && targetingAdapter.SupportsRoomTargeting()
+}
+
+// Returns the options of an operation in a form the given adapter understands.
+//
+// With the adapters which do not implement [RoomTargetingAdapter], the `RoomPatterns` and `ExceptPatterns` options
+// are resolved against the rooms known to the current server, and the returned options are nil if the patterns do not
+// match any room (the operation must then be skipped). The `InAll` option cannot be resolved this way, so the
+// operation is rejected.
+func resolveRoomTargets(adapter Adapter, opts *BroadcastOptions) (*BroadcastOptions, error) {
+	if supportsRoomTargeting(adapter) {
+		return opts, nil
+	}
+	if opts.InAll != nil && opts.InAll.Len() > 0 {
+		return nil, ErrRoomTargetingUnsupported
+	}
+	if len(opts.RoomPatterns) == 0 && len(opts.ExceptPatterns) == 0 {
+		return opts, nil
+	}
+
+	resolved := *opts
+	resolved.RoomPatterns = nil
+	resolved.ExceptPatterns = nil
+	if len(opts.RoomPatterns) > 0 {
+		rooms := types.NewSet(roomKeys(opts.Rooms)...)
+		rooms.Add(roomKeys(adapter.MatchRooms(opts.RoomPatterns...))...)
+		if rooms.Len() == 0 {
+			return nil, nil
+		}
+		resolved.Rooms = rooms
+	}
+	if len(opts.ExceptPatterns) > 0 {
+		except := types.NewSet(roomKeys(opts.Except)...)
+		except.Add(roomKeys(adapter.MatchRooms(opts.ExceptPatterns...))...)
+		resolved.Except = except
+	}
+	return &resolved, nil
+}
+
 // Makes the matching socket instances disconnect
 func (a *adapter) DisconnectSockets(opts *BroadcastOptions, status bool) {
 	a.apply(opts, func(socket *Socket) {
@@ -268,47 +611,176 @@ func (a *adapter) DisconnectSockets(opts *BroadcastOptions, status bool) {
 	})
 }
 
+// Calls the callback for each matching socket.
+//
+// The matching sockets are first collected in a pooled buffer (the locks of the room index are released before any
+// callback is called, so the callbacks are free to join or leave rooms), which means a broadcast does not allocate
+// anything in the common case.
 func (a *adapter) apply(opts *BroadcastOptions, callback func(*Socket)) {
 	rooms := opts.Rooms
-	except := a.computeExceptSids(opts.Except)
-	if rooms != nil && rooms.Len() > 0 {
-		ids := types.NewSet[SocketId]()
-		for _, room := range rooms.Keys() {
-			if _ids, ok := a.rooms.Load(room); ok {
-				for _, id := range _ids.Keys() {
-					if ids.Has(id) || except.Has(id) {
-						continue
-					}
-					if socket, ok := a.nsp.Sockets().Load(id); ok {
-						callback(socket)
-						ids.Add(id)
-					}
+	if len(opts.RoomPatterns) > 0 {
+		rooms = a.Proto().MatchRooms(opts.RoomPatterns...)
+		if opts.Rooms != nil {
+			rooms.Add(opts.Rooms.Keys()...)
+		}
+		if rooms.Len() == 0 {
+			// no room matches the patterns
+			return
+		}
+	}
+	except := roomKeys(a.computeExceptRooms(opts.Except, opts.ExceptPatterns))
+
+	buffer := a.rooms.getBuffer()
+	defer a.rooms.putBuffer(buffer)
+
+	sockets := a.collect(roomKeys(rooms), roomKeys(opts.InAll), except, (*buffer)[:0])
+	// keep the grown buffer for the next broadcasts
+	*buffer = sockets
+
+	for _, socket := range sockets {
+		if opts.Matches(socket) {
+			callback(socket)
+		}
+	}
+}
+
+// Returns the socket of a room member, once it is tracked by the namespace.
+func (a *adapter) resolve(member *roomMember) *Socket {
+	if socket := member.socket.Load(); socket != nil {
+		return socket
+	}
+	if socket, ok := a.nsp.Sockets().Load(member.id); ok {
+		member.socket.Store(socket)
+		return socket
+	}
+	return nil
+}
+
+// Appends the targeted sockets to the buffer: the members of the given rooms (or every socket if there is no room),
+// which have joined every room of `inAll`, and none of the `except` rooms.
+//
+// A socket which is in several of the rooms must only be appended once: instead of keeping track of the appended (and
+// excluded) sockets in a set, each broadcast gets a new epoch which is stamped on the excluded sockets first, and then
+// on the sockets as they are appended, so that a socket already bearing the current epoch is skipped.
+//
+// A socket holds one stamp per dedup slot of the adapter, and each of these broadcasts holds a slot while collecting
+// the sockets, so that up to [adapterDedupSlots] broadcasts run concurrently. When every slot is in use, the broadcast
+// keeps track of the sockets in a set instead.
+func (a *adapter) collect(rooms []Room, inAll []Room, except []Room, sockets []*Socket) []*Socket {
+	var unseen func(*Socket) bool
+	if len(except) > 0 || (len(rooms) > 1 && len(inAll) == 0) {
+		select {
+		case slot := <-a.dedupSlots:
+			defer func() { a.dedupSlots <- slot }()
+
+			epoch := a.epoch.Add(1)
+			for _, room := range except {
+				a.rooms.Collect(room, nil, a.resolve, func(socket *Socket) bool {
+					socket.broadcastEpochs[slot].Store(epoch)
+					return false
+				})
+			}
+			unseen = func(socket *Socket) bool {
+				return socket.broadcastEpochs[slot].Swap(epoch) != epoch
+			}
+		default:
+			seen := map[*Socket]struct{}{}
+			for _, room := range except {
+				a.rooms.Collect(room, nil, a.resolve, func(socket *Socket) bool {
+					seen[socket] = struct{}{}
+					return false
+				})
+			}
+			unseen = func(socket *Socket) bool {
+				if _, ok := seen[socket]; ok {
+					return false
 				}
+				seen[socket] = struct{}{}
+				return true
 			}
 		}
-	} else {
+	}
+
+	switch {
+	case len(inAll) > 0:
+		return a.collectInAll(inAll, rooms, sockets, unseen)
+	case len(rooms) > 0:
+		for _, room := range rooms {
+			sockets = a.rooms.Collect(room, sockets, a.resolve, unseen)
+		}
+	default:
 		a.sids.Range(func(id SocketId, _ *types.Set[Room]) bool {
-			if except.Has(id) {
-				return true
-			}
-			if socket, ok := a.nsp.Sockets().Load(id); ok {
-				callback(socket)
+			if socket, ok := a.nsp.Sockets().Load(id); ok && (unseen == nil || unseen(socket)) {
+				sockets = append(sockets, socket)
 			}
 			return true
 		})
 	}
+	return sockets
 }
 
-func (a *adapter) computeExceptSids(exceptRooms *types.Set[Room]) *types.Set[SocketId] {
-	exceptSids := types.NewSet[SocketId]()
-	if exceptRooms != nil && exceptRooms.Len() > 0 {
-		for _, room := range exceptRooms.Keys() {
-			if ids, ok := a.rooms.Load(room); ok {
-				exceptSids.Add(ids.Keys()...)
-			}
+// Appends the sockets which have joined every room of `inAll` (and at least one of the targeted rooms, if any) to the
+// buffer, starting from the smallest room.
+func (a *adapter) collectInAll(inAll []Room, rooms []Room, sockets []*Socket, unseen func(*Socket) bool) []*Socket {
+	var smallest Room
+	smallestSize := -1
+	for _, room := range inAll {
+		size, ok := a.rooms.Len(room)
+		if !ok {
+			// one of the rooms is empty, so is the intersection
+			return sockets
+		}
+		if smallestSize < 0 || size < smallestSize {
+			smallest, smallestSize = room, size
+		}
+	}
+	return a.rooms.Collect(smallest, sockets, a.resolve, func(socket *Socket) bool {
+		socketRooms, ok := a.sids.Load(socket.Id())
+		return ok && hasAllRooms(socketRooms, inAll) && hasAnyRoom(socketRooms, rooms) && (unseen == nil || unseen(socket))
+	})
+}
+
+// Returns the rooms of the set, or nil if there is no set.
+func roomKeys(rooms *types.Set[Room]) []Room {
+	if rooms == nil {
+		return nil
+	}
+	return rooms.Keys()
+}
+
+// Whether the given rooms contain every room of `required`.
+func hasAllRooms(rooms *types.Set[Room], required []Room) bool {
+	for _, room := range required {
+		if !rooms.Has(room) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the given rooms contain at least one room of `candidates` (always true when there is no candidate).
+func hasAnyRoom(rooms *types.Set[Room], candidates []Room) bool {
+	if len(candidates) == 0 {
+		return true
+	}
+	for _, room := range candidates {
+		if rooms.Has(room) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the excluded rooms, including the rooms matching the excluded patterns.
+func (a *adapter) computeExceptRooms(exceptRooms *types.Set[Room], exceptPatterns []string) *types.Set[Room] {
+	if len(exceptPatterns) > 0 {
+		matchingRooms := a.Proto().MatchRooms(exceptPatterns...)
+		if exceptRooms != nil {
+			matchingRooms.Add(exceptRooms.Keys()...)
 		}
+		return matchingRooms
 	}
-	return exceptSids
+	return exceptRooms
 }
 
 // Send a packet to the other Socket.IO servers in the cluster
@@ -0,0 +1,396 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/zishang520/engine.io/v2/types"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+func TestAdapterAddAllMaxMembers(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	nsp.SetRoomMeta("room", &RoomMeta{MaxMembers: 5})
+	adapter := nsp.Adapter()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(id SocketId) {
+			defer wg.Done()
+			adapter.AddAll(id, types.NewSet[Room]("room", Room(id)))
+		}(SocketId(fmt.Sprintf("socket-%d", i)))
+	}
+	wg.Wait()
+
+	if members, _ := adapter.Rooms().Load("room"); members.Len() != 5 {
+		t.Fatalf("expected 5 members, got %d", members.Len())
+	}
+	joined := 0
+	for i := 0; i < 100; i++ {
+		if adapter.SocketRooms(SocketId(fmt.Sprintf("socket-%d", i))).Has("room") {
+			joined++
+		}
+	}
+	if joined != 5 {
+		t.Fatalf("expected 5 sockets with the room, got %d", joined)
+	}
+}
+
+// Returns an adapter with 10k sockets in "room-1", the even ones also in "room-2" and one out of ten in "except".
+func newBenchmarkAdapter(b *testing.B) *adapter {
+	b.Helper()
+
+	nsp := NewServer(nil, nil).Of("/benchmark", nil)
+	a, ok := nsp.Adapter().(*adapter)
+	if !ok {
+		b.Fatalf("unexpected adapter %T", nsp.Adapter())
+	}
+	for i := 0; i < 10_000; i++ {
+		id := SocketId(fmt.Sprintf("socket-%d", i))
+		nsp.Sockets().Store(id, &Socket{id: id})
+		rooms := types.NewSet[Room](Room(id), "room-1")
+		if i%2 == 0 {
+			rooms.Add("room-2")
+		}
+		if i%10 == 0 {
+			rooms.Add("except")
+		}
+		a.AddAll(id, rooms)
+	}
+	return a
+}
+
+func BenchmarkAdapterApplyOneRoom(b *testing.B) {
+	a := newBenchmarkAdapter(b)
+	opts := &BroadcastOptions{Rooms: types.NewSet[Room]("room-1"), Except: types.NewSet[Room]()}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.apply(opts, func(*Socket) {})
+	}
+}
+
+func BenchmarkAdapterApplyTwoRoomsExcept(b *testing.B) {
+	a := newBenchmarkAdapter(b)
+	opts := &BroadcastOptions{Rooms: types.NewSet[Room]("room-1", "room-2"), Except: types.NewSet[Room]("except")}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.apply(opts, func(*Socket) {})
+	}
+}
+
+func BenchmarkAdapterApplyTwoRoomsExceptParallel(b *testing.B) {
+	a := newBenchmarkAdapter(b)
+	opts := &BroadcastOptions{Rooms: types.NewSet[Room]("room-1", "room-2"), Except: types.NewSet[Room]("except")}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a.apply(opts, func(*Socket) {})
+		}
+	})
+}
+
+func TestAdapterApplyDedup(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/dedup", nil)
+	a := nsp.Adapter().(*adapter)
+	for i := 0; i < 100; i++ {
+		id := SocketId(fmt.Sprintf("socket-%d", i))
+		nsp.Sockets().Store(id, &Socket{id: id})
+		rooms := types.NewSet[Room]("room-1")
+		if i%2 == 0 {
+			rooms.Add("room-2")
+		}
+		if i%10 == 0 {
+			rooms.Add("except")
+		}
+		a.AddAll(id, rooms)
+	}
+	opts := &BroadcastOptions{Rooms: types.NewSet[Room]("room-1", "room-2"), Except: types.NewSet[Room]("except")}
+
+	// more concurrent broadcasts than dedup slots, so that some of them keep track of the sockets in a set
+	var wg sync.WaitGroup
+	for i := 0; i < 4*adapterDedupSlots; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				count := 0
+				a.apply(opts, func(*Socket) { count++ })
+				if count != 90 {
+					t.Errorf("expected 90 sockets, got %d", count)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Returns the ids of the sockets matching the options, sorted.
+func applyIds(a *adapter, opts *BroadcastOptions) []SocketId {
+	ids := []SocketId{}
+	a.apply(opts, func(socket *Socket) { ids = append(ids, socket.Id()) })
+	slices.Sort(ids)
+	return ids
+}
+
+func TestAdapterApplyInAll(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/in-all", nil)
+	a := nsp.Adapter().(*adapter)
+	for id, rooms := range map[SocketId][]Room{
+		"a": {"tenant:1", "channel:1"},
+		"b": {"tenant:1", "channel:2"},
+		"c": {"tenant:2", "channel:1"},
+		"d": {"tenant:1", "channel:1", "muted"},
+	} {
+		nsp.Sockets().Store(id, &Socket{id: id})
+		a.AddAll(id, types.NewSet(rooms...))
+	}
+	nsp.SetRoomMeta("empty", &RoomMeta{})
+
+	for _, test := range []struct {
+		name     string
+		rooms    []Room
+		inAll    []Room
+		except   []Room
+		expected []SocketId
+	}{
+		{"intersection", nil, []Room{"tenant:1", "channel:1"}, nil, []SocketId{"a", "d"}},
+		{"single room", nil, []Room{"tenant:1"}, nil, []SocketId{"a", "b", "d"}},
+		{"with except", nil, []Room{"tenant:1", "channel:1"}, []Room{"muted"}, []SocketId{"a"}},
+		{"with rooms", []Room{"channel:1", "channel:2"}, []Room{"tenant:1"}, nil, []SocketId{"a", "b", "d"}},
+		{"empty room", nil, []Room{"tenant:1", "empty"}, nil, []SocketId{}},
+		{"missing room", nil, []Room{"tenant:1", "missing"}, nil, []SocketId{}},
+		{"rooms with missing room", []Room{"channel:1"}, []Room{"missing"}, nil, []SocketId{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ids := applyIds(a, &BroadcastOptions{
+				Rooms:  types.NewSet(test.rooms...),
+				Except: types.NewSet(test.except...),
+				InAll:  types.NewSet(test.inAll...),
+			})
+			if !slices.Equal(ids, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
+
+func TestAdapterApplyRoomPatterns(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/room-patterns", nil)
+	a := nsp.Adapter().(*adapter)
+	for id, rooms := range map[SocketId][]Room{
+		"a": {"doc:1:cursor"},
+		"b": {"doc:12:cursor", "muted"},
+		"c": {"user:1"},
+	} {
+		nsp.Sockets().Store(id, &Socket{id: id})
+		a.AddAll(id, types.NewSet(rooms...))
+	}
+
+	for _, test := range []struct {
+		name           string
+		patterns       []string
+		exceptPatterns []string
+		expected       []SocketId
+	}{
+		{"prefix", []string{"doc:*"}, nil, []SocketId{"a", "b"}},
+		{"single character", []string{"doc:?:cursor"}, nil, []SocketId{"a"}},
+		{"several patterns", []string{"doc:1?:*", "user:*"}, nil, []SocketId{"b", "c"}},
+		{"except pattern", []string{"*"}, []string{"mut*"}, []SocketId{"a", "c"}},
+		{"no match", []string{"chat:*"}, nil, []SocketId{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ids := applyIds(a, &BroadcastOptions{
+				Rooms:          types.NewSet[Room](),
+				Except:         types.NewSet[Room](),
+				RoomPatterns:   test.patterns,
+				ExceptPatterns: test.exceptPatterns,
+			})
+			if !slices.Equal(ids, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
+
+func TestMatchRoomPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		room    Room
+		match   bool
+	}{
+		{"room", "room", true},
+		{"room", "room-1", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:", true},
+		{"user:*", "user:42", true},
+		{"user:*", "users:42", false},
+		{"*:cursor", "doc:1:cursor", true},
+		{"*:cursor", "doc:1:cursors", false},
+		{"doc:*:cursor", "doc:1:2:cursor", true},
+		{"doc:?:cursor", "doc:1:cursor", true},
+		{"doc:?:cursor", "doc:12:cursor", false},
+		{"doc:?:cursor", "doc::cursor", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"??", "ab", true},
+		{"??", "a", false},
+		{"*?", "", false},
+	} {
+		if match := matchRoomPattern(test.pattern, test.room); match != test.match {
+			t.Errorf("matchRoomPattern(%q, %q): expected %v, got %v", test.pattern, test.room, test.match, match)
+		}
+	}
+}
+
+func TestRoomTrieMatch(t *testing.T) {
+	trie := newRoomTrie()
+	for _, room := range []Room{"doc", "doc:1", "doc:12", "doc:2:cursor", "dog", "user:1"} {
+		trie.Insert(room)
+	}
+	trie.Delete("dog")
+
+	for _, test := range []struct {
+		pattern  string
+		expected []Room
+	}{
+		{"doc", []Room{"doc"}},
+		{"doc*", []Room{"doc", "doc:1", "doc:12", "doc:2:cursor"}},
+		{"doc:*", []Room{"doc:1", "doc:12", "doc:2:cursor"}},
+		{"doc:1*", []Room{"doc:1", "doc:12"}},
+		{"doc:?", []Room{"doc:1"}},
+		{"doc:*:cursor", []Room{"doc:2:cursor"}},
+		{"do?", []Room{"doc"}},
+		{"dog", nil},
+		{"missing:*", nil},
+		{"*1", []Room{"doc:1", "user:1"}},
+	} {
+		rooms := trie.Match(test.pattern)
+		slices.Sort(rooms)
+		if len(rooms) != len(test.expected) || (len(rooms) > 0 && !slices.Equal(rooms, test.expected)) {
+			t.Errorf("Match(%q): expected %v, got %v", test.pattern, test.expected, rooms)
+		}
+	}
+}
+
+// An adapter which does not implement RoomTargetingAdapter.
+type legacyTestAdapter struct {
+	Adapter
+
+	broadcasts []*BroadcastOptions
+}
+
+func (a *legacyTestAdapter) Broadcast(packet *parser.Packet, opts *BroadcastOptions) {
+	a.broadcasts = append(a.broadcasts, opts)
+}
+
+func TestBroadcastOperatorRoomTargetingFallback(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/legacy", nil)
+	nsp.Adapter().AddAll("a", types.NewSet[Room]("doc:1", "muted"))
+	nsp.Adapter().AddAll("b", types.NewSet[Room]("doc:2"))
+	legacy := &legacyTestAdapter{Adapter: nsp.Adapter()}
+
+	if err := NewBroadcastOperator(legacy, nil, nil, nil).InAll("doc:1", "muted").Emit("foo"); err != ErrRoomTargetingUnsupported {
+		t.Fatalf("expected ErrRoomTargetingUnsupported, got %v", err)
+	}
+	if err := NewBroadcastOperator(legacy, nil, nil, nil).ToPattern("chat:*").Emit("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(legacy.broadcasts) != 0 {
+		t.Fatalf("expected no broadcast, got %d", len(legacy.broadcasts))
+	}
+
+	if err := NewBroadcastOperator(legacy, nil, nil, nil).ToPattern("doc:*").ExceptPattern("mut*").Emit("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(legacy.broadcasts) != 1 {
+		t.Fatalf("expected 1 broadcast, got %d", len(legacy.broadcasts))
+	}
+	opts := legacy.broadcasts[0]
+	if rooms := opts.Rooms.Keys(); len(opts.RoomPatterns) != 0 || !opts.Rooms.Has("doc:1") || !opts.Rooms.Has("doc:2") || len(rooms) != 2 {
+		t.Fatalf("unexpected rooms %v (patterns %v)", rooms, opts.RoomPatterns)
+	}
+	if len(opts.ExceptPatterns) != 0 || !opts.Except.Has("muted") {
+		t.Fatalf("unexpected except %v (patterns %v)", opts.Except.Keys(), opts.ExceptPatterns)
+	}
+}
+
+type legacyTestAdapterBuilder struct{}
+
+func (*legacyTestAdapterBuilder) New(nsp Namespace) Adapter {
+	return &legacyTestAdapter{Adapter: NewAdapterNew(nsp)}
+}
+
+func TestNamespaceRoomMetaFallback(t *testing.T) {
+	opts := DefaultServerOptions()
+	opts.SetAdapter(&legacyTestAdapterBuilder{})
+	nsp := NewServer(nil, opts).Of("/legacy-meta", nil)
+	if _, ok := nsp.Adapter().(RoomRegistryAdapter); ok {
+		t.Fatal("expected an adapter without room registry")
+	}
+
+	nsp.SetRoomMeta("room", &RoomMeta{Owner: "admin", MaxMembers: 1})
+	if meta, ok := nsp.RoomMeta("room"); !ok || meta.Owner != "admin" || meta.CreatedAt == 0 {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+
+	a := &persistedSocketDetails{&SessionToPersist{Sid: "a"}}
+	b := &persistedSocketDetails{&SessionToPersist{Sid: "b"}}
+	if err := checkJoin(nsp, a, "room"); err != nil {
+		t.Fatal(err)
+	}
+	nsp.Adapter().AddAll("a", types.NewSet[Room]("room"))
+	if err := checkJoin(nsp, a, "room"); err != nil {
+		t.Fatalf("expected a member to be allowed, got %v", err)
+	}
+	if err := checkJoin(nsp, b, "room"); !errors.Is(err, ErrRoomFull) {
+		t.Fatalf("expected ErrRoomFull, got %v", err)
+	}
+
+	errForbidden := errors.New("forbidden")
+	nsp.SetJoinGuard(func(socket SocketDetails, room Room, meta *RoomMeta) error {
+		if meta == nil && socket.Id() == "b" {
+			return errForbidden
+		}
+		return nil
+	})
+	if err := checkJoin(nsp, b, "other"); !errors.Is(err, errForbidden) {
+		t.Fatalf("expected the error of the join guard, got %v", err)
+	}
+
+	nsp.DelRoomMeta("room")
+	if _, ok := nsp.RoomMeta("room"); ok {
+		t.Fatal("expected the metadata to be removed")
+	}
+	if err := checkJoin(nsp, b, "room"); !errors.Is(err, errForbidden) {
+		t.Fatalf("expected the error of the join guard, got %v", err)
+	}
+}
+
+func TestNamespaceRoomMetaRegistry(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/registry", nil)
+	nsp.SetRoomMeta("room", &RoomMeta{Topic: "news"})
+
+	registry, ok := nsp.Adapter().(RoomRegistryAdapter)
+	if !ok {
+		t.Fatal("expected the in-memory adapter to be a room registry")
+	}
+	if meta, ok := registry.RoomMeta("room"); !ok || meta.Topic != "news" {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+	nsp.DelRoomMeta("room")
+	if _, ok := registry.RoomMeta("room"); ok {
+		t.Fatal("expected the metadata to be removed")
+	}
+}
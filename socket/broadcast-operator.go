@@ -12,10 +12,15 @@ import (
 )
 
 type BroadcastOperator struct {
-	adapter     Adapter
-	rooms       *types.Set[Room]
-	exceptRooms *types.Set[Room]
-	flags       *BroadcastFlags
+	adapter        Adapter
+	rooms          *types.Set[Room]
+	exceptRooms    *types.Set[Room]
+	flags          *BroadcastFlags
+	allRooms       *types.Set[Room]
+	roomPatterns   []string
+	exceptPatterns []string
+	predicates     []*SocketPredicate
+	filter         func(SocketDetails) bool
 }
 
 func MakeBroadcastOperator() *BroadcastOperator {
@@ -49,6 +54,47 @@ func (b *BroadcastOperator) Construct(adapter Adapter, rooms *types.Set[Room], e
 	}
 }
 
+// Returns a new [BroadcastOperator] which keeps the targeting conditions of the current one.
+func (b *BroadcastOperator) derive(rooms *types.Set[Room], exceptRooms *types.Set[Room], flags *BroadcastFlags) *BroadcastOperator {
+	operator := NewBroadcastOperator(b.adapter, rooms, exceptRooms, flags)
+	operator.allRooms = b.allRooms
+	operator.roomPatterns = b.roomPatterns
+	operator.exceptPatterns = b.exceptPatterns
+	operator.predicates = b.predicates
+	operator.filter = b.filter
+	return operator
+}
+
+// Returns a new [BroadcastOperator] targeting the sockets described by the given options.
+func newBroadcastOperatorFromOptions(adapter Adapter, opts *BroadcastOptions) *BroadcastOperator {
+	operator := NewBroadcastOperator(adapter, opts.Rooms, opts.Except, opts.Flags)
+	operator.allRooms = opts.InAll
+	operator.roomPatterns = opts.RoomPatterns
+	operator.exceptPatterns = opts.ExceptPatterns
+	operator.predicates = opts.Predicates
+	operator.filter = opts.Filter
+	return operator
+}
+
+func (b *BroadcastOperator) broadcastOptions() *BroadcastOptions {
+	return &BroadcastOptions{
+		Rooms:          b.rooms,
+		Except:         b.exceptRooms,
+		Flags:          b.flags,
+		InAll:          b.allRooms,
+		RoomPatterns:   b.roomPatterns,
+		ExceptPatterns: b.exceptPatterns,
+		Predicates:     b.predicates,
+		Filter:         b.filter,
+	}
+}
+
+// Returns the options of the operation in a form the adapter understands, nil if no socket can match (see
+// [RoomTargetingAdapter]).
+func (b *BroadcastOperator) targetOptions() (*BroadcastOptions, error) {
+	return resolveRoomTargets(b.adapter, b.broadcastOptions())
+}
+
 // Targets a room when emitting.
 //
 //	// the “foo” event will be broadcast to all connected clients in the “room-101” room
@@ -67,7 +113,7 @@ func (b *BroadcastOperator) Construct(adapter Adapter, rooms *types.Set[Room], e
 func (b *BroadcastOperator) To(room ...Room) *BroadcastOperator {
 	rooms := types.NewSet(b.rooms.Keys()...)
 	rooms.Add(room...)
-	return NewBroadcastOperator(b.adapter, rooms, b.exceptRooms, b.flags)
+	return b.derive(rooms, b.exceptRooms, b.flags)
 }
 
 // Targets a room when emitting. Similar to `to()`, but might feel clearer in some cases:
@@ -82,6 +128,51 @@ func (b *BroadcastOperator) In(room ...Room) *BroadcastOperator {
 	return b.To(room...)
 }
 
+// Targets the sockets which have joined all the given rooms when emitting (intersection, whereas [BroadcastOperator.To]
+// is a union).
+//
+//	// the “foo” event will be broadcast to the clients which are both in the “tenant:42” and in the “channel:general” rooms
+//	io.InAll("tenant:42", "channel:general").Emit("foo", "bar")
+//
+//	// with multiple chained calls
+//	io.InAll("tenant:42").InAll("channel:general").Emit("foo", "bar")
+//
+//	// combined with To(): the clients of the “tenant:42” room which are either in the “room-101” or in the “room-102” room
+//	io.InAll("tenant:42").To("room-101", "room-102").Emit("foo", "bar")
+//
+// Param: Room - a `Room`, or a `Room` slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (b *BroadcastOperator) InAll(room ...Room) *BroadcastOperator {
+	allRooms := types.NewSet(room...)
+	if b.allRooms != nil {
+		allRooms.Add(b.allRooms.Keys()...)
+	}
+	operator := b.derive(b.rooms, b.exceptRooms, b.flags)
+	operator.allRooms = allRooms
+	return operator
+}
+
+// Targets the rooms matching a pattern when emitting, where `*` matches any sequence of characters and `?` matches a
+// single character.
+//
+//	// the “foo” event will be broadcast to all connected clients in a room starting with “user:”
+//	io.ToPattern("user:*").Emit("foo", "bar")
+//
+//	// with several patterns, combined with regular rooms (a client will be notified at most once)
+//	io.To("room-101").ToPattern("doc:*:cursor", "org:9:*").Emit("foo", "bar")
+//
+// Note: this method also works within a cluster of multiple Socket.IO servers, with a compatible [Adapter].
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (b *BroadcastOperator) ToPattern(pattern ...string) *BroadcastOperator {
+	operator := b.derive(b.rooms, b.exceptRooms, b.flags)
+	operator.roomPatterns = append(append(make([]string, 0, len(b.roomPatterns)+len(pattern)), b.roomPatterns...), pattern...)
+	return operator
+}
+
 // Excludes a room when emitting.
 //
 //	// the "foo" event will be broadcast to all connected clients, except the ones that are in the "room-101" room
@@ -100,7 +191,22 @@ func (b *BroadcastOperator) In(room ...Room) *BroadcastOperator {
 func (b *BroadcastOperator) Except(room ...Room) *BroadcastOperator {
 	exceptRooms := types.NewSet(b.exceptRooms.Keys()...)
 	exceptRooms.Add(room...)
-	return NewBroadcastOperator(b.adapter, b.rooms, exceptRooms, b.flags)
+	return b.derive(b.rooms, exceptRooms, b.flags)
+}
+
+// Excludes the rooms matching a pattern when emitting.
+//
+//	// the "foo" event will be broadcast to all connected clients, except the ones that are in a room starting with
+//	// "guest:"
+//	io.ExceptPattern("guest:*").Emit("foo", "bar")
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (b *BroadcastOperator) ExceptPattern(pattern ...string) *BroadcastOperator {
+	operator := b.derive(b.rooms, b.exceptRooms, b.flags)
+	operator.exceptPatterns = append(append(make([]string, 0, len(b.exceptPatterns)+len(pattern)), b.exceptPatterns...), pattern...)
+	return operator
 }
 
 // Sets the compress flag.
@@ -113,7 +219,7 @@ func (b *BroadcastOperator) Except(room ...Room) *BroadcastOperator {
 func (b *BroadcastOperator) Compress(compress bool) *BroadcastOperator {
 	flags := *b.flags
 	flags.Compress = compress
-	return NewBroadcastOperator(b.adapter, b.rooms, b.exceptRooms, &flags)
+	return b.derive(b.rooms, b.exceptRooms, &flags)
 }
 
 // Sets a modifier for a subsequent event emission that the event data may be lost if the client is not ready to
@@ -124,7 +230,26 @@ func (b *BroadcastOperator) Compress(compress bool) *BroadcastOperator {
 func (b *BroadcastOperator) Volatile() *BroadcastOperator {
 	flags := *b.flags
 	flags.Volatile = true
-	return NewBroadcastOperator(b.adapter, b.rooms, b.exceptRooms, &flags)
+	return b.derive(b.rooms, b.exceptRooms, &flags)
+}
+
+// Sets a modifier for a subsequent event emission that at most one event emitted with the given key is delivered to
+// each client per window: the first event is delivered right away, and only the last of the following events is
+// delivered once the window expires, the previous ones being discarded. The events emitted with an acknowledgement are
+// never coalesced.
+//
+//	// at most one "price" event every 100ms per client
+//	io.To("AAPL").Coalesce("price:AAPL", 100*time.Millisecond).Emit("price", "AAPL", 187.2)
+//
+// Param: key - the coalescing key
+//
+// Param: window - the window
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (b *BroadcastOperator) Coalesce(key string, window time.Duration) *BroadcastOperator {
+	flags := *b.flags
+	flags.Coalesce = &CoalesceOptions{Key: key, Window: window}
+	return b.derive(b.rooms, b.exceptRooms, &flags)
 }
 
 // Sets a modifier for a subsequent event emission that the event data will only be broadcast to the current node.
@@ -136,7 +261,29 @@ func (b *BroadcastOperator) Volatile() *BroadcastOperator {
 func (b *BroadcastOperator) Local() *BroadcastOperator {
 	flags := *b.flags
 	flags.Local = true
-	return NewBroadcastOperator(b.adapter, b.rooms, b.exceptRooms, &flags)
+	return b.derive(b.rooms, b.exceptRooms, &flags)
+}
+
+// Sets a modifier for a subsequent event emission that the event will be retained in the history of the targeted
+// rooms (only the rooms whose history is enabled, see [Namespace.RoomHistory]), so that it can be replayed to the
+// sockets joining them later.
+//
+//	io.Of("/chat", nil).RoomHistory().Enable("general", &socket.RoomHistoryOptions{MaxEvents: 50, ReplayOnJoin: true})
+//
+//	io.Of("/chat", nil).To("general").History().Emit("message", "hello")
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (b *BroadcastOperator) History() *BroadcastOperator {
+	flags := *b.flags
+	flags.History = true
+	return b.derive(b.rooms, b.exceptRooms, &flags)
+}
+
+// Retains an event in the history of the targeted rooms, if the event is emitted with [BroadcastOperator.History].
+func (b *BroadcastOperator) appendHistory(data []any) {
+	if b.flags.History {
+		b.adapter.Nsp().RoomHistory().Append(b.broadcastOptions(), data)
+	}
 }
 
 // Adds a timeout in milliseconds for the next operation
@@ -153,7 +300,70 @@ func (b *BroadcastOperator) Local() *BroadcastOperator {
 func (b *BroadcastOperator) Timeout(timeout time.Duration) *BroadcastOperator {
 	flags := *b.flags
 	flags.Timeout = &timeout
-	return NewBroadcastOperator(b.adapter, b.rooms, b.exceptRooms, &flags)
+	return b.derive(b.rooms, b.exceptRooms, &flags)
+}
+
+// Only targets the sockets for which the given function returns `true`. Several conditions can be chained, a socket
+// must satisfy all of them.
+//
+//	// the “foo” event will be broadcast to the admins of the “room-101” room
+//	io.To("room-101").Where(func(socket socket.SocketDetails) bool {
+//		data, ok := socket.Data().(*User)
+//		return ok && data.Role == "admin"
+//	}).Emit("foo", "bar")
+//
+// Note: the function is only evaluated on the current node, please use [BroadcastOperator.WhereField] to filter the
+// sockets of the other Socket.IO servers of the cluster.
+//
+// Param: fn - the condition
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (b *BroadcastOperator) Where(fn func(SocketDetails) bool) *BroadcastOperator {
+	operator := b.derive(b.rooms, b.exceptRooms, b.flags)
+	if filter := b.filter; filter != nil {
+		operator.filter = func(socket SocketDetails) bool {
+			return filter(socket) && fn(socket)
+		}
+	} else {
+		operator.filter = fn
+	}
+	return operator
+}
+
+// Only targets the sockets matching the given serializable predicates. Several predicates can be chained, a socket
+// must satisfy all of them.
+//
+// Note: this method also works within a cluster of multiple Socket.IO servers, with a compatible [Adapter].
+//
+//	// the “foo” event will be broadcast to the admins of the “room-101” room
+//	io.To("room-101").WherePredicate(socket.NewSocketPredicate("data.role", socket.PredicateEq, "admin")).Emit("foo", "bar")
+//
+// Param: predicate - a [SocketPredicate], or a [SocketPredicate] slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (b *BroadcastOperator) WherePredicate(predicate ...*SocketPredicate) *BroadcastOperator {
+	operator := b.derive(b.rooms, b.exceptRooms, b.flags)
+	operator.predicates = append(append(make([]*SocketPredicate, 0, len(b.predicates)+len(predicate)), b.predicates...), predicate...)
+	return operator
+}
+
+// Only targets the sockets whose field matches the given value. Shorthand for [BroadcastOperator.WherePredicate].
+//
+//	// disconnect the guests of the “room-101” room
+//	io.In("room-101").WhereField("data.role", socket.PredicateEq, "guest").DisconnectSockets(false)
+//
+//	// the “foo” event will be broadcast to the sockets connected with one of the given versions
+//	io.WhereField("handshake.query.v.0", socket.PredicateIn, []string{"1", "2"}).Emit("foo", "bar")
+//
+// Param: field - a dot-separated path, starting with "id", "handshake", "rooms" or "data"
+//
+// Param: operator - the comparison
+//
+// Param: value - the operand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (b *BroadcastOperator) WhereField(field string, operator PredicateOperator, value any) *BroadcastOperator {
+	return b.WherePredicate(NewSocketPredicate(field, operator, value))
 }
 
 // Emits to all clients.
@@ -186,18 +396,36 @@ func (b *BroadcastOperator) Emit(ev string, args ...any) error {
 	}
 
 	ack, withAck := data[data_len-1].(func([]any, error))
+	if withAck {
+		packet.Data = data[:data_len-1]
+		// the callback is called by the timer or by the adapter, which may run it on its own goroutine
+		callback := ack
+		ack = func(args []any, err error) {
+			defer recoverNamespacePanic(b.adapter.Nsp(), "ack")
+
+			callback(args, err)
+		}
+	}
 
-	if !withAck {
-		b.adapter.Broadcast(packet, &BroadcastOptions{
-			Rooms:  b.rooms,
-			Except: b.exceptRooms,
-			Flags:  b.flags,
-		})
+	opts, err := b.targetOptions()
+	if err != nil {
+		return err
+	}
+
+	b.appendHistory(packet.Data.([]any))
 
+	if opts == nil {
+		if withAck {
+			ack([]any{}, nil)
+		}
 		return nil
 	}
 
-	packet.Data = data[:data_len-1]
+	if !withAck {
+		b.adapter.Broadcast(packet, opts)
+
+		return nil
+	}
 
 	var timedOut atomic.Bool
 	responses := types.NewSlice[any]()
@@ -232,11 +460,7 @@ func (b *BroadcastOperator) Emit(ev string, args ...any) error {
 		}
 	}
 
-	b.adapter.BroadcastWithAck(packet, &BroadcastOptions{
-		Rooms:  b.rooms,
-		Except: b.exceptRooms,
-		Flags:  b.flags,
-	}, func(clientCount uint64) {
+	b.adapter.BroadcastWithAck(packet, opts, func(clientCount uint64) {
 		// each Socket.IO server in the cluster sends the number of clients that were notified
 		expectedClientCount.Add(clientCount)
 		actualServerCount.Add(1)
@@ -251,6 +475,51 @@ func (b *BroadcastOperator) Emit(ev string, args ...any) error {
 	return nil
 }
 
+// Emits an event at the given time, the targeted sockets being computed at the time of the emission. The event is
+// persisted if the server has a [ScheduledEmitStore] (except the condition set with [BroadcastOperator.Where]).
+//
+//	handle, err := io.To("room-101").EmitAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "happy-new-year")
+//
+//	// the event will not be emitted
+//	handle.Cancel()
+//
+// Param: at - the time of the emission
+//
+// Param: ev - the event name
+//
+// Param: args - the arguments (acknowledgements are not supported)
+//
+// Return: the handle of the scheduled event
+func (b *BroadcastOperator) EmitAt(at time.Time, ev string, args ...any) (*ScheduledEmitHandle, error) {
+	if SOCKET_RESERVED_EVENTS.Has(ev) {
+		return nil, errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
+	}
+	if data_len := len(args); data_len > 0 {
+		if _, ok := args[data_len-1].(func([]any, error)); ok {
+			return nil, errors.New("scheduled events do not support acknowledgements")
+		}
+	}
+	return b.adapter.Nsp().Server().Scheduler().Schedule(b, at, append([]any{ev}, args...))
+}
+
+// Emits an event after the given delay, see [BroadcastOperator.EmitAt].
+//
+//	handle, _ := io.To("room-101").EmitAfter(30*time.Second, "reminder", "meeting in 5 minutes")
+//
+//	// unless cancelled
+//	handle.Cancel()
+//
+// Param: delay - the delay before the emission
+//
+// Param: ev - the event name
+//
+// Param: args - the arguments (acknowledgements are not supported)
+//
+// Return: the handle of the scheduled event
+func (b *BroadcastOperator) EmitAfter(delay time.Duration, ev string, args ...any) (*ScheduledEmitHandle, error) {
+	return b.EmitAt(time.Now().Add(delay), ev, args...)
+}
+
 // Emits an event and waits for an acknowledgement from all clients.
 //
 //	io.Timeout(1000 * time.Millisecond).EmitWithAck("some-event")(func(args []any, err error) {
@@ -304,11 +573,12 @@ func (b *BroadcastOperator) AllSockets() (*types.Set[SocketId], error) {
 //	})
 func (b *BroadcastOperator) FetchSockets() func(func([]*RemoteSocket, error)) {
 	return func(callback func([]*RemoteSocket, error)) {
-		b.adapter.FetchSockets(&BroadcastOptions{
-			Rooms:  b.rooms,
-			Except: b.exceptRooms,
-			Flags:  b.flags,
-		})(func(sockets []SocketDetails, err error) {
+		opts, err := b.targetOptions()
+		if err != nil || opts == nil {
+			callback([]*RemoteSocket{}, err)
+			return
+		}
+		b.adapter.FetchSockets(opts)(func(sockets []SocketDetails, err error) {
 			remoteSockets := []*RemoteSocket{}
 			for _, socket := range sockets {
 				if s, ok := socket.(*RemoteSocket); ok {
@@ -334,11 +604,9 @@ func (b *BroadcastOperator) FetchSockets() func(func([]*RemoteSocket, error)) {
 //
 // Param: Room - a `Room`, or a `Room` slice to expand
 func (b *BroadcastOperator) SocketsJoin(room ...Room) {
-	b.adapter.AddSockets(&BroadcastOptions{
-		Rooms:  b.rooms,
-		Except: b.exceptRooms,
-		Flags:  b.flags,
-	}, room)
+	if opts := b.applyOptions(); opts != nil {
+		b.adapter.AddSockets(opts, room)
+	}
 }
 
 // Makes the matching socket instances leave the specified rooms.
@@ -353,11 +621,24 @@ func (b *BroadcastOperator) SocketsJoin(room ...Room) {
 //
 // Param: Room - a `Room`, or a `Room` slice to expand
 func (b *BroadcastOperator) SocketsLeave(room ...Room) {
-	b.adapter.DelSockets(&BroadcastOptions{
-		Rooms:  b.rooms,
-		Except: b.exceptRooms,
-		Flags:  b.flags,
-	}, room)
+	if opts := b.applyOptions(); opts != nil {
+		b.adapter.DelSockets(opts, room)
+	}
+}
+
+// Makes the matching socket instances leave the rooms matching a pattern.
+//
+// Note: this method also works within a cluster of multiple Socket.IO servers, with an [Adapter] implementing
+// [RoomPatternAdapter].
+//
+//	// make all socket instances leave the rooms starting with "doc:"
+//	io.SocketsLeavePattern("doc:*")
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+func (b *BroadcastOperator) SocketsLeavePattern(pattern ...string) {
+	if opts := b.applyOptions(); opts != nil {
+		delSocketsPattern(b.adapter, opts, pattern)
+	}
 }
 
 // Makes the matching socket instances disconnect.
@@ -372,11 +653,19 @@ func (b *BroadcastOperator) SocketsLeave(room ...Room) {
 //
 // Param: close - whether to close the underlying connection
 func (b *BroadcastOperator) DisconnectSockets(status bool) {
-	b.adapter.DisconnectSockets(&BroadcastOptions{
-		Rooms:  b.rooms,
-		Except: b.exceptRooms,
-		Flags:  b.flags,
-	}, status)
+	if opts := b.applyOptions(); opts != nil {
+		b.adapter.DisconnectSockets(opts, status)
+	}
+}
+
+// Returns the options of an operation applied to the matching sockets, nil if it must be skipped.
+func (b *BroadcastOperator) applyOptions() *BroadcastOptions {
+	opts, err := b.targetOptions()
+	if err != nil {
+		adapter_log.Debug("skipping the operation: %v", err)
+		return nil
+	}
+	return opts
 }
 
 // Expose of subset of the attributes and methods of the Socket struct
@@ -472,6 +761,20 @@ func (r *RemoteSocket) Leave(room ...Room) {
 	r.operator.SocketsLeave(room...)
 }
 
+// Leaves the rooms matching a pattern, which are resolved by the server of the socket (and not against the rooms
+// fetched with the socket, which may have changed since).
+//
+//	io.FetchSockets()(func(sockets []*RemoteSocket, _ error){
+//		for _, socket := range sockets {
+//			socket.LeavePattern("doc:*")
+//		}
+//	})
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+func (r *RemoteSocket) LeavePattern(pattern ...string) {
+	r.operator.SocketsLeavePattern(pattern...)
+}
+
 // Disconnects this client.
 //
 // Param: close - if `true`, closes the underlying connection
@@ -0,0 +1,70 @@
+package socket
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+var (
+	cbor_parser_log = log.NewLog("socket.io:cbor-parser")
+
+	cborDecMode, _ = cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+	}.DecMode()
+)
+
+type (
+	// A parser where each packet is encoded as a single CBOR (RFC 8949) map with the "type", "nsp", "data" and "id"
+	// fields, like with [MsgpackParser]: the type is an integer from 0 (CONNECT) to 4 (CONNECT_ERROR) and the binary
+	// data is inlined as byte strings.
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetParser(socket.NewCborParser())
+	//	io := socket.NewServer(nil, opts)
+	//
+	// The decoded binary data are [types.BytesBuffer], like with the default parser, while the integers are decoded as
+	// uint64 (or int64 if negative) instead of float64.
+	CborParser struct {
+	}
+
+	cborEncoder struct {
+	}
+)
+
+func NewCborParser() *CborParser {
+	return &CborParser{}
+}
+
+func (p *CborParser) NewEncoder() parser.Encoder {
+	return &cborEncoder{}
+}
+
+func (p *CborParser) NewDecoder() parser.Decoder {
+	return newFrameDecoder(cbor_parser_log, cborDecodePacket)
+}
+
+// Encodes a packet as a single CBOR map.
+func (e *cborEncoder) Encode(packet *parser.Packet) []types.BufferInterface {
+	cbor_parser_log.Debug("encoding packet %v", packet)
+
+	buf := types.NewBytesBuffer(nil)
+	// like with the default parser, the structs without "cbor" tags are encoded with their "json" tags
+	if err := cbor.NewEncoder(buf).Encode(encodeFramePacket(packet)); err != nil {
+		cbor_parser_log.Error("failed to encode packet %v: %v", packet, err)
+		return nil
+	}
+	return []types.BufferInterface{buf}
+}
+
+func cborDecodePacket(data []byte) (*parser.Packet, error) {
+	var raw map[string]any
+	if err := cborDecMode.Unmarshal(data, &raw); err != nil || raw == nil {
+		return nil, errors.New("invalid payload")
+	}
+	return decodeFramePacket(raw)
+}
@@ -2,7 +2,9 @@ package socket
 
 import (
 	"net/url"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	_types "github.com/zishang520/engine.io-go-parser/types"
 	"github.com/zishang520/engine.io-server-go-fasthttp/v2/engine"
@@ -25,12 +27,28 @@ type Client struct {
 	sockets        *types.Map[SocketId, *Socket]
 	nsps           *types.Map[string, *Socket]
 	connectTimeout atomic.Pointer[utils.Timer]
+	// nil unless the server has a [SlowConsumer] option
+	outbound *clientOutbound
+	// the sockets whose middlewares are running, cleaned up if the client closes before they complete
+	connecting *types.Map[SocketId, *Socket]
+	// the size of the data of the packet being received, see [PacketLimits]
+	packetSize atomic.Int64
+	// whether the client uses the default parser, whose binary packets announce their attachments
+	defaultParser bool
+	// the packets held back by the [CoalesceOptions] of their emission
+	coalesced   map[coalesceKey]*coalescedWrite
+	coalescedMu sync.Mutex
+	// the number of packets held back, and the order of the last emission of a packet held back
+	coalescedPending int
+	coalescedSeq     uint64
 }
 
 func MakeClient() *Client {
 	c := &Client{
-		sockets: &types.Map[SocketId, *Socket]{},
-		nsps:    &types.Map[string, *Socket]{},
+		sockets:    &types.Map[SocketId, *Socket]{},
+		nsps:       &types.Map[string, *Socket]{},
+		connecting: &types.Map[SocketId, *Socket]{},
+		coalesced:  map[coalesceKey]*coalescedWrite{},
 	}
 
 	return c
@@ -56,9 +74,18 @@ func (c *Client) Conn() engine.Socket {
 func (c *Client) Construct(server *Server, conn engine.Socket) {
 	c.server = server
 	c.conn = conn
-	c.encoder = server.Encoder()
-	c.decoder = server._parser.NewDecoder()
+	if p, ok := server.negotiateParser(conn); ok && p != nil {
+		c.encoder = p.encoder
+		c.decoder = p.parser.NewDecoder()
+	} else {
+		c.encoder = server.Encoder()
+		c.decoder = server._parser.NewDecoder()
+		c.defaultParser = server.opts.GetRawParser() == nil
+	}
 	c.id = conn.Id()
+	if slowConsumer := server.opts.GetRawSlowConsumer(); slowConsumer != nil {
+		c.outbound = newClientOutbound(slowConsumer)
+	}
 	c.setup()
 }
 
@@ -74,14 +101,22 @@ func (c *Client) setup() {
 	c.conn.On("error", c.onerror)
 	c.conn.On("close", c.onclose)
 
-	c.connectTimeout.Store(utils.SetTimeout(func() {
+	c.setConnectTimeout(c.server._connectTimeout)
+}
+
+// Closes the client if it has not joined any namespace after the given delay, replacing the previous timer.
+func (c *Client) setConnectTimeout(timeout time.Duration) {
+	timer := utils.SetTimeout(func() {
 		if c.nsps.Len() == 0 {
 			client_log.Debug("no namespace joined yet, close the client")
 			c.close()
 		} else {
 			client_log.Debug("the client has already joined a namespace, nothing to do")
 		}
-	}, c.server._connectTimeout))
+	}, timeout)
+	if previous := c.connectTimeout.Swap(timer); previous != nil {
+		utils.ClearTimeout(previous)
+	}
 }
 
 // Connects a client to a namespace.
@@ -118,6 +153,10 @@ func (c *Client) connect(name string, auth any) {
 // Param: auth - the auth parameters
 func (c *Client) doConnect(name string, auth any) {
 	nsp := c.server.Of(name, nil)
+	if timeout := nsp.Options().GetRawConnectTimeout(); timeout != nil && c.connectTimeout.Load() != nil {
+		client_log.Debug("using the connect timeout of namespace %s", name)
+		c.setConnectTimeout(*timeout)
+	}
 	nsp.Add(c, auth, func(socket *Socket) {
 		c.sockets.Store(socket.Id(), socket)
 		c.nsps.Store(nsp.Name(), socket)
@@ -168,37 +207,48 @@ func (c *Client) _packet(packet *parser.Packet, opts *WriteOptions) {
 		opts = &WriteOptions{}
 	}
 
-	c.WriteToEngine(c.encoder.Encode(packet), opts)
+	// only the events may be dropped by the slow consumer policy
+	critical := packet.Type != parser.EVENT && packet.Type != parser.BINARY_EVENT
+	c.writeToEngine(packet.Nsp, c.encoder.Encode(packet), opts, critical)
 }
 
 func (c *Client) WriteToEngine(encodedPackets []_types.BufferInterface, opts *WriteOptions) {
-	if opts.Volatile && !c.conn.Transport().Writable() {
-		client_log.Debug("volatile packet is discarded since the transport is not currently writable")
-		return
-	}
-
-	for _, encodedPacket := range encodedPackets {
-		switch data := encodedPacket.(type) {
-		case *_types.StringBuffer:
-			c.conn.Write(_types.NewStringBuffer(data.Bytes()), &opts.Options, nil)
-		case *_types.BytesBuffer:
-			c.conn.Write(_types.NewBytesBuffer(data.Bytes()), &opts.Options, nil)
-		}
-	}
+	c.writeToEngine("", encodedPackets, opts, false)
 }
 
 // Called with incoming transport data.
 func (c *Client) ondata(args ...any) {
+	size := c.packetSize.Add(dataSize(args[0]))
+	if err := c.server.opts.PacketLimits().checkData(args[0], size, c.defaultParser); err != nil {
+		client_log.Debug("packet limit exceeded: %v", err)
+		c.onlimit(err)
+		return
+	}
 	// error is needed for protocol violations (GH-1880)
 	if err := c.decoder.Add(args[0]); err != nil {
 		client_log.Debug("invalid packet format")
+		c.packetSize.Store(0)
 		c.onerror(err)
 	}
 }
 
+// Closes the connection once a packet exceeded a limit of the server, since the rest of the packet could not be
+// skipped.
+func (c *Client) onlimit(err *PacketLimitError) {
+	c.sockets.Range(func(_ SocketId, socket *Socket) bool {
+		socket._onerror(err)
+		return true
+	})
+	if c.conn.ReadyState() == "open" {
+		c.onclose(err.Reason())
+		c.conn.Close(false)
+	}
+}
+
 // Called when parser fully decodes a packet.
 func (c *Client) ondecoded(args ...any) {
 	packet, _ := args[0].(*parser.Packet)
+	size := c.packetSize.Swap(0)
 	var namespace string
 	var authPayload any
 	if c.conn.Protocol() == 3 {
@@ -214,6 +264,17 @@ func (c *Client) ondecoded(args ...any) {
 	if !ok && packet.Type == parser.CONNECT {
 		c.connect(namespace, authPayload)
 	} else if ok && packet.Type != parser.CONNECT && packet.Type != parser.CONNECT_ERROR {
+		if limits := socket.Nsp().Options().GetRawPacketLimits(); limits != nil {
+			if err := limits.checkPacket(packet, size); err != nil {
+				client_log.Debug("packet limit exceeded: %v", err)
+				if limits.Policy() == PacketLimitDrop {
+					socket._onerror(err)
+				} else {
+					socket.disconnect(err.Reason())
+				}
+				return
+			}
+		}
 		go socket._onpacket(packet)
 	} else {
 		client_log.Debug("invalid state (packet type: %s)", packet.Type.String())
@@ -243,6 +304,12 @@ func (c *Client) onclose(args ...any) {
 		return true
 	})
 	c.sockets.Clear()
+	// releases the slots of the namespaces, whose middlewares may never complete
+	c.connecting.Range(func(id SocketId, socket *Socket) bool {
+		socket._cleanup()
+		return true
+	})
+	c.connecting.Clear()
 
 	c.decoder.Destroy() // clean up decoder
 }
@@ -258,4 +325,6 @@ func (c *Client) destroy() {
 		utils.ClearTimeout(connectTimeout)
 		c.connectTimeout.Store(nil)
 	}
+
+	c.clearCoalesced()
 }
@@ -0,0 +1,135 @@
+package socket
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	_types "github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/engine.io/v2/utils"
+)
+
+type (
+	coalesceKey struct {
+		nsp string
+		key string
+	}
+
+	// The window of a coalescing key, and the last packet emitted with the key within the window, if any.
+	coalescedWrite struct {
+		encodedPackets []_types.BufferInterface
+		opts           *WriteOptions
+		critical       bool
+		// whether a packet is held back
+		pending bool
+		// the order of the emission of the packet held back, among the packets held back by the client
+		seq   uint64
+		timer *utils.Timer
+	}
+)
+
+// Writes the first packet emitted with a given key right away, and holds the following ones back until the window
+// expires, each packet replacing the one previously held back with the same key. The last packet is then written, and
+// a new window starts.
+//
+// The packets held back are written before any other packet of the client, so that the packets are written in the
+// order of their emission. The volatile and slow consumer checks are applied when the packets are eventually written.
+func (c *Client) coalesce(nsp string, encodedPackets []_types.BufferInterface, opts *WriteOptions, critical bool) {
+	key := coalesceKey{nsp: nsp, key: opts.Coalesce.Key}
+
+	c.coalescedMu.Lock()
+	if w, ok := c.coalesced[key]; ok {
+		if w.pending {
+			client_log.Debug("discarding the previous packet with coalescing key %s", key.key)
+		} else {
+			c.coalescedPending++
+		}
+		c.coalescedSeq++
+		w.encodedPackets = encodedPackets
+		w.opts = opts
+		w.critical = critical
+		w.pending = true
+		w.seq = c.coalescedSeq
+		c.coalescedMu.Unlock()
+		return
+	}
+	w := &coalescedWrite{}
+	c.coalesced[key] = w
+	c.startCoalesceWindow(key, w, opts.Coalesce.Window)
+	c.coalescedMu.Unlock()
+
+	c.flushCoalesced(math.MaxUint64)
+	c.writeOutbound(encodedPackets, withoutCoalesce(opts), critical)
+}
+
+// Writes the packet held back once the window expires, and starts a new window if there was one.
+//
+// Must be called with the lock of the coalesced packets held.
+func (c *Client) startCoalesceWindow(key coalesceKey, w *coalescedWrite, window time.Duration) {
+	w.timer = utils.SetTimeout(func() {
+		c.coalescedMu.Lock()
+		if c.coalesced[key] != w {
+			// the client was closed in the meantime
+			c.coalescedMu.Unlock()
+			return
+		}
+		if !w.pending {
+			delete(c.coalesced, key)
+			c.coalescedMu.Unlock()
+			return
+		}
+		seq := w.seq
+		c.startCoalesceWindow(key, w, window)
+		c.coalescedMu.Unlock()
+
+		// the packets held back with the other keys which were emitted before are written first
+		c.flushCoalesced(seq)
+	}, window)
+}
+
+// Writes the packets held back which were emitted up to the given order, in the order of their emission. Their
+// windows are kept.
+func (c *Client) flushCoalesced(seq uint64) {
+	c.coalescedMu.Lock()
+	if c.coalescedPending == 0 {
+		c.coalescedMu.Unlock()
+		return
+	}
+	writes := []coalescedWrite{}
+	for _, w := range c.coalesced {
+		if w.pending && w.seq <= seq {
+			writes = append(writes, *w)
+			w.pending = false
+			w.encodedPackets = nil
+			w.opts = nil
+			c.coalescedPending--
+		}
+	}
+	c.coalescedMu.Unlock()
+
+	slices.SortFunc(writes, func(a, b coalescedWrite) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	for _, w := range writes {
+		c.writeOutbound(w.encodedPackets, withoutCoalesce(w.opts), w.critical)
+	}
+}
+
+func withoutCoalesce(opts *WriteOptions) *WriteOptions {
+	o := *opts
+	o.Coalesce = nil
+	return &o
+}
+
+// Discards the packets held back, upon disconnection.
+func (c *Client) clearCoalesced() {
+	c.coalescedMu.Lock()
+	defer c.coalescedMu.Unlock()
+
+	for key, w := range c.coalesced {
+		utils.ClearTimeout(w.timer)
+		delete(c.coalesced, key)
+	}
+	c.coalescedPending = 0
+}
@@ -0,0 +1,68 @@
+package socket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoalesceLeadingEdge(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/coalesce", nil)
+	socket, conn := newWriteTestSocket(nsp, "a")
+
+	// the first packet is written right away
+	socket.Coalesce("position", 50*time.Millisecond).Emit("position", 1)
+	expectWrites(t, conn, `2/coalesce,["position",1]`)
+
+	// the following ones replace each other until the window expires
+	socket.Coalesce("position", 50*time.Millisecond).Emit("position", 2)
+	socket.Coalesce("position", 50*time.Millisecond).Emit("position", 3)
+	time.Sleep(20 * time.Millisecond)
+	expectWrites(t, conn, `2/coalesce,["position",1]`)
+	expectWrites(t, conn, `2/coalesce,["position",1]`, `2/coalesce,["position",3]`)
+
+	// a new window started with the packet held back
+	socket.Coalesce("position", 50*time.Millisecond).Emit("position", 4)
+	time.Sleep(20 * time.Millisecond)
+	expectWrites(t, conn, `2/coalesce,["position",1]`, `2/coalesce,["position",3]`)
+	expectWrites(t, conn, `2/coalesce,["position",1]`, `2/coalesce,["position",3]`, `2/coalesce,["position",4]`)
+
+	// once a window expires without any packet, the next packet is written right away
+	time.Sleep(120 * time.Millisecond)
+	socket.Coalesce("position", 50*time.Millisecond).Emit("position", 5)
+	expectWrites(t, conn, `2/coalesce,["position",1]`, `2/coalesce,["position",3]`, `2/coalesce,["position",4]`, `2/coalesce,["position",5]`)
+}
+
+func TestCoalesceKeepsOrder(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/coalesce", nil)
+	socket, conn := newWriteTestSocket(nsp, "a")
+
+	socket.Coalesce("a", time.Second).Emit("a", 1)
+	socket.Coalesce("b", time.Second).Emit("b", 1)
+	socket.Coalesce("a", time.Second).Emit("a", 2)
+	socket.Coalesce("b", time.Second).Emit("b", 2)
+	socket.Coalesce("a", time.Second).Emit("a", 3)
+	// the packets held back were emitted before, they are written first
+	socket.Emit("c")
+	expectWrites(t, conn,
+		`2/coalesce,["a",1]`,
+		`2/coalesce,["b",1]`,
+		`2/coalesce,["b",2]`,
+		`2/coalesce,["a",3]`,
+		`2/coalesce,["c"]`,
+	)
+}
+
+func TestCoalesceClose(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/coalesce", nil)
+	socket, conn := newWriteTestSocket(nsp, "a")
+
+	socket.Coalesce("position", 30*time.Millisecond).Emit("position", 1)
+	socket.Coalesce("position", 30*time.Millisecond).Emit("position", 2)
+	socket.Client().onclose("transport close")
+
+	time.Sleep(60 * time.Millisecond)
+	expectWrites(t, conn, `2/coalesce,["position",1]`)
+	if socket.Client().coalescedPending != 0 || len(socket.Client().coalesced) != 0 {
+		t.Fatal("expected the packets held back to be discarded")
+	}
+}
@@ -0,0 +1,164 @@
+package socket
+
+import (
+	"sync"
+)
+
+type (
+	fanOutTask struct {
+		sockets []*Socket
+		write   func(*Socket)
+		// nil unless the broadcast waits for the workers
+		done *sync.WaitGroup
+	}
+
+	// Shards the recipients of the broadcasts across a bounded pool of workers, see [BroadcastExecutor].
+	fanOutExecutor struct {
+		queues []chan *fanOutTask
+		wait   bool
+
+		mu     sync.RWMutex
+		closed bool
+		// closed along with the executor, to release the broadcasts waiting for a full queue
+		quit chan struct{}
+		// the broadcasts which are queueing their batches
+		dispatching sync.WaitGroup
+		workers     sync.WaitGroup
+	}
+
+	// The recipients of a broadcast, grouped by worker.
+	fanOutBatch struct {
+		executor *fanOutExecutor
+		shards   [][]*Socket
+		size     int
+	}
+)
+
+func newFanOutExecutor(opts *BroadcastExecutor) *fanOutExecutor {
+	e := &fanOutExecutor{
+		queues: make([]chan *fanOutTask, opts.Workers()),
+		wait:   opts.Wait(),
+		quit:   make(chan struct{}),
+	}
+	for i := range e.queues {
+		e.queues[i] = make(chan *fanOutTask, opts.QueueSize())
+		e.workers.Add(1)
+		go e.work(e.queues[i])
+	}
+	return e
+}
+
+func (e *fanOutExecutor) work(queue chan *fanOutTask) {
+	defer e.workers.Done()
+
+	for task := range queue {
+		for _, socket := range task.sockets {
+			task.write(socket)
+		}
+		if task.done != nil {
+			task.done.Done()
+		}
+	}
+}
+
+// Returns the worker of a socket, so that the packets broadcast to a given socket are written in order.
+func (e *fanOutExecutor) shard(id SocketId) int {
+	// FNV-1a
+	hash := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		hash ^= uint32(id[i])
+		hash *= 16777619
+	}
+	return int(hash % uint32(len(e.queues)))
+}
+
+func (e *fanOutExecutor) batch() *fanOutBatch {
+	return &fanOutBatch{
+		executor: e,
+		shards:   make([][]*Socket, len(e.queues)),
+	}
+}
+
+// Stops the workers, once the pending batches are written.
+func (e *fanOutExecutor) Close() {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	// the broadcasts waiting for a full queue write their batches themselves
+	close(e.quit)
+	e.dispatching.Wait()
+
+	for _, queue := range e.queues {
+		close(queue)
+	}
+	e.workers.Wait()
+}
+
+func (b *fanOutBatch) Add(socket *Socket) {
+	i := b.executor.shard(socket.Id())
+	b.shards[i] = append(b.shards[i], socket)
+	b.size++
+}
+
+// Returns the number of recipients.
+func (b *fanOutBatch) Len() int {
+	return b.size
+}
+
+// Hands the recipients to the workers, and waits for the packet to be written to every recipient if the executor is
+// configured to. The packets of the successive broadcasts are written in order to each recipient, since a recipient
+// is always handled by the same worker.
+//
+// The workers never call user code: the outgoing listeners are called by the broadcasting goroutine, and the slow
+// consumer events are emitted on another goroutine (see [WriteOptions]), so that no broadcast is made by a worker,
+// which could wait for itself. The packet is written on the calling goroutine once the executor is closed.
+func (b *fanOutBatch) Dispatch(write func(*Socket)) {
+	e := b.executor
+
+	e.mu.RLock()
+	if e.closed {
+		e.mu.RUnlock()
+		for _, sockets := range b.shards {
+			for _, socket := range sockets {
+				write(socket)
+			}
+		}
+		return
+	}
+	e.dispatching.Add(1)
+	e.mu.RUnlock()
+	defer e.dispatching.Done()
+
+	var done *sync.WaitGroup
+	if e.wait {
+		done = &sync.WaitGroup{}
+	}
+	for i, sockets := range b.shards {
+		if len(sockets) == 0 {
+			continue
+		}
+		if done != nil {
+			done.Add(1)
+		}
+		// blocks while the queue of the worker is full
+		select {
+		case e.queues[i] <- &fanOutTask{sockets: sockets, write: write, done: done}:
+		case <-e.quit:
+			for _, socket := range sockets {
+				write(socket)
+			}
+			if done != nil {
+				done.Done()
+			}
+		}
+	}
+
+	if done != nil {
+		done.Wait()
+	}
+}
@@ -0,0 +1,253 @@
+package socket
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/zishang520/engine.io-go-parser/packet"
+	"github.com/zishang520/engine.io-server-go-fasthttp/v2/engine"
+	"github.com/zishang520/engine.io-server-go-fasthttp/v2/transports"
+	f_types "github.com/zishang520/engine.io-server-go-fasthttp/v2/types"
+	"github.com/zishang520/engine.io/v2/events"
+	"github.com/zishang520/engine.io/v2/types"
+)
+
+// A connection which records the packets written to it, the callbacks of the writes being called by drain.
+type writeTestConn struct {
+	engine.Socket
+
+	request *f_types.HttpContext
+
+	mu        sync.Mutex
+	state     string
+	writable  bool
+	writes    []string
+	callbacks []func(transports.Transport)
+}
+
+type writeTestTransport struct {
+	transports.Transport
+
+	conn *writeTestConn
+}
+
+func (t writeTestTransport) Writable() bool {
+	t.conn.mu.Lock()
+	defer t.conn.mu.Unlock()
+
+	return t.conn.writable
+}
+
+func newWriteTestConn() *writeTestConn {
+	return &writeTestConn{request: f_types.NewHttpContext(&fasthttp.RequestCtx{}), state: "open", writable: true}
+}
+
+func (c *writeTestConn) Request() *f_types.HttpContext {
+	return c.request
+}
+
+func (c *writeTestConn) RemoteAddress() string {
+	return "127.0.0.1"
+}
+
+func (c *writeTestConn) Id() string {
+	return "conn"
+}
+
+func (c *writeTestConn) Protocol() int {
+	return 4
+}
+
+func (c *writeTestConn) ReadyState() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+func (c *writeTestConn) Transport() transports.Transport {
+	return writeTestTransport{conn: c}
+}
+
+func (c *writeTestConn) Write(data io.Reader, _ *packet.Options, callback func(transports.Transport)) engine.Socket {
+	b, _ := io.ReadAll(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writes = append(c.writes, string(b))
+	if callback != nil {
+		c.callbacks = append(c.callbacks, callback)
+	}
+	return c
+}
+
+func (c *writeTestConn) RemoveListener(events.EventName, events.Listener) bool {
+	return false
+}
+
+func (c *writeTestConn) Close(bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state = "closed"
+}
+
+// Calls the callbacks of the packets written so far, as if the transport was drained.
+func (c *writeTestConn) drain() {
+	c.mu.Lock()
+	callbacks := c.callbacks
+	c.callbacks = nil
+	c.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(nil)
+	}
+}
+
+func (c *writeTestConn) Writes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.writes)
+}
+
+// Returns a client writing to a test connection.
+func newWriteTestClient(server *Server, id string) (*Client, *writeTestConn) {
+	conn := newWriteTestConn()
+	client := MakeClient()
+	client.server = server
+	client.conn = conn
+	client.encoder = server.Encoder()
+	client.decoder = server._parser.NewDecoder()
+	client.id = id
+	if slowConsumer := server.opts.GetRawSlowConsumer(); slowConsumer != nil {
+		client.outbound = newClientOutbound(slowConsumer)
+	}
+	return client, conn
+}
+
+// Returns a socket of the namespace connected with a recording connection.
+func newWriteTestSocket(nsp Namespace, id SocketId) (*Socket, *writeTestConn) {
+	client, conn := newWriteTestClient(nsp.Server(), string(id))
+
+	s := MakeSocket()
+	s.server = nsp.Server()
+	s.nsp = nsp
+	s.adapter = nsp.Adapter()
+	s.client = client
+	s.id = id
+	s.connected.Store(true)
+	client.sockets.Store(id, s)
+	client.nsps.Store(nsp.Name(), s)
+	nsp.Sockets().Store(id, s)
+	nsp.Adapter().AddAll(id, types.NewSet(Room(id)))
+	return s, conn
+}
+
+func TestFanOutExecutorNestedBroadcast(t *testing.T) {
+	for _, wait := range []bool{true, false} {
+		t.Run(fmt.Sprintf("wait=%v", wait), func(t *testing.T) {
+			executor := &BroadcastExecutor{}
+			executor.SetWorkers(2)
+			executor.SetQueueSize(0)
+			executor.SetWait(wait)
+			opts := DefaultServerOptions()
+			opts.SetBroadcastExecutor(executor)
+			io := NewServer(nil, opts)
+			defer io.executor.Close()
+			nsp := io.Of("/nested", nil)
+
+			conns := []*writeTestConn{}
+			for i := 0; i < 20; i++ {
+				socket, conn := newWriteTestSocket(nsp, SocketId(fmt.Sprintf("socket-%d", i)))
+				conns = append(conns, conn)
+				// a broadcast made by a listener of the outgoing packets
+				socket.OnAnyOutgoing(func(args ...any) {
+					if args[0] == "outer" {
+						nsp.Except(Room(socket.Id())).Emit("nested", args[1])
+					}
+				})
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 10; i++ {
+					nsp.Emit("outer", i)
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the nested broadcasts deadlocked")
+			}
+			io.executor.Close()
+
+			for _, conn := range conns {
+				writes := conn.Writes()
+				outer := []string{}
+				nested := 0
+				for _, write := range writes {
+					if strings.Contains(write, `"outer"`) {
+						outer = append(outer, write)
+					} else {
+						nested++
+					}
+				}
+				// the packets of the successive broadcasts are written in order to each recipient
+				expected := []string{}
+				for i := 0; i < 10; i++ {
+					expected = append(expected, fmt.Sprintf(`2/nested,["outer",%d]`, i))
+				}
+				if !slices.Equal(outer, expected) {
+					t.Fatalf("expected %v, got %v", expected, outer)
+				}
+				if nested != 10*19 {
+					t.Fatalf("expected %d nested packets, got %d", 10*19, nested)
+				}
+			}
+		})
+	}
+}
+
+func TestFanOutExecutorCloseReleasesDispatch(t *testing.T) {
+	opts := &BroadcastExecutor{}
+	opts.SetWorkers(1)
+	opts.SetQueueSize(0)
+	e := newFanOutExecutor(opts)
+
+	block := make(chan struct{})
+	first := e.batch()
+	first.Add(&Socket{id: "a"})
+	first.Dispatch(func(*Socket) { <-block })
+
+	// the worker is busy, so the second broadcast waits for the queue
+	var written atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		second := e.batch()
+		second.Add(&Socket{id: "b"})
+		second.Dispatch(func(*Socket) { written.Store(true) })
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	go e.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the broadcast was not released by Close")
+	}
+	close(block)
+	if !written.Load() {
+		t.Fatal("expected the packet to be written")
+	}
+}
@@ -0,0 +1,240 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/engine.io/v2/events"
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+// The decoder of the parsers which encode each packet as a single binary frame, with the binary data inlined (see
+// [MsgpackParser], [CborParser] and [ProtobufParser]).
+type frameDecoder struct {
+	events.EventEmitter
+
+	log    *log.Log
+	decode func([]byte) (*parser.Packet, error)
+}
+
+func newFrameDecoder(log *log.Log, decode func([]byte) (*parser.Packet, error)) *frameDecoder {
+	return &frameDecoder{EventEmitter: events.New(), log: log, decode: decode}
+}
+
+// Decodes a binary frame, the text frames are rejected.
+func (d *frameDecoder) Add(data any) error {
+	var rdata []byte
+	switch tdata := data.(type) {
+	case string, *strings.Reader, *types.StringBuffer:
+		return errors.New("unexpected string")
+	case []byte:
+		rdata = tdata
+	case io.Reader:
+		if c, ok := tdata.(io.Closer); ok {
+			defer c.Close()
+		}
+		b, err := io.ReadAll(tdata)
+		if err != nil {
+			return err
+		}
+		rdata = b
+	default:
+		return fmt.Errorf("Unknown type: %v", data)
+	}
+
+	packet, err := d.decode(rdata)
+	if err != nil {
+		d.log.Debug("decode err %v", err)
+		return err
+	}
+	d.log.Debug("decoded %v", packet)
+	d.Emit("decoded", packet)
+	return nil
+}
+
+func (d *frameDecoder) Destroy() {
+}
+
+// Returns the type of the packet once encoded as a single frame, since the binary packet types only exist when the
+// attachments are sent separately.
+func framePacketType(t parser.PacketType) parser.PacketType {
+	switch t {
+	case parser.BINARY_EVENT:
+		return parser.EVENT
+	case parser.BINARY_ACK:
+		return parser.ACK
+	}
+	return t
+}
+
+// Converts the buffers and readers of the data to byte slices (and strings), which are inlined in the frame.
+func inlineFrameData(data any) any {
+	switch tdata := data.(type) {
+	case nil:
+		return nil
+	case *types.StringBuffer:
+		return tdata.String()
+	case *strings.Reader:
+		rdata, _ := io.ReadAll(tdata)
+		return string(rdata)
+	case types.BufferInterface:
+		return tdata.Bytes()
+	case []byte:
+		return tdata
+	case io.Reader:
+		if c, ok := tdata.(io.Closer); ok {
+			defer c.Close()
+		}
+		rdata, _ := io.ReadAll(tdata)
+		return rdata
+	case []any:
+		newData := make([]any, 0, len(tdata))
+		for _, v := range tdata {
+			newData = append(newData, inlineFrameData(v))
+		}
+		return newData
+	case map[string]any:
+		newData := make(map[string]any, len(tdata))
+		for k, v := range tdata {
+			newData[k] = inlineFrameData(v)
+		}
+		return newData
+	default:
+		return data
+	}
+}
+
+// Converts the decoded byte slices to buffers, like the ones of the default parser, and the integers to int64 or
+// uint64.
+func normalizeFrameData(data any) any {
+	switch tdata := data.(type) {
+	case []byte:
+		return types.NewBytesBuffer(tdata)
+	case int8:
+		return int64(tdata)
+	case int16:
+		return int64(tdata)
+	case int32:
+		return int64(tdata)
+	case uint8:
+		return uint64(tdata)
+	case uint16:
+		return uint64(tdata)
+	case uint32:
+		return uint64(tdata)
+	case float32:
+		return float64(tdata)
+	case []any:
+		for i, v := range tdata {
+			tdata[i] = normalizeFrameData(v)
+		}
+	case map[string]any:
+		for k, v := range tdata {
+			tdata[k] = normalizeFrameData(v)
+		}
+	}
+	return data
+}
+
+// Returns the value as an unsigned integer, if it is a non-negative integer.
+func frameUint(value any) (uint64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return uint64(v), v >= 0
+	case uint64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Returns the type of a decoded packet, the binary packet types are rejected.
+func frameDecodePacketType(value any) (parser.PacketType, error) {
+	t, ok := frameUint(value)
+	if !ok || t > uint64(parser.CONNECT_ERROR-parser.CONNECT) {
+		return 0, fmt.Errorf("unknown packet type %v", value)
+	}
+	return parser.CONNECT + parser.PacketType(t), nil
+}
+
+func isFramePayloadValid(t parser.PacketType, payload any) bool {
+	switch t {
+	case parser.CONNECT:
+		if payload == nil {
+			return true
+		}
+		_, ok := payload.(map[string]any)
+		return ok
+	case parser.DISCONNECT:
+		return payload == nil
+	case parser.CONNECT_ERROR:
+		switch payload.(type) {
+		case map[string]any, string:
+			return true
+		}
+		return false
+	case parser.EVENT:
+		data, ok := payload.([]any)
+		if ok && len(data) > 0 {
+			event, isString := data[0].(string)
+			return isString && !parser.RESERVED_EVENTS.Has(event)
+		}
+		return false
+	case parser.ACK:
+		_, ok := payload.([]any)
+		return ok
+	}
+	return false
+}
+
+// Returns the object with the "type", "nsp", "data" and "id" fields which is encoded in place of a packet. The fields
+// without value are omitted, since the JS implementations expect them to be undefined (and not null).
+func encodeFramePacket(packet *parser.Packet) map[string]any {
+	rpacket := map[string]any{
+		"type": uint64(framePacketType(packet.Type) - parser.CONNECT),
+		"nsp":  packet.Nsp,
+	}
+	if packet.Data != nil {
+		rpacket["data"] = inlineFrameData(packet.Data)
+	}
+	if packet.Id != nil {
+		rpacket["id"] = *packet.Id
+	}
+	return rpacket
+}
+
+// Decodes a packet which was encoded as an object with the "type", "nsp", "data" and "id" fields.
+func decodeFramePacket(raw map[string]any) (*parser.Packet, error) {
+	normalizeFrameData(raw)
+
+	packetType, err := frameDecodePacketType(raw["type"])
+	if err != nil {
+		return nil, err
+	}
+	packet := &parser.Packet{Type: packetType}
+
+	nsp, ok := raw["nsp"].(string)
+	if !ok {
+		return nil, errors.New("Illegal namespace")
+	}
+	packet.Nsp = nsp
+
+	if rawId, exists := raw["id"]; exists && rawId != nil {
+		id, ok := frameUint(rawId)
+		if !ok {
+			return nil, errors.New("Illegal id")
+		}
+		packet.Id = &id
+	}
+
+	payload := raw["data"]
+	if !isFramePayloadValid(packet.Type, payload) {
+		return nil, errors.New("invalid payload")
+	}
+	packet.Data = payload
+
+	return packet, nil
+}
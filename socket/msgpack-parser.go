@@ -0,0 +1,74 @@
+package socket
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+var msgpack_parser_log = log.NewLog("socket.io:msgpack-parser")
+
+type (
+	// A parser compatible with the "socket.io-msgpack-parser" package, where each packet is encoded as a single
+	// MessagePack object and binary data is sent inline.
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetParser(socket.NewMsgpackParser())
+	//	io := socket.NewServer(nil, opts)
+	//
+	// The clients must use the same parser, which is bundled in "socket.io.msgpack.min.js": when the client is served,
+	// it is sent in place of "socket.io.js" and "socket.io.min.js".
+	//
+	// The decoded binary data are [types.BytesBuffer], like with the default parser, while the integers are decoded as
+	// int64 or uint64 (depending on their encoding) instead of float64.
+	MsgpackParser struct {
+	}
+
+	msgpackEncoder struct {
+	}
+)
+
+func NewMsgpackParser() *MsgpackParser {
+	return &MsgpackParser{}
+}
+
+func (p *MsgpackParser) NewEncoder() parser.Encoder {
+	return &msgpackEncoder{}
+}
+
+func (p *MsgpackParser) NewDecoder() parser.Decoder {
+	return newFrameDecoder(msgpack_parser_log, msgpackDecodePacket)
+}
+
+// Encodes a packet as a single MessagePack object.
+func (e *msgpackEncoder) Encode(packet *parser.Packet) []types.BufferInterface {
+	msgpack_parser_log.Debug("encoding packet %v", packet)
+
+	buf := types.NewBytesBuffer(nil)
+	enc := msgpack.NewEncoder(buf)
+	enc.UseCompactInts(true)
+	// like with the default parser, the structs without "msgpack" tags are encoded with their "json" tags
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(encodeFramePacket(packet)); err != nil {
+		msgpack_parser_log.Error("failed to encode packet %v: %v", packet, err)
+		return nil
+	}
+	return []types.BufferInterface{buf}
+}
+
+func msgpackDecodePacket(data []byte) (*parser.Packet, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	value, err := dec.DecodeInterface()
+	if err != nil {
+		return nil, errors.New("invalid payload")
+	}
+	raw, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid payload")
+	}
+	return decodeFramePacket(raw)
+}
@@ -0,0 +1,159 @@
+package socket
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+// The frames were captured from socket.io-msgpack-parser, the fields are in the order of the JS objects (the "options"
+// field is added by socket.io-client and must be ignored).
+var msgpackParserFrames = []struct {
+	name   string
+	frame  []byte
+	packet *parser.Packet
+}{
+	{
+		name: "CONNECT",
+		// {"type":0,"data":{"token":"abc"},"nsp":"/"}
+		frame: []byte{
+			0x83,
+			0xa4, 't', 'y', 'p', 'e', 0x00,
+			0xa4, 'd', 'a', 't', 'a', 0x81, 0xa5, 't', 'o', 'k', 'e', 'n', 0xa3, 'a', 'b', 'c',
+			0xa3, 'n', 's', 'p', 0xa1, '/',
+		},
+		packet: &parser.Packet{
+			Type: parser.CONNECT,
+			Nsp:  "/",
+			Data: map[string]any{"token": "abc"},
+		},
+	},
+	{
+		name: "EVENT with binary",
+		// {"type":2,"data":["upload",<Buffer 01 02 03>],"options":{"compress":true},"id":1,"nsp":"/chat"}
+		frame: []byte{
+			0x85,
+			0xa4, 't', 'y', 'p', 'e', 0x02,
+			0xa4, 'd', 'a', 't', 'a', 0x92, 0xa6, 'u', 'p', 'l', 'o', 'a', 'd', 0xc4, 0x03, 0x01, 0x02, 0x03,
+			0xa7, 'o', 'p', 't', 'i', 'o', 'n', 's', 0x81, 0xa8, 'c', 'o', 'm', 'p', 'r', 'e', 's', 's', 0xc3,
+			0xa2, 'i', 'd', 0x01,
+			0xa3, 'n', 's', 'p', 0xa5, '/', 'c', 'h', 'a', 't',
+		},
+		packet: &parser.Packet{
+			Type: parser.EVENT,
+			Nsp:  "/chat",
+			Id:   msgpackParserId(1),
+			Data: []any{"upload", []byte{0x01, 0x02, 0x03}},
+		},
+	},
+	{
+		name: "ACK",
+		// {"type":3,"id":5,"data":["ok"],"nsp":"/"}
+		frame: []byte{
+			0x84,
+			0xa4, 't', 'y', 'p', 'e', 0x03,
+			0xa2, 'i', 'd', 0x05,
+			0xa4, 'd', 'a', 't', 'a', 0x91, 0xa2, 'o', 'k',
+			0xa3, 'n', 's', 'p', 0xa1, '/',
+		},
+		packet: &parser.Packet{
+			Type: parser.ACK,
+			Nsp:  "/",
+			Id:   msgpackParserId(5),
+			Data: []any{"ok"},
+		},
+	},
+	{
+		name: "CONNECT_ERROR",
+		// {"type":4,"data":{"message":"Not authorized","data":{"code":401}},"nsp":"/admin"}
+		frame: []byte{
+			0x83,
+			0xa4, 't', 'y', 'p', 'e', 0x04,
+			0xa4, 'd', 'a', 't', 'a', 0x82,
+			0xa7, 'm', 'e', 's', 's', 'a', 'g', 'e',
+			0xae, 'N', 'o', 't', ' ', 'a', 'u', 't', 'h', 'o', 'r', 'i', 'z', 'e', 'd',
+			0xa4, 'd', 'a', 't', 'a', 0x81, 0xa4, 'c', 'o', 'd', 'e', 0xcd, 0x01, 0x91,
+			0xa3, 'n', 's', 'p', 0xa6, '/', 'a', 'd', 'm', 'i', 'n',
+		},
+		packet: &parser.Packet{
+			Type: parser.CONNECT_ERROR,
+			Nsp:  "/admin",
+			Data: map[string]any{"message": "Not authorized", "data": map[string]any{"code": uint64(401)}},
+		},
+	},
+}
+
+func msgpackParserId(id uint64) *uint64 {
+	return &id
+}
+
+// Decodes a frame as a plain object, without the fields ignored by the parser.
+func msgpackParserObject(t *testing.T, frame []byte) any {
+	value, err := msgpack.NewDecoder(bytes.NewReader(frame)).DecodeInterface()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := value.(map[string]any)
+	delete(raw, "options")
+	return inlineFrameData(normalizeFrameData(raw))
+}
+
+func TestMsgpackParserDecode(t *testing.T) {
+	for _, test := range msgpackParserFrames {
+		t.Run(test.name, func(t *testing.T) {
+			var packet *parser.Packet
+			decoder := NewMsgpackParser().NewDecoder()
+			decoder.On("decoded", func(args ...any) {
+				packet = args[0].(*parser.Packet)
+			})
+			if err := decoder.Add(test.frame); err != nil {
+				t.Fatal(err)
+			}
+			if packet == nil {
+				t.Fatal("expected a decoded packet")
+			}
+			if packet.Type != test.packet.Type || packet.Nsp != test.packet.Nsp || !reflect.DeepEqual(packet.Id, test.packet.Id) {
+				t.Fatalf("unexpected packet %+v", packet)
+			}
+			// the binary data are decoded as buffers
+			if data := inlineFrameData(packet.Data); !reflect.DeepEqual(data, test.packet.Data) {
+				t.Fatalf("unexpected data %#v", data)
+			}
+		})
+	}
+}
+
+func TestMsgpackParserEncode(t *testing.T) {
+	for _, test := range msgpackParserFrames {
+		t.Run(test.name, func(t *testing.T) {
+			buffers := NewMsgpackParser().NewEncoder().Encode(test.packet)
+			if len(buffers) != 1 {
+				t.Fatalf("expected a single frame, got %d", len(buffers))
+			}
+			// the order of the fields is not significant
+			if encoded, expected := msgpackParserObject(t, buffers[0].Bytes()), msgpackParserObject(t, test.frame); !reflect.DeepEqual(encoded, expected) {
+				t.Fatalf("unexpected frame %#v, expected %#v", encoded, expected)
+			}
+		})
+	}
+}
+
+func TestMsgpackParserDecodeInvalidFrames(t *testing.T) {
+	for name, frame := range map[string]any{
+		"text frame":        "2[\"hello\"]",
+		"not an object":     []byte{0x92, 0x01, 0x02},
+		"unknown type":      []byte{0x82, 0xa4, 't', 'y', 'p', 'e', 0x07, 0xa3, 'n', 's', 'p', 0xa1, '/'},
+		"missing nsp":       []byte{0x81, 0xa4, 't', 'y', 'p', 'e', 0x01},
+		"reserved event":    []byte{0x83, 0xa4, 't', 'y', 'p', 'e', 0x02, 0xa4, 'd', 'a', 't', 'a', 0x91, 0xa7, 'c', 'o', 'n', 'n', 'e', 'c', 't', 0xa3, 'n', 's', 'p', 0xa1, '/'},
+		"binary event type": []byte{0x82, 0xa4, 't', 'y', 'p', 'e', 0x05, 0xa3, 'n', 's', 'p', 0xa1, '/'},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := NewMsgpackParser().NewDecoder().Add(frame); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package socket
+
+import (
+	"time"
+)
+
+type (
+	// Overrides an option of the server for a namespace, see [Server.Of].
+	NamespaceOption func(*NamespaceOptions)
+
+	// The options of a namespace, which override the ones of the server.
+	NamespaceOptions struct {
+		// The adapter of the namespace.
+		adapter AdapterConstructor
+
+		// Whether the connection state recovery of the server is overridden.
+		overrideRecovery bool
+
+		// The connection state recovery of the namespace, nil if disabled.
+		connectionStateRecovery *ConnectionStateRecovery
+
+		// The maximum number of connected sockets (0 means no limit).
+		maxSockets *int
+
+		// The delay within which a client connecting to the namespace must be connected, nil for the one of the server.
+		connectTimeout *time.Duration
+
+		// The limits of the incoming packets.
+		packetLimits *PacketLimits
+	}
+)
+
+// Sets the adapter of the namespace, instead of the one of the server.
+//
+//	io.Of("/chat", nil, socket.WithAdapter(&redis.RedisAdapterBuilder{Redis: redisClient}))
+func WithAdapter(adapter AdapterConstructor) NamespaceOption {
+	return func(o *NamespaceOptions) {
+		o.adapter = adapter
+	}
+}
+
+// Sets the connection state recovery of the namespace, instead of the one of the server. A nil value disables the
+// recovery for the namespace.
+//
+// Unless an adapter is set with [WithAdapter] or the server has a custom adapter, the namespace uses a
+// [SessionAwareAdapter] when the recovery is enabled, and the in-memory adapter otherwise.
+//
+//	io.Of("/telemetry", nil, socket.WithRecovery(nil))
+func WithRecovery(connectionStateRecovery *ConnectionStateRecovery) NamespaceOption {
+	return func(o *NamespaceOptions) {
+		o.overrideRecovery = true
+		o.connectionStateRecovery = connectionStateRecovery
+	}
+}
+
+// Sets the maximum number of connected sockets, the connections exceeding it are rejected with the "Namespace is
+// full" error. A slot is reserved as soon as the connection is attempted, and released if the connection is rejected
+// (by a middleware or its timeout for example), if the client closes before it completes, or once the socket
+// disconnects.
+func WithMaxSockets(maxSockets int) NamespaceOption {
+	return func(o *NamespaceOptions) {
+		o.maxSockets = &maxSockets
+	}
+}
+
+// Sets the delay within which a client, which is not connected to any namespace yet, must be connected to the
+// namespace, instead of the connect timeout of the server (see [Server.SetConnectTimeout]). The delay starts upon the
+// connection attempt, so that a namespace with slow middlewares may allow more time.
+//
+//	io.Of("/admin", nil, socket.WithConnectTimeout(30*time.Second))
+func WithConnectTimeout(connectTimeout time.Duration) NamespaceOption {
+	return func(o *NamespaceOptions) {
+		o.connectTimeout = &connectTimeout
+	}
+}
+
+// Sets the limits of the incoming packets of the namespace, instead of the ones of the server. The limits of the
+// server are still checked before the packets are decoded, so they may only be lowered.
+//
+//	io.Of("/chat", nil, socket.WithPacketLimits(chatLimits))
+func WithPacketLimits(packetLimits *PacketLimits) NamespaceOption {
+	return func(o *NamespaceOptions) {
+		o.packetLimits = packetLimits
+	}
+}
+
+func NewNamespaceOptions(opts ...NamespaceOption) *NamespaceOptions {
+	o := &NamespaceOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+func (o *NamespaceOptions) Adapter() AdapterConstructor {
+	return o.adapter
+}
+
+// Whether the connection state recovery of the server is overridden.
+func (o *NamespaceOptions) OverridesRecovery() bool {
+	return o.overrideRecovery
+}
+
+// Returns the connection state recovery of the namespace, nil if disabled.
+func (o *NamespaceOptions) GetRawConnectionStateRecovery() *ConnectionStateRecovery {
+	return o.connectionStateRecovery
+}
+func (o *NamespaceOptions) ConnectionStateRecovery() *ConnectionStateRecovery {
+	if o.connectionStateRecovery == nil {
+		return &ConnectionStateRecovery{}
+	}
+
+	return o.connectionStateRecovery
+}
+
+func (o *NamespaceOptions) GetRawMaxSockets() *int {
+	return o.maxSockets
+}
+func (o *NamespaceOptions) MaxSockets() int {
+	if o.maxSockets == nil {
+		return 0
+	}
+
+	return *o.maxSockets
+}
+
+func (o *NamespaceOptions) GetRawConnectTimeout() *time.Duration {
+	return o.connectTimeout
+}
+
+func (o *NamespaceOptions) GetRawPacketLimits() *PacketLimits {
+	return o.packetLimits
+}
+func (o *NamespaceOptions) PacketLimits() *PacketLimits {
+	if o.packetLimits == nil {
+		return &PacketLimits{}
+	}
+
+	return o.packetLimits
+}
+
+// Returns the options of the namespace, once the options of the server are applied.
+func (o *NamespaceOptions) resolve(server *Server) *NamespaceOptions {
+	resolved := *o
+	if !resolved.overrideRecovery && server.Opts() != nil {
+		resolved.connectionStateRecovery = server.Opts().GetRawConnectionStateRecovery()
+	}
+	if resolved.packetLimits == nil && server.Opts() != nil {
+		resolved.packetLimits = server.Opts().GetRawPacketLimits()
+	}
+	return &resolved
+}
@@ -0,0 +1,151 @@
+package socket
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNamespaceOptions(t *testing.T) {
+	serverLimits := &PacketLimits{}
+	serverLimits.SetMaxArgs(10)
+	opts := DefaultServerOptions()
+	opts.SetConnectionStateRecovery(&ConnectionStateRecovery{})
+	opts.SetPacketLimits(serverLimits)
+	io := NewServer(nil, opts)
+
+	// the options of the server apply by default
+	nsp := io.Of("/default", nil)
+	if _, ok := nsp.Adapter().(*sessionAwareAdapter); !ok {
+		t.Fatal("expected a session aware adapter with the recovery of the server")
+	}
+	if nsp.Options().GetRawPacketLimits() != serverLimits || nsp.Options().MaxSockets() != 0 || nsp.Options().GetRawConnectTimeout() != nil {
+		t.Fatal("expected the options of the server")
+	}
+
+	limits := &PacketLimits{}
+	limits.SetMaxArgs(2)
+	nsp = io.Of("/custom", nil,
+		WithRecovery(nil),
+		WithPacketLimits(limits),
+		WithMaxSockets(10),
+		WithConnectTimeout(5*time.Second),
+	)
+	if _, ok := nsp.Adapter().(*sessionAwareAdapter); ok {
+		t.Fatal("expected an in-memory adapter without recovery")
+	}
+	if nsp.Options().GetRawConnectionStateRecovery() != nil {
+		t.Fatal("expected the recovery to be disabled")
+	}
+	if nsp.Options().PacketLimits() != limits || nsp.Options().MaxSockets() != 10 || *nsp.Options().GetRawConnectTimeout() != 5*time.Second {
+		t.Fatal("expected the options of the namespace")
+	}
+
+	nsp = io.Of("/legacy", nil, WithAdapter(&legacyTestAdapterBuilder{}))
+	if _, ok := nsp.Adapter().(*legacyTestAdapter); !ok {
+		t.Fatalf("expected the adapter of the namespace, got %T", nsp.Adapter())
+	}
+}
+
+// Connects a client to the namespace, the middleware of the namespace reads the outcome of its middlewares from auth.
+func connectTestClient(nsp Namespace, id string, auth string) (*Client, *writeTestConn) {
+	client, conn := newWriteTestClient(nsp.Server(), id)
+	nsp.Add(client, auth, func(socket *Socket) {
+		client.sockets.Store(socket.Id(), socket)
+		client.nsps.Store(nsp.Name(), socket)
+	})
+	return client, conn
+}
+
+func expectTestConnectError(t *testing.T, conn *writeTestConn, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for len(conn.Writes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if writes := conn.Writes(); len(writes) != 1 || !strings.HasPrefix(writes[0], "4/limited,") || !strings.Contains(writes[0], message) {
+		t.Fatalf("expected the %q error, got %v", message, writes)
+	}
+}
+
+func expectTestReservedSlots(t *testing.T, nsp Namespace, expected int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for nsp.(*namespace).reservedSlots.Load() != expected && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if actual := nsp.(*namespace).reservedSlots.Load(); actual != expected {
+		t.Fatalf("expected %d reserved slots, got %d", expected, actual)
+	}
+}
+
+func TestNamespaceMaxSockets(t *testing.T) {
+	io := NewServer(nil, nil)
+	nsp := io.Of("/limited", nil, WithMaxSockets(1))
+	nsp.SetMiddlewareTimeout(50 * time.Millisecond)
+	nsp.Use(func(socket *Socket, next func(*ExtendedError)) {
+		switch socket.Handshake().Auth {
+		case "reject":
+			next(NewExtendedError("unauthorized", nil))
+		case "accept":
+			next(nil)
+		}
+	})
+
+	// a stalled connection holds the slot until it times out
+	_, stalled := connectTestClient(nsp, "stalled", "stall")
+	_, full := connectTestClient(nsp, "full", "accept")
+	expectTestConnectError(t, full, "Namespace is full")
+	expectTestConnectError(t, stalled, ErrMiddlewareTimeout.Error())
+	expectTestReservedSlots(t, nsp, 0)
+
+	// a rejected connection releases the slot
+	_, rejected := connectTestClient(nsp, "rejected", "reject")
+	expectTestConnectError(t, rejected, "unauthorized")
+	expectTestReservedSlots(t, nsp, 0)
+
+	// a client closed while its middlewares run releases the slot
+	nsp.SetMiddlewareTimeout(0)
+	closed, _ := connectTestClient(nsp, "closed", "stall")
+	expectTestReservedSlots(t, nsp, 1)
+	closed.onclose("transport close")
+	expectTestReservedSlots(t, nsp, 0)
+
+	// a connected socket holds the slot until it disconnects
+	accepted, _ := connectTestClient(nsp, "accepted", "accept")
+	deadline := time.Now().Add(time.Second)
+	for nsp.Sockets().Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if nsp.Sockets().Len() != 1 {
+		t.Fatalf("expected a connected socket, got %d", nsp.Sockets().Len())
+	}
+	expectTestReservedSlots(t, nsp, 1)
+	_, full = connectTestClient(nsp, "full", "accept")
+	expectTestConnectError(t, full, "Namespace is full")
+	accepted.onclose("transport close")
+	expectTestReservedSlots(t, nsp, 0)
+	if nsp.Sockets().Len() != 0 {
+		t.Fatal("expected the socket to be disconnected")
+	}
+}
+
+func TestNamespaceConnectTimeout(t *testing.T) {
+	io := NewServer(nil, nil)
+	nsp := io.Of("/limited", nil, WithMaxSockets(1), WithConnectTimeout(20*time.Millisecond))
+	// the middleware never completes
+	nsp.Use(func(*Socket, func(*ExtendedError)) {})
+
+	client, conn := newWriteTestClient(io, "slow")
+	client.setConnectTimeout(time.Hour)
+	client.doConnect("/limited", nil)
+	expectTestReservedSlots(t, nsp, 1)
+
+	// the client is closed by the connect timeout of the namespace, which releases the slot
+	expectTestReservedSlots(t, nsp, 0)
+	if conn.ReadyState() != "closed" {
+		t.Fatal("expected the client to be closed")
+	}
+}
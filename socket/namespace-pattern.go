@@ -0,0 +1,93 @@
+package socket
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var namespacePatternParam = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// A route-style pattern of dynamic namespaces, where each `:name` segment matches a single segment of the name of a
+// namespace.
+//
+//	pattern, _ := socket.ParseNamespacePattern("/tenant/:tenantId/board/:boardId")
+//	pattern.Match("/tenant/42/board/7") // map[boardId:7 tenantId:42], true
+type NamespacePattern struct {
+	pattern string
+	params  []string
+	regex   *regexp.Regexp
+}
+
+// Parses a route-style pattern, the name of each parameter must be a valid identifier and may appear only once.
+func ParseNamespacePattern(pattern string) (*NamespacePattern, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf(`invalid namespace pattern "%s": it must start with "/"`, pattern)
+	}
+
+	p := &NamespacePattern{pattern: pattern}
+	expr := &strings.Builder{}
+	expr.WriteString("^")
+	for _, segment := range strings.Split(pattern, "/")[1:] {
+		expr.WriteString("/")
+		if !strings.HasPrefix(segment, ":") {
+			expr.WriteString(regexp.QuoteMeta(segment))
+			continue
+		}
+		name := segment[1:]
+		if !namespacePatternParam.MatchString(name) {
+			return nil, fmt.Errorf(`invalid namespace pattern "%s": invalid parameter name "%s"`, pattern, name)
+		}
+		for _, param := range p.params {
+			if param == name {
+				return nil, fmt.Errorf(`invalid namespace pattern "%s": duplicate parameter "%s"`, pattern, name)
+			}
+		}
+		p.params = append(p.params, name)
+		expr.WriteString(`([^/]+)`)
+	}
+	expr.WriteString("$")
+
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf(`invalid namespace pattern "%s": %w`, pattern, err)
+	}
+	p.regex = regex
+	return p, nil
+}
+
+// Like [ParseNamespacePattern], but panics if the pattern is invalid.
+func MustParseNamespacePattern(pattern string) *NamespacePattern {
+	p, err := ParseNamespacePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Returns the parameters extracted from the name of a namespace, and whether the name matches the pattern.
+func (p *NamespacePattern) Match(name string) (map[string]string, bool) {
+	matches := p.regex.FindStringSubmatch(name)
+	if matches == nil {
+		return nil, false
+	}
+	params := make(map[string]string, len(p.params))
+	for i, param := range p.params {
+		params[param] = matches[i+1]
+	}
+	return params, true
+}
+
+// Returns the names of the parameters, in order.
+func (p *NamespacePattern) Params() []string {
+	return append([]string{}, p.params...)
+}
+
+// The regular expression equivalent to the pattern.
+func (p *NamespacePattern) Regexp() *regexp.Regexp {
+	return p.regex
+}
+
+func (p *NamespacePattern) String() string {
+	return p.pattern
+}
@@ -0,0 +1,31 @@
+package socket
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerOfPattern(t *testing.T) {
+	io := NewServer(nil, nil)
+
+	for _, pattern := range []string{"tenant/:id", "/tenant/:1d", "/tenant/:id/board/:id"} {
+		if _, err := io.OfPattern(pattern, nil); err == nil {
+			t.Fatalf("expected an error for the pattern %q", pattern)
+		}
+	}
+
+	parentNsp, err := io.OfPattern("/tenant/:tenantId/board/:boardId", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, ok := parentNsp.Pattern().Match("/tenant/42/board/7")
+	if !ok {
+		t.Fatal("expected the name to match")
+	}
+	if expected := map[string]string{"tenantId": "42", "boardId": "7"}; !reflect.DeepEqual(params, expected) {
+		t.Fatalf("unexpected params %v", params)
+	}
+	if _, ok := parentNsp.Pattern().Match("/tenant/42"); ok {
+		t.Fatal("expected the name not to match")
+	}
+}
@@ -1,6 +1,8 @@
 package socket
 
 import (
+	"context"
+	"regexp"
 	"time"
 
 	"github.com/zishang520/engine.io/v2/events"
@@ -78,10 +80,22 @@ type Namespace interface {
 	Name() string
 	Ids() uint64
 	Fns() *types.Slice[func(*Socket, func(*ExtendedError))]
+	RoomHistory() *RoomHistory
+	Rpc() *Rpc
+	Params() map[string]string
+
+	// The options of the namespace, which override the ones of the server.
+	Options() *NamespaceOptions
+
+	// Sets the options of the namespace, should be called before calling Construct()
+	SetOptions(*NamespaceOptions)
 
 	// Construct() should be called after calling Prototype()
 	Construct(*Server, string)
 
+	// Sets the parameters extracted from the name of a child namespace, see [Server.OfPattern].
+	SetParams(map[string]string)
+
 	// @protected
 	//
 	// Initializes the `Adapter` for n nsp.
@@ -95,15 +109,60 @@ type Namespace interface {
 	// Sets up namespace middleware.
 	Use(func(*Socket, func(*ExtendedError))) Namespace
 
+	// Stops accepting new connections.
+	Drain() Namespace
+
+	// Whether the namespace stopped accepting new connections.
+	Draining() bool
+
+	// Sets up namespace middleware, with a context.
+	UseContext(func(context.Context, *Socket) error) Namespace
+
+	// Sets the maximum duration of the middlewares of an incoming client.
+	SetMiddlewareTimeout(time.Duration) Namespace
+
+	// Returns the maximum duration of the middlewares of an incoming client.
+	MiddlewareTimeout() time.Duration
+
+	// Sets the maximum duration of the middlewares of an incoming event.
+	SetEventMiddlewareTimeout(time.Duration) Namespace
+
+	// Returns the maximum duration of the middlewares of an incoming event.
+	EventMiddlewareTimeout() time.Duration
+
+	// Sets the hook which is called before a socket joins a room.
+	SetJoinGuard(JoinGuard) Namespace
+
+	// Returns the hook which is called before a socket joins a room.
+	JoinGuard() JoinGuard
+
+	// Attaches metadata to a room, nil removes it.
+	SetRoomMeta(Room, *RoomMeta) Namespace
+
+	// Gets the metadata of a room.
+	RoomMeta(Room) (*RoomMeta, bool)
+
+	// Removes the metadata of a room.
+	DelRoomMeta(Room) Namespace
+
 	// Targets a room when emitting.
 	To(...Room) *BroadcastOperator
 
 	// Targets a room when emitting.
 	In(...Room) *BroadcastOperator
 
+	// Targets the sockets which have joined all the given rooms when emitting.
+	InAll(...Room) *BroadcastOperator
+
+	// Targets the rooms matching a pattern when emitting.
+	ToPattern(...string) *BroadcastOperator
+
 	// Excludes a room when emitting.
 	Except(...Room) *BroadcastOperator
 
+	// Excludes the rooms matching a pattern when emitting.
+	ExceptPattern(...string) *BroadcastOperator
+
 	// Adds a new client.
 	Add(*Client, any, func(*Socket))
 
@@ -138,12 +197,22 @@ type Namespace interface {
 	// and is in the middle of a request-response cycle).
 	Volatile() *BroadcastOperator
 
+	// Sets a modifier for a subsequent event emission that at most one event emitted with the given key is delivered
+	// to each client per window.
+	Coalesce(string, time.Duration) *BroadcastOperator
+
 	// Sets a modifier for a subsequent event emission that the event data will only be broadcast to the current node.
 	Local() *BroadcastOperator
 
 	// Adds a timeout in milliseconds for the next operation
 	Timeout(time.Duration) *BroadcastOperator
 
+	// Only targets the sockets for which the given function returns `true`.
+	Where(func(SocketDetails) bool) *BroadcastOperator
+
+	// Only targets the sockets whose field matches the given value.
+	WhereField(string, PredicateOperator, any) *BroadcastOperator
+
 	// Returns the matching socket instances
 	//
 	// Deprecated: this method will be removed in the next major release, please use [Server.ServerSideEmit] or [BroadcastOperator.FetchSockets] instead.
@@ -166,6 +235,31 @@ type ParentNamespace interface {
 	Namespace
 
 	CreateChild(string) Namespace
+
+	// Removes a child namespace, returns whether it was a child of the namespace.
+	RemoveChild(Namespace) bool
+
+	// The child namespaces which were created so far.
+	Children() []Namespace
+
+	// The pattern of the names of the child namespaces, see [Server.OfPattern].
+	SetPattern(*NamespacePattern)
+	Pattern() *NamespacePattern
+
+	// The regular expression matching the names of the child namespaces, which is sent to the other Socket.IO
+	// servers of the cluster with the operations on the sockets. It is nil for a parent namespace created with a
+	// function.
+	SetRegexp(*regexp.Regexp)
+	Regexp() *regexp.Regexp
+}
+
+// The options of [Server.DeleteNamespace].
+type DeleteNamespaceOptions struct {
+	// The reason of the disconnection of the sockets ("server namespace delete" by default)
+	Reason string
+	// Whether to close the underlying connections of the sockets. Unlike [Socket.Disconnect] with `true`, a
+	// connection is only closed if its client is not connected to another namespace.
+	Close bool
 }
 
 type ExtendedError struct {
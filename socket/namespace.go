@@ -1,6 +1,7 @@
 package socket
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync/atomic"
@@ -9,13 +10,17 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/zishang520/engine.io/v2/log"
 	"github.com/zishang520/engine.io/v2/types"
+	"github.com/zishang520/engine.io/v2/utils"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
 	"github.com/zishang520/socket.io/v2/socket"
 )
 
 var (
 	namespace_log = log.NewLog("socket.io:namespace")
 
-	NAMESPACE_RESERVED_EVENTS = types.NewSet("connect", "connection", "new_namespace")
+	NAMESPACE_RESERVED_EVENTS = types.NewSet("connect", "connection", "new_namespace", "slow_consumer", "delete_namespace", PARENT_NAMESPACE_REQUEST_EVENT, ROOM_META_EVENT)
+
+	ErrMiddlewareTimeout = errors.New("middleware timeout")
 )
 
 // A namespace is a communication channel that allows you to split the logic of your application over a single shared
@@ -88,6 +93,25 @@ type namespace struct {
 
 	_fns *types.Slice[func(*Socket, func(*ExtendedError))]
 
+	_joinGuard atomic.Pointer[JoinGuard]
+	// the metadata of the rooms, when the adapter is not a RoomRegistryAdapter
+	_roomMetas *types.Map[Room, *RoomMeta]
+
+	middlewareTimeout      atomic.Int64
+	eventMiddlewareTimeout atomic.Int64
+
+	draining atomic.Bool
+
+	roomHistory *RoomHistory
+
+	rpc *Rpc
+
+	params map[string]string
+
+	opts *NamespaceOptions
+	// the slots of the sockets which are connected or connecting, see [WithMaxSockets]
+	reservedSlots atomic.Int64
+
 	_cleanup func()
 }
 
@@ -95,9 +119,13 @@ func MakeNamespace() Namespace {
 	n := &namespace{
 		StrictEventEmitter: socket.NewStrictEventEmitter(),
 
-		sockets:  &types.Map[SocketId, *Socket]{},
-		_fns:     types.NewSlice[func(*Socket, func(*ExtendedError))](),
-		_cleanup: nil,
+		sockets:     &types.Map[SocketId, *Socket]{},
+		_roomMetas:  &types.Map[Room, *RoomMeta]{},
+		_fns:        types.NewSlice[func(*Socket, func(*ExtendedError))](),
+		roomHistory: NewRoomHistory(),
+		rpc:         NewRpc(),
+		opts:        NewNamespaceOptions(),
+		_cleanup:    nil,
 	}
 
 	n.Prototype(n)
@@ -105,9 +133,10 @@ func MakeNamespace() Namespace {
 	return n
 }
 
-func NewNamespace(server *Server, name string) Namespace {
+func NewNamespace(server *Server, name string, opts ...NamespaceOption) Namespace {
 	n := MakeNamespace()
 
+	n.SetOptions(NewNamespaceOptions(opts...))
 	n.Construct(server, name)
 
 	return n
@@ -149,9 +178,67 @@ func (n *namespace) Fns() *types.Slice[func(*Socket, func(*ExtendedError))] {
 	return n._fns
 }
 
+// The opt-in history of the rooms of the namespace.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	myNamespace.RoomHistory().Enable("room-101", &socket.RoomHistoryOptions{
+//		MaxEvents:    100,
+//		MaxAge:       time.Hour,
+//		ReplayOnJoin: true,
+//	})
+func (n *namespace) RoomHistory() *RoomHistory {
+	return n.roomHistory
+}
+
+// The RPC layer of the namespace.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	socket.RegisterProcedure(myNamespace.Rpc(), "sum", func(ctx context.Context, client *socket.Socket, req *SumRequest) (*SumResponse, error) {
+//		return &SumResponse{Sum: req.A + req.B}, nil
+//	})
+func (n *namespace) Rpc() *Rpc {
+	return n.rpc
+}
+
+// The parameters extracted from the name of the namespace, if it was created by a parent namespace with a pattern.
+//
+//	boards, _ := io.OfPattern("/tenant/:tenantId/board/:boardId", nil)
+//	boards.Use(func(client *socket.Socket, next func(*socket.ExtendedError)) {
+//		tenantId := client.Nsp().Params()["tenantId"]
+//		next(nil)
+//	})
+//
+// The returned map must not be modified.
+func (n *namespace) Params() map[string]string {
+	if n.params == nil {
+		return map[string]string{}
+	}
+	return n.params
+}
+
+func (n *namespace) SetParams(params map[string]string) {
+	n.params = params
+}
+
+// The options of the namespace, see [Server.Of].
+func (n *namespace) Options() *NamespaceOptions {
+	return n.opts
+}
+
+// Sets the options of the namespace, must be called before Construct().
+func (n *namespace) SetOptions(opts *NamespaceOptions) {
+	if opts == nil {
+		opts = NewNamespaceOptions()
+	}
+	n.opts = opts
+}
+
 func (n *namespace) Construct(server *Server, name string) {
 	n.server = server
 	n.name = name
+	n.opts = n.opts.resolve(server)
 	n.Proto().InitAdapter()
 }
 
@@ -161,6 +248,22 @@ func (n *namespace) Construct(server *Server, name string) {
 // Run upon changing adapter by [Server.Adapter]
 // in addition to the constructor.
 func (n *namespace) InitAdapter() {
+	if adapter := n.opts.Adapter(); adapter != nil {
+		n.adapter = adapter.New(n)
+		return
+	}
+	if n.opts.OverridesRecovery() {
+		// the default adapter of the server depends on its connection state recovery
+		switch n.server.Adapter().(type) {
+		case *AdapterBuilder, *SessionAwareAdapterBuilder:
+			if n.opts.GetRawConnectionStateRecovery() != nil {
+				n.adapter = (&SessionAwareAdapterBuilder{}).New(n)
+			} else {
+				n.adapter = (&AdapterBuilder{}).New(n)
+			}
+			return
+		}
+	}
 	n.adapter = n.server.Adapter().New(n)
 }
 
@@ -179,6 +282,162 @@ func (n *namespace) Use(fn func(*Socket, func(*ExtendedError))) Namespace {
 	return n
 }
 
+// Registers a middleware with a context, as an alternative to the callback style of [Namespace.Use]. The middleware
+// runs on its own goroutine, and the context is cancelled once the middlewares of the namespace complete, or once their
+// timeout is reached (see [Namespace.SetMiddlewareTimeout]).
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	myNamespace.UseContext(func(ctx context.Context, socket *socket.Socket) error {
+//		user, err := sessions.Load(ctx, socket.Handshake().Auth)
+//		if err != nil {
+//			return socket.NewExtendedError("unauthorized", map[string]any{"reason": err.Error()})
+//		}
+//		socket.SetData(user)
+//		return nil
+//	})
+//
+// Param: fn - the middleware function, an [*ExtendedError] is sent as is to the client
+func (n *namespace) UseContext(fn func(context.Context, *Socket) error) Namespace {
+	return n.Use(func(socket *Socket, next func(*ExtendedError)) {
+		go func() {
+			defer socket.recoverConnectPanic()
+
+			ctx := socket.middlewareCtx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if err := fn(ctx, socket); err != nil {
+				var extendedError *ExtendedError
+				if !errors.As(err, &extendedError) {
+					extendedError = NewExtendedError(err.Error(), nil)
+				}
+				next(extendedError)
+				return
+			}
+			next(nil)
+		}()
+	})
+}
+
+// Sets the maximum duration of the middlewares of an incoming client, the connection is rejected with the
+// "middleware timeout" error once it is reached. The timeout applies to the whole chain, not to each middleware.
+//
+// Param: timeout - the timeout, or 0 to wait indefinitely (the default)
+func (n *namespace) SetMiddlewareTimeout(timeout time.Duration) Namespace {
+	n.middlewareTimeout.Store(int64(timeout))
+	return n
+}
+
+// Returns the maximum duration of the middlewares of an incoming client.
+func (n *namespace) MiddlewareTimeout() time.Duration {
+	return time.Duration(n.middlewareTimeout.Load())
+}
+
+// Sets the maximum duration of the middlewares of an incoming event (see [Socket.Use]), the event is discarded and
+// the [ErrMiddlewareTimeout] error is emitted on the socket once it is reached.
+//
+// Param: timeout - the timeout, or 0 to wait indefinitely (the default)
+func (n *namespace) SetEventMiddlewareTimeout(timeout time.Duration) Namespace {
+	n.eventMiddlewareTimeout.Store(int64(timeout))
+	return n
+}
+
+// Returns the maximum duration of the middlewares of an incoming event.
+func (n *namespace) EventMiddlewareTimeout() time.Duration {
+	return time.Duration(n.eventMiddlewareTimeout.Load())
+}
+
+// Sets the hook which is called before a socket joins a room. Returning an error rejects the join, and the error is
+// returned by [Socket.JoinE].
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	myNamespace.SetJoinGuard(func(socket socket.SocketDetails, room socket.Room, meta *socket.RoomMeta) error {
+//		if meta != nil && meta.Owner == "admin" && socket.Handshake().Auth == nil {
+//			return errors.New("unauthorized")
+//		}
+//		return nil
+//	})
+//
+// Param: JoinGuard - the hook, or nil to remove it
+func (n *namespace) SetJoinGuard(guard JoinGuard) Namespace {
+	if guard == nil {
+		n._joinGuard.Store(nil)
+	} else {
+		n._joinGuard.Store(&guard)
+	}
+	return n
+}
+
+// Returns the hook which is called before a socket joins a room.
+func (n *namespace) JoinGuard() JoinGuard {
+	if guard := n._joinGuard.Load(); guard != nil {
+		return *guard
+	}
+	return nil
+}
+
+// Attaches metadata to a room, see [RoomMeta].
+//
+// The metadata is kept by the adapter if it implements [RoomRegistryAdapter] (and replicated to the other Socket.IO
+// servers of the cluster by a cluster adapter), otherwise by the namespace on the current server only.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	myNamespace.SetRoomMeta("room-101", &socket.RoomMeta{Owner: "admin", MaxMembers: 10})
+//
+// Param: Room - the room
+//
+// Param: *RoomMeta - the metadata, or nil to remove it
+func (n *namespace) SetRoomMeta(room Room, meta *RoomMeta) Namespace {
+	if registry, ok := n.adapter.(RoomRegistryAdapter); ok {
+		registry.SetRoomMeta(room, meta)
+	} else if meta == nil {
+		n._roomMetas.Delete(room)
+	} else {
+		if meta.CreatedAt == 0 {
+			meta.CreatedAt = time.Now().UnixMilli()
+		}
+		n._roomMetas.Store(room, meta)
+	}
+	return n
+}
+
+// Gets the metadata of a room.
+func (n *namespace) RoomMeta(room Room) (*RoomMeta, bool) {
+	if registry, ok := n.adapter.(RoomRegistryAdapter); ok {
+		return registry.RoomMeta(room)
+	}
+	return n._roomMetas.Load(room)
+}
+
+// Removes the metadata of a room.
+func (n *namespace) DelRoomMeta(room Room) Namespace {
+	return n.SetRoomMeta(room, nil)
+}
+
+// Checks whether a socket is allowed to join a room, through the adapter if it implements [RoomRegistryAdapter].
+//
+// Otherwise, the capacity of the room is checked against the members known to the current server, before they are
+// added by [Adapter.AddAll].
+func checkJoin(nsp Namespace, socket SocketDetails, room Room) error {
+	adapter := nsp.Adapter()
+	if registry, ok := adapter.(RoomRegistryAdapter); ok {
+		return registry.CheckJoin(socket, room)
+	}
+	size := 0
+	if members, ok := adapter.Rooms().Load(room); ok {
+		if members.Has(socket.Id()) {
+			// already a member
+			return nil
+		}
+		size = members.Len()
+	}
+	meta, _ := nsp.RoomMeta(room)
+	return checkRoomJoin(nsp, socket, room, meta, size)
+}
+
 // Executes the middleware for an incoming client.
 //
 // Param: socket - the socket that will get added
@@ -186,28 +445,66 @@ func (n *namespace) Use(fn func(*Socket, func(*ExtendedError))) Namespace {
 // Param: fn - last fn call in the middleware
 func (n *namespace) run(socket *Socket, fn func(err *ExtendedError)) {
 	fns := n._fns.All()
-	if length := len(fns); length > 0 {
-		var run func(i int)
-		run = func(i int) {
-			fns[i](socket, func(err *ExtendedError) {
-				// upon error, short-circuit
-				if err != nil {
-					fn(err)
-					return
-				}
-				// if no middleware left, summon callback
-				if i >= length-1 {
-					fn(nil)
-					return
-				}
-				// go on to next
-				run(i + 1)
-			})
-		}
-		run(0)
-	} else {
+	length := len(fns)
+	if length == 0 {
 		fn(nil)
+		return
 	}
+
+	// the timeout applies to the whole chain, the context of the middlewares is cancelled once it completes
+	ctx, cancel := context.WithCancel(context.Background())
+	socket.middlewareCtx = ctx
+
+	var done atomic.Bool
+	var timer *utils.Timer
+	finish := func(err *ExtendedError) {
+		if !done.CompareAndSwap(false, true) {
+			return
+		}
+		if timer != nil {
+			utils.ClearTimeout(timer)
+		}
+		cancel()
+		fn(err)
+	}
+	if timeout := n.MiddlewareTimeout(); timeout > 0 {
+		timer = utils.SetTimeout(func() {
+			namespace_log.Debug("middlewares of nsp %s have timed out after %d ms", n.name, timeout/time.Millisecond)
+			// the timer is not cleared, since it expired
+			if done.CompareAndSwap(false, true) {
+				cancel()
+				fn(NewExtendedError(ErrMiddlewareTimeout.Error(), nil))
+			}
+		}, timeout)
+	}
+
+	var run func(i int)
+	run = func(i int) {
+		var called atomic.Bool
+		fns[i](socket, func(err *ExtendedError) {
+			if !called.CompareAndSwap(false, true) {
+				namespace_log.Warning("next called more than once by middleware #%d of nsp %s - ignoring", i, n.name)
+				return
+			}
+			if done.Load() {
+				namespace_log.Debug("next called after the middleware timeout - ignoring")
+				return
+			}
+			// upon error, short-circuit
+			if err != nil {
+				finish(err)
+				return
+			}
+			// if no middleware left, summon callback
+			if i >= length-1 {
+				finish(nil)
+				return
+			}
+			// go on to next
+			run(i + 1)
+		})
+	}
+	run(0)
 }
 
 // Targets a room when emitting.
@@ -245,6 +542,34 @@ func (n *namespace) In(room ...Room) *BroadcastOperator {
 	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).In(room...)
 }
 
+// Targets the sockets which have joined all the given rooms when emitting.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	// the “foo” event will be broadcast to the clients which are both in the “tenant:42” and in the “channel:general” rooms
+//	myNamespace.InAll("tenant:42", "channel:general").Emit("foo", "bar")
+//
+// Param: Room - a `Room`, or a `Room` slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (n *namespace) InAll(room ...Room) *BroadcastOperator {
+	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).InAll(room...)
+}
+
+// Targets the rooms matching a pattern when emitting.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	// the “foo” event will be broadcast to all connected clients in a room starting with “user:”
+//	myNamespace.ToPattern("user:*").Emit("foo", "bar")
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (n *namespace) ToPattern(pattern ...string) *BroadcastOperator {
+	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).ToPattern(pattern...)
+}
+
 // Excludes a room when emitting.
 //
 //	myNamespace := io.Of("/my-namespace")
@@ -266,22 +591,93 @@ func (n *namespace) Except(room ...Room) *BroadcastOperator {
 	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).Except(room...)
 }
 
+// Excludes the rooms matching a pattern when emitting.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	// the “foo” event will be broadcast to all connected clients, except the ones that are in a room starting with
+//	// “guest:”
+//	myNamespace.ExceptPattern("guest:*").Emit("foo", "bar")
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (n *namespace) ExceptPattern(pattern ...string) *BroadcastOperator {
+	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).ExceptPattern(pattern...)
+}
+
+// Stops accepting new connections, which are rejected with the "Namespace is draining" error. The connected sockets
+// are not disconnected.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	myNamespace.Drain()
+//	myNamespace.Emit("reconnect-elsewhere")
+func (n *namespace) Drain() Namespace {
+	n.draining.Store(true)
+	return n
+}
+
+// Whether the namespace stopped accepting new connections.
+func (n *namespace) Draining() bool {
+	return n.draining.Load()
+}
+
 // Adds a new client.
 func (n *namespace) Add(client *Client, auth any, fn func(*Socket)) {
+	if n.draining.Load() {
+		namespace_log.Debug("nsp %s is draining - rejecting the connection", n.name)
+		client._packet(&parser.Packet{
+			Type: parser.CONNECT_ERROR,
+			Nsp:  n.name,
+			Data: map[string]any{
+				"message": "Namespace is draining",
+			},
+		}, nil)
+		return
+	}
+	// the slot is reserved before the middlewares run, so that concurrent connections cannot exceed the maximum
+	if max := n.opts.MaxSockets(); max > 0 && n.reservedSlots.Add(1) > int64(max) {
+		n.reservedSlots.Add(-1)
+		namespace_log.Debug("nsp %s is full - rejecting the connection", n.name)
+		client._packet(&parser.Packet{
+			Type: parser.CONNECT_ERROR,
+			Nsp:  n.name,
+			Data: map[string]any{
+				"message": "Namespace is full",
+			},
+		}, nil)
+		return
+	}
 	namespace_log.Debug("adding socket to nsp %s", n.name)
 	socket := n._createSocket(client, auth)
-	if n.server.Opts().ConnectionStateRecovery().SkipMiddlewares() && socket.Recovered() && client.Conn().ReadyState() == "open" {
+	if n.opts.MaxSockets() > 0 {
+		// released by [namespace.Remove], once the connection is rejected or the socket disconnects
+		socket.slotReserved.Store(true)
+	}
+	if n.opts.ConnectionStateRecovery().SkipMiddlewares() && socket.Recovered() && client.Conn().ReadyState() == "open" {
+		defer socket.recoverConnectPanic()
 		n._doConnect(socket, fn)
 		return
 	}
 	// socket := NewSocket(n, client, query)
+	defer socket.recoverConnectPanic()
+	client.connecting.Store(socket.Id(), socket)
 	n.run(socket, func(err *ExtendedError) {
+		client.connecting.Delete(socket.Id())
 		go func() {
+			defer socket.recoverConnectPanic()
+
 			if "open" != client.conn.ReadyState() {
 				namespace_log.Debug("next called after client was closed - ignoring socket")
 				socket._cleanup()
 				return
 			}
+			if err == nil && n.draining.Load() {
+				// the namespace was drained or deleted while the middlewares were running
+				namespace_log.Debug("nsp %s is draining - rejecting the connection", n.name)
+				err = NewExtendedError("Namespace is draining", nil)
+			}
 			if err != nil {
 				namespace_log.Debug("middleware error, sending CONNECT_ERROR packet to the client")
 				socket._cleanup()
@@ -311,7 +707,7 @@ func (n *namespace) _createSocket(client *Client, auth any) *Socket {
 	if mapstructure.Decode(auth, &_auth) == nil {
 		sessionId, has_sessionId := _auth.GetPid()
 		offset, has_offset := _auth.GetOffset()
-		if has_sessionId && has_offset && n.server.Opts().GetRawConnectionStateRecovery() != nil {
+		if has_sessionId && has_offset && n.opts.GetRawConnectionStateRecovery() != nil {
 			session, err := n.Proto().Adapter().RestoreSession(PrivateSessionId(sessionId), offset)
 			if err != nil {
 				namespace_log.Debug("error while restoring session: %v", err)
@@ -354,6 +750,9 @@ func (n *namespace) Remove(socket *Socket) {
 	if _, ok := n.sockets.LoadAndDelete(socket.Id()); !ok {
 		namespace_log.Debug("ignoring remove for %s", socket.Id())
 	}
+	if socket.slotReserved.CompareAndSwap(true, false) {
+		n.reservedSlots.Add(-1)
+	}
 	if n._cleanup != nil {
 		n._cleanup()
 	}
@@ -467,6 +866,14 @@ func (n *namespace) ServerSideEmitWithAck(ev string, args ...any) func(func([]an
 
 // Called when a packet is received from another Socket.IO server
 func (n *namespace) OnServerSideEmit(ev string, args ...any) {
+	switch ev {
+	case PARENT_NAMESPACE_REQUEST_EVENT:
+		n.server.onParentNamespaceRequest(args...)
+		return
+	case ROOM_META_EVENT:
+		n.Proto().Adapter().Emit(ROOM_META_EVENT, args...)
+		return
+	}
 	n.EmitUntyped(ev, args...)
 }
 
@@ -499,6 +906,20 @@ func (n *namespace) Volatile() *BroadcastOperator {
 	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).Volatile()
 }
 
+// Sets a modifier for a subsequent event emission that at most one event emitted with the given key is delivered to
+// each client per window, the first one right away and the last of the following ones once the window expires.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	myNamespace.Coalesce("price:AAPL", 100*time.Millisecond).Emit("price", "AAPL", 187.2)
+//
+// Param: key - the coalescing key
+//
+// Param: window - the window
+func (n *namespace) Coalesce(key string, window time.Duration) *BroadcastOperator {
+	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).Coalesce(key, window)
+}
+
 // Sets a modifier for a subsequent event emission that the event data will only be broadcast to the current node.
 //
 //	myNamespace := io.Of("/my-namespace")
@@ -524,6 +945,35 @@ func (n *namespace) Timeout(timeout time.Duration) *BroadcastOperator {
 	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).Timeout(timeout)
 }
 
+// Only targets the sockets for which the given function returns `true`.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	// the “foo” event will be broadcast to the admins
+//	myNamespace.Where(func(socket socket.SocketDetails) bool {
+//		data, ok := socket.Data().(*User)
+//		return ok && data.Role == "admin"
+//	}).Emit("foo", "bar")
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (n *namespace) Where(fn func(SocketDetails) bool) *BroadcastOperator {
+	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).Where(fn)
+}
+
+// Only targets the sockets whose field matches the given value.
+//
+// Note: this method also works within a cluster of multiple Socket.IO servers, with a compatible Adapter.
+//
+//	myNamespace := io.Of("/my-namespace")
+//
+//	// the “foo” event will be broadcast to the admins
+//	myNamespace.WhereField("data.role", socket.PredicateEq, "admin").Emit("foo", "bar")
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (n *namespace) WhereField(field string, operator PredicateOperator, value any) *BroadcastOperator {
+	return NewBroadcastOperator(n.Proto().Adapter(), nil, nil, nil).WhereField(field, operator, value)
+}
+
 // Returns the matching socket instances
 //
 // Note: this method also works within a cluster of multiple Socket.IO servers, with a compatible Adapter.
@@ -0,0 +1,199 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Runs the middlewares of the namespace for the socket, and returns the result of the chain.
+func runTestMiddlewares(t *testing.T, nsp Namespace, socket *Socket) *ExtendedError {
+	t.Helper()
+
+	var calls atomic.Int32
+	result := make(chan *ExtendedError, 2)
+	nsp.(*namespace).run(socket, func(err *ExtendedError) {
+		calls.Add(1)
+		result <- err
+	})
+	select {
+	case err := <-result:
+		time.Sleep(10 * time.Millisecond)
+		if calls.Load() != 1 {
+			t.Fatalf("expected the chain to complete once, got %d", calls.Load())
+		}
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("expected the middlewares to complete")
+	}
+	return nil
+}
+
+func TestNamespaceMiddlewareNextTwice(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	socket, _ := newWriteTestSocket(nsp, "a")
+
+	var calls atomic.Int32
+	nsp.Use(func(_ *Socket, next func(*ExtendedError)) {
+		next(nil)
+		next(NewExtendedError("ignored", nil))
+	})
+	nsp.Use(func(_ *Socket, next func(*ExtendedError)) {
+		calls.Add(1)
+		next(nil)
+	})
+
+	if err := runTestMiddlewares(t, nsp, socket); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected the next middleware to be called once, got %d", calls.Load())
+	}
+}
+
+func TestNamespaceMiddlewareTimeout(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	nsp.SetMiddlewareTimeout(20 * time.Millisecond)
+	socket, _ := newWriteTestSocket(nsp, "a")
+
+	var stalled func(*ExtendedError)
+	nsp.Use(func(_ *Socket, next func(*ExtendedError)) {
+		next(nil)
+	})
+	nsp.Use(func(_ *Socket, next func(*ExtendedError)) {
+		stalled = next
+	})
+
+	err := runTestMiddlewares(t, nsp, socket)
+	if err == nil || err.Error() != ErrMiddlewareTimeout.Error() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	// a late next is ignored
+	stalled(nil)
+}
+
+func TestNamespaceUseContext(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	socket, _ := newWriteTestSocket(nsp, "a")
+
+	var ctxs []context.Context
+	nsp.UseContext(func(ctx context.Context, _ *Socket) error {
+		ctxs = append(ctxs, ctx)
+		return nil
+	})
+	if err := runTestMiddlewares(t, nsp, socket); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if ctxs[0].Err() != context.Canceled {
+		t.Fatal("expected the context to be cancelled once the chain completes")
+	}
+
+	// the timeout of the chain cancels the context
+	nsp.SetMiddlewareTimeout(20 * time.Millisecond)
+	nsp.UseContext(func(ctx context.Context, _ *Socket) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	err := runTestMiddlewares(t, nsp, socket)
+	if err == nil || err.Error() != ErrMiddlewareTimeout.Error() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// the errors are sent as extended errors
+	other := NewServer(nil, nil).Of("/other", nil)
+	other.UseContext(func(context.Context, *Socket) error {
+		return errors.New("unauthorized")
+	})
+	otherSocket, _ := newWriteTestSocket(other, "b")
+	if err := runTestMiddlewares(t, other, otherSocket); err == nil || err.Error() != "unauthorized" {
+		t.Fatalf("expected the error of the middleware, got %v", err)
+	}
+}
+
+func TestSocketMiddlewareTimeout(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	nsp.SetEventMiddlewareTimeout(20 * time.Millisecond)
+	socket, _ := newWriteTestSocket(nsp, "a")
+
+	var calls atomic.Int32
+	socket.Use(func(event []any, next func(error)) {
+		next(nil)
+		next(errors.New("ignored"))
+	})
+	socket.Use(func(event []any, next func(error)) {
+		calls.Add(1)
+		if event[0] == "slow" {
+			return
+		}
+		next(nil)
+	})
+
+	results := make(chan error, 2)
+	socket.run([]any{"fast"}, func(err error) {
+		results <- err
+	})
+	if err := <-results; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected the next middleware to be called once, got %d", calls.Load())
+	}
+
+	socket.run([]any{"slow"}, func(err error) {
+		results <- err
+	})
+	select {
+	case err := <-results:
+		if err != ErrMiddlewareTimeout {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the middlewares of the event to time out")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if len(results) != 0 {
+		t.Fatal("expected the chain to complete once")
+	}
+}
+
+func TestNamespaceDeletedDuringMiddlewares(t *testing.T) {
+	for _, deleted := range []bool{false, true} {
+		io := NewServer(nil, nil)
+		nsp := io.Of("/tenant", nil)
+		release := make(chan struct{})
+		nsp.Use(func(_ *Socket, next func(*ExtendedError)) {
+			go func() {
+				<-release
+				next(nil)
+			}()
+		})
+
+		client, conn := newWriteTestClient(io, "a")
+		connected := make(chan *Socket, 1)
+		nsp.Add(client, nil, func(socket *Socket) {
+			connected <- socket
+		})
+		if deleted {
+			if err := io.DeleteNamespace("/tenant", nil); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			nsp.Drain()
+		}
+		close(release)
+
+		deadline := time.Now().Add(time.Second)
+		for len(conn.Writes()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if writes := conn.Writes(); len(writes) != 1 || !strings.HasPrefix(writes[0], "4/tenant,") || !strings.Contains(writes[0], "Namespace is draining") {
+			t.Fatalf("expected the connection to be rejected, got %v", writes)
+		}
+		if len(connected) != 0 || nsp.Sockets().Len() != 0 {
+			t.Fatal("expected the socket not to be connected")
+		}
+	}
+}
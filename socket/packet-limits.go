@@ -0,0 +1,180 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	_types "github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+// What happens to a decoded packet which exceeds a limit of its namespace, see [PacketLimits].
+type PacketLimitPolicy string
+
+const (
+	// The socket is disconnected, with the reason of the violated limit (e.g. "packet too large").
+	PacketLimitDisconnect PacketLimitPolicy = "disconnect"
+	// The packet is dropped, and an "error" event is emitted by the socket with a [PacketLimitError].
+	PacketLimitDrop PacketLimitPolicy = "drop"
+)
+
+var (
+	ErrPacketTooLarge     = errors.New("packet too large")
+	ErrTooManyArguments   = errors.New("too many arguments")
+	ErrTooManyAttachments = errors.New("too many attachments")
+)
+
+// The violation of a limit of [PacketLimits], which wraps one of [ErrPacketTooLarge], [ErrTooManyArguments] and
+// [ErrTooManyAttachments].
+//
+//	socket.On("error", func(args ...any) {
+//		if err, ok := args[0].(*socket.PacketLimitError); ok && errors.Is(err, socket.ErrPacketTooLarge) {
+//			// the packet was dropped
+//		}
+//	})
+type PacketLimitError struct {
+	// The violated limit.
+	Err error `json:"-" mapstructure:"-" msgpack:"-"`
+	// The namespace of the packet, empty if the packet was not decoded.
+	Nsp string `json:"nsp,omitempty" mapstructure:"nsp,omitempty" msgpack:"nsp,omitempty"`
+	// The event of the packet, empty if the packet is not an event or was not decoded.
+	Event string `json:"event,omitempty" mapstructure:"event,omitempty" msgpack:"event,omitempty"`
+	// The value of the limit.
+	Limit int64 `json:"limit" mapstructure:"limit" msgpack:"limit"`
+	// The value of the packet.
+	Actual int64 `json:"actual" mapstructure:"actual" msgpack:"actual"`
+}
+
+func (e *PacketLimitError) Error() string {
+	if e.Event != "" {
+		return fmt.Sprintf("%s (event %q: %d > %d)", e.Reason(), e.Event, e.Actual, e.Limit)
+	}
+	return fmt.Sprintf("%s (%d > %d)", e.Reason(), e.Actual, e.Limit)
+}
+
+func (e *PacketLimitError) Unwrap() error {
+	return e.Err
+}
+
+// The reason of the disconnection, which is the message of the violated limit.
+func (e *PacketLimitError) Reason() string {
+	if e.Err == nil {
+		return "packet limit exceeded"
+	}
+	return e.Err.Error()
+}
+
+// Checks the limits of the server as the data of a packet is received, with the size of the data of the packet
+// received so far.
+func (l *PacketLimits) checkData(data any, size int64, defaultParser bool) *PacketLimitError {
+	// the event is not known yet, so the largest limit applies
+	if limit := l.MaxPayloadSize(); limit > 0 {
+		for _, eventLimit := range l.MaxEventPayloadSizes() {
+			limit = max(limit, eventLimit)
+		}
+		if size > limit {
+			return &PacketLimitError{Err: ErrPacketTooLarge, Limit: limit, Actual: size}
+		}
+	}
+	if limit := l.MaxAttachments(); limit > 0 && defaultParser {
+		// the number of attachments is announced by the header of a binary packet (e.g. `51-["upload",{...}]`), so
+		// that they are not buffered
+		if attachments, ok := packetAttachments(data); ok && attachments > int64(limit) {
+			return &PacketLimitError{Err: ErrTooManyAttachments, Limit: int64(limit), Actual: attachments}
+		}
+	}
+	return nil
+}
+
+// Checks the limits of a decoded packet, with its size.
+func (l *PacketLimits) checkPacket(packet *parser.Packet, size int64) *PacketLimitError {
+	var event string
+	var args []any
+	if packet.Type == parser.EVENT || packet.Type == parser.BINARY_EVENT {
+		args, _ = packet.Data.([]any)
+		if len(args) > 0 {
+			event, _ = args[0].(string)
+		}
+	}
+
+	limit := l.MaxPayloadSize()
+	if eventLimit, ok := l.MaxEventPayloadSizes()[event]; ok && event != "" {
+		limit = eventLimit
+	}
+	if limit > 0 && size > limit {
+		return &PacketLimitError{Err: ErrPacketTooLarge, Nsp: packet.Nsp, Event: event, Limit: limit, Actual: size}
+	}
+	if limit := l.MaxArgs(); limit > 0 && len(args) > limit+1 {
+		return &PacketLimitError{Err: ErrTooManyArguments, Nsp: packet.Nsp, Event: event, Limit: int64(limit), Actual: int64(len(args) - 1)}
+	}
+	if limit := l.MaxAttachments(); limit > 0 {
+		if attachments := countAttachments(packet.Data); attachments > int64(limit) {
+			return &PacketLimitError{Err: ErrTooManyAttachments, Nsp: packet.Nsp, Event: event, Limit: int64(limit), Actual: attachments}
+		}
+	}
+	return nil
+}
+
+// Returns the size of the data received from the transport.
+func dataSize(data any) int64 {
+	switch tdata := data.(type) {
+	case string:
+		return int64(len(tdata))
+	case []byte:
+		return int64(len(tdata))
+	case *strings.Reader:
+		return int64(tdata.Len())
+	case _types.BufferInterface:
+		return int64(tdata.Len())
+	}
+	return 0
+}
+
+// Returns the number of attachments announced by the header of a binary packet of the default parser.
+func packetAttachments(data any) (int64, bool) {
+	var header []byte
+	switch tdata := data.(type) {
+	case string:
+		header = []byte(tdata[:min(len(tdata), 22)])
+	case *_types.StringBuffer:
+		header = tdata.Bytes()
+	default:
+		return 0, false
+	}
+	if len(header) < 3 || (parser.PacketType(header[0]) != parser.BINARY_EVENT && parser.PacketType(header[0]) != parser.BINARY_ACK) {
+		return 0, false
+	}
+
+	var attachments int64
+	for i := 1; i < len(header) && i <= 20; i++ {
+		switch c := header[i]; {
+		case c >= '0' && c <= '9':
+			attachments = attachments*10 + int64(c-'0')
+		case c == '-' && i > 1:
+			return attachments, true
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// Returns the number of binary values of decoded data.
+func countAttachments(data any) (count int64) {
+	switch tdata := data.(type) {
+	case *_types.StringBuffer, *strings.Reader:
+	case []byte, io.Reader:
+		return 1
+	case []any:
+		for _, v := range tdata {
+			count += countAttachments(v)
+		}
+	case map[string]any:
+		for _, v := range tdata {
+			count += countAttachments(v)
+		}
+	}
+	return count
+}
@@ -0,0 +1,204 @@
+package socket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zishang520/engine.io-server-go-fasthttp/v2/engine"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+func newTestPacketLimits() *PacketLimits {
+	limits := &PacketLimits{}
+	limits.SetMaxPayloadSize(100)
+	limits.SetMaxEventPayloadSizes(map[string]int64{"upload": 1000})
+	limits.SetMaxArgs(2)
+	limits.SetMaxAttachments(1)
+	return limits
+}
+
+func TestPacketLimitsCheckPacket(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		packet *parser.Packet
+		size   int64
+		err    error
+		reason string
+		event  string
+		limit  int64
+		actual int64
+	}{
+		{
+			name:   "within the limits",
+			packet: &parser.Packet{Type: parser.EVENT, Data: []any{"chat", "hello"}},
+			size:   50,
+		},
+		{
+			name:   "packet too large",
+			packet: &parser.Packet{Type: parser.EVENT, Data: []any{"chat", "hello"}},
+			size:   150,
+			err:    ErrPacketTooLarge,
+			reason: "packet too large",
+			event:  "chat",
+			limit:  100,
+			actual: 150,
+		},
+		{
+			name:   "within the limit of the event",
+			packet: &parser.Packet{Type: parser.BINARY_EVENT, Data: []any{"upload", []byte{0x01}}},
+			size:   500,
+		},
+		{
+			name:   "event too large",
+			packet: &parser.Packet{Type: parser.BINARY_EVENT, Data: []any{"upload", []byte{0x01}}},
+			size:   1500,
+			err:    ErrPacketTooLarge,
+			reason: "packet too large",
+			event:  "upload",
+			limit:  1000,
+			actual: 1500,
+		},
+		{
+			name:   "acknowledgement too large",
+			packet: &parser.Packet{Type: parser.ACK, Data: []any{"upload"}},
+			size:   150,
+			err:    ErrPacketTooLarge,
+			reason: "packet too large",
+			limit:  100,
+			actual: 150,
+		},
+		{
+			name:   "too many arguments",
+			packet: &parser.Packet{Type: parser.EVENT, Data: []any{"chat", 1, 2, 3}},
+			size:   10,
+			err:    ErrTooManyArguments,
+			reason: "too many arguments",
+			event:  "chat",
+			limit:  2,
+			actual: 3,
+		},
+		{
+			name:   "too many attachments",
+			packet: &parser.Packet{Type: parser.BINARY_EVENT, Data: []any{"upload", []byte{0x01}, map[string]any{"thumbnail": []byte{0x02}}}},
+			size:   10,
+			err:    ErrTooManyAttachments,
+			reason: "too many attachments",
+			event:  "upload",
+			limit:  1,
+			actual: 2,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := newTestPacketLimits().checkPacket(test.packet, test.size)
+			if test.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err.Reason() != test.reason || err.Event != test.event || err.Limit != test.limit || err.Actual != test.actual {
+				t.Fatalf("unexpected error %+v", err)
+			}
+		})
+	}
+}
+
+func TestPacketLimitsCheckData(t *testing.T) {
+	limits := newTestPacketLimits()
+
+	// the event is not known yet, so the largest limit applies
+	if err := limits.checkData("2[\"upload\"]", 500, true); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := limits.checkData("2[\"upload\"]", 1500, true); err == nil || !errors.Is(err, ErrPacketTooLarge) || err.Limit != 1000 {
+		t.Fatalf("expected ErrPacketTooLarge, got %v", err)
+	}
+
+	// the attachments are announced by the header of the binary packets of the default parser
+	header := "52-[\"upload\",{\"_placeholder\":true,\"num\":0},{\"_placeholder\":true,\"num\":1}]"
+	if err := limits.checkData(header, 10, true); err == nil || !errors.Is(err, ErrTooManyAttachments) || err.Actual != 2 {
+		t.Fatalf("expected ErrTooManyAttachments, got %v", err)
+	}
+	if err := limits.checkData(header, 10, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := limits.checkData("51-[\"upload\",{\"_placeholder\":true,\"num\":0}]", 10, true); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// A connection which is not open, so that the packets sent to the client are dropped.
+type limitTestConn struct {
+	engine.Socket
+}
+
+func (limitTestConn) ReadyState() string {
+	return "closed"
+}
+
+func (limitTestConn) Protocol() int {
+	return 4
+}
+
+func TestPacketLimitsPolicy(t *testing.T) {
+	for _, policy := range []PacketLimitPolicy{PacketLimitDrop, PacketLimitDisconnect} {
+		t.Run(string(policy), func(t *testing.T) {
+			limits := newTestPacketLimits()
+			limits.SetPolicy(policy)
+			io := NewServer(nil, nil)
+			nsp := io.Of("/limited", nil, WithPacketLimits(limits))
+
+			client := MakeClient()
+			client.server = io
+			client.conn = limitTestConn{}
+			s := MakeSocket()
+			s.server = io
+			s.nsp = nsp
+			s.adapter = nsp.Adapter()
+			s.client = client
+			s.id = "a"
+			s.connected.Store(true)
+			client.sockets.Store(s.id, s)
+			client.nsps.Store(nsp.Name(), s)
+
+			errs := make(chan any, 1)
+			s.On("error", func(args ...any) {
+				errs <- args[0]
+			})
+			reasons := make(chan any, 1)
+			s.On("disconnect", func(args ...any) {
+				reasons <- args[0]
+			})
+
+			client.ondecoded(&parser.Packet{Type: parser.EVENT, Nsp: "/limited", Data: []any{"chat", 1, 2, 3}})
+
+			switch policy {
+			case PacketLimitDrop:
+				select {
+				case err := <-errs:
+					if err, ok := err.(*PacketLimitError); !ok || !errors.Is(err, ErrTooManyArguments) || err.Nsp != "/limited" {
+						t.Fatalf("unexpected error %v", err)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("expected an error event")
+				}
+				if !s.Connected() {
+					t.Fatal("expected the socket to stay connected")
+				}
+			case PacketLimitDisconnect:
+				select {
+				case reason := <-reasons:
+					if reason != "too many arguments" {
+						t.Fatalf("unexpected reason %v", reason)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("expected the socket to be disconnected")
+				}
+			}
+		})
+	}
+}
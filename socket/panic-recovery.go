@@ -0,0 +1,112 @@
+package socket
+
+import (
+	"runtime/debug"
+
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+var panic_recovery_log = log.NewLog("socket.io:panic-recovery")
+
+type (
+	// What happens to a socket once a panic of one of its handlers was recovered.
+	PanicPolicy string
+
+	// Called with the socket, the event being handled (e.g. "connection", "ack" or the name of an incoming event),
+	// the recovered value and the stack trace of the panic. The socket is nil for the callbacks which are not bound to
+	// a socket, like the acknowledgement callbacks of broadcasts or the scheduled emits.
+	ErrorHandler func(socket *Socket, event string, value any, stack []byte)
+)
+
+const (
+	// The socket is kept connected.
+	PanicKeep PanicPolicy = "keep"
+	// The socket is disconnected with the "server namespace disconnect" reason.
+	PanicDisconnect PanicPolicy = "disconnect"
+	// The panic is raised again, which crashes the process.
+	PanicRepanic PanicPolicy = "repanic"
+)
+
+// Recovers a panic of a handler (listener, middleware or acknowledgement callback) of the socket, must be deferred.
+//
+// Param: event - the event being handled
+func (s *Socket) recoverPanic(event string) {
+	if value := recover(); value != nil {
+		s.server.onpanic(s.nsp, s, event, value, debug.Stack())
+	}
+}
+
+// Recovers a panic of a callback which is not bound to a socket (e.g. the acknowledgement callback of a broadcast),
+// must be deferred.
+//
+// Param: event - the event being handled
+func recoverNamespacePanic(nsp Namespace, event string) {
+	if value := recover(); value != nil {
+		nsp.Server().onpanic(nsp, nil, event, value, debug.Stack())
+	}
+}
+
+// Recovers a panic of a namespace middleware or of a "connection" listener, must be deferred. The connection is
+// rejected if the panic happened before its completion, since it would never complete otherwise.
+func (s *Socket) recoverConnectPanic() {
+	if value := recover(); value != nil {
+		s.server.onpanic(s.nsp, s, "connection", value, debug.Stack())
+		if s.Connected() {
+			return
+		}
+		s._cleanup()
+		if s.client.conn.Protocol() == 3 {
+			s._error("server error")
+		} else {
+			s._error(map[string]any{
+				"message": "server error",
+				"data":    nil,
+			})
+		}
+	}
+}
+
+// Reports a recovered panic, and applies the [PanicPolicy] of the server. The socket is nil if the callback was not
+// bound to a socket, in which case the socket is kept.
+func (s *Server) onpanic(nsp Namespace, socket *Socket, event string, value any, stack []byte) {
+	var id SocketId
+	if socket != nil {
+		id = socket.Id()
+	}
+
+	opts := s.opts.PanicRecovery()
+	if handler := opts.ErrorHandler(); handler != nil {
+		panic_recovery_log.Debug("panic while handling %q in namespace %s (socket %s): %v", event, nsp.Name(), id, value)
+		handler(socket, event, value, stack)
+	} else {
+		panic_recovery_log.Error("panic while handling %q in namespace %s (socket %s): %v\n%s", event, nsp.Name(), id, value, stack)
+	}
+
+	switch opts.Policy() {
+	case PanicRepanic:
+		panic(value)
+	case PanicDisconnect:
+		// the socket is already closing upon "disconnecting"
+		if socket != nil && event != "disconnecting" {
+			socket.Disconnect(false)
+		}
+	}
+}
+
+// The event of an incoming packet, as reported upon panic.
+func packetEvent(packet *parser.Packet) string {
+	switch packet.Type {
+	case parser.EVENT, parser.BINARY_EVENT:
+		if args, ok := packet.Data.([]any); ok && len(args) > 0 {
+			if ev, ok := args[0].(string); ok {
+				return ev
+			}
+		}
+	case parser.ACK, parser.BINARY_ACK:
+		return "ack"
+	case parser.DISCONNECT:
+		return "disconnect"
+	}
+	return packet.Type.String()
+}
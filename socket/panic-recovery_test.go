@@ -0,0 +1,158 @@
+package socket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+type panicReport struct {
+	socket *Socket
+	event  string
+	value  any
+}
+
+// Returns a namespace of a server with the given panic policy, along with the reported panics.
+func newPanicTestNamespace(policy PanicPolicy) (Namespace, chan *panicReport) {
+	reports := make(chan *panicReport, 10)
+	panicRecovery := &PanicRecovery{}
+	panicRecovery.SetPolicy(policy)
+	panicRecovery.SetErrorHandler(func(socket *Socket, event string, value any, _ []byte) {
+		reports <- &panicReport{socket, event, value}
+	})
+	opts := DefaultServerOptions()
+	opts.SetPanicRecovery(panicRecovery)
+	return NewServer(nil, opts).Of("/", nil), reports
+}
+
+func expectPanicReport(t *testing.T, reports chan *panicReport, socket *Socket, event string) {
+	t.Helper()
+
+	select {
+	case report := <-reports:
+		if report.socket != socket || report.event != event || report.value != "boom" {
+			t.Fatalf("unexpected report %+v", report)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the panic of %q to be reported", event)
+	}
+}
+
+func TestPanicPolicyKeep(t *testing.T) {
+	nsp, reports := newPanicTestNamespace(PanicKeep)
+	socket, _ := newWriteTestSocket(nsp, "a")
+	socket.On("explode", func(...any) {
+		panic("boom")
+	})
+
+	socket._onpacket(&parser.Packet{Type: parser.EVENT, Data: []any{"explode"}})
+	expectPanicReport(t, reports, socket, "explode")
+	if !socket.Connected() {
+		t.Fatal("expected the socket to be kept")
+	}
+
+	// the acknowledgement callback is removed even though it panicked
+	id := uint64(42)
+	socket.registerAckCallback(id, func([]any, error) {
+		panic("boom")
+	}, nil)
+	socket._onpacket(&parser.Packet{Type: parser.ACK, Id: &id, Data: []any{}})
+	expectPanicReport(t, reports, socket, "ack")
+	if _, ok := socket.acks.Load(id); ok {
+		t.Fatal("expected the acknowledgement callback to be removed")
+	}
+}
+
+func TestPanicPolicyDisconnect(t *testing.T) {
+	nsp, reports := newPanicTestNamespace(PanicDisconnect)
+	socket, conn := newWriteTestSocket(nsp, "a")
+	socket.On("explode", func(...any) {
+		panic("boom")
+	})
+
+	socket._onpacket(&parser.Packet{Type: parser.EVENT, Data: []any{"explode"}})
+	expectPanicReport(t, reports, socket, "explode")
+	expectWrites(t, conn, "1")
+	if socket.Connected() {
+		t.Fatal("expected the socket to be disconnected")
+	}
+}
+
+func TestPanicPolicyRepanic(t *testing.T) {
+	nsp, reports := newPanicTestNamespace(PanicRepanic)
+	socket, _ := newWriteTestSocket(nsp, "a")
+
+	func() {
+		defer func() {
+			if value := recover(); value != "boom" {
+				t.Fatalf("expected the panic to be raised again, got %v", value)
+			}
+		}()
+		defer socket.recoverPanic("explode")
+
+		panic("boom")
+	}()
+	expectPanicReport(t, reports, socket, "explode")
+}
+
+func TestPanicRecoveryBroadcastAck(t *testing.T) {
+	nsp, reports := newPanicTestNamespace(PanicKeep)
+	newWriteTestSocket(nsp, "a")
+
+	// no socket acknowledges the event, so the callback is called by the timer
+	nsp.Timeout(10*time.Millisecond).Emit("question", func([]any, error) {
+		panic("boom")
+	})
+	expectPanicReport(t, reports, nil, "ack")
+
+	var once sync.Once
+	nsp.Timeout(10 * time.Millisecond).EmitWithAckStream("question")(&AckStreamHandler{
+		OnDone: func(*AckStreamSummary) {
+			once.Do(func() {
+				panic("boom")
+			})
+		},
+	})
+	expectPanicReport(t, reports, nil, "ack")
+}
+
+func TestPanicRecoveryDisconnectListeners(t *testing.T) {
+	nsp, reports := newPanicTestNamespace(PanicDisconnect)
+	a, _ := newWriteTestSocket(nsp, "a")
+	b, _ := newWriteTestSocket(nsp, "b")
+	// both sockets share the client, as if they were connected to several namespaces
+	a.client.sockets.Store(b.Id(), b)
+	a.On("disconnecting", func(...any) {
+		panic("boom")
+	})
+	a.On("disconnect", func(...any) {
+		panic("boom")
+	})
+
+	a.client.onclose("transport close")
+	expectPanicReport(t, reports, a, "disconnecting")
+	expectPanicReport(t, reports, a, "disconnect")
+	if a.Connected() || b.Connected() {
+		t.Fatal("expected every socket of the client to be closed")
+	}
+	if _, ok := nsp.Sockets().Load(a.Id()); ok {
+		t.Fatal("expected the socket to be removed from the namespace")
+	}
+}
+
+func TestPanicRecoveryProcedure(t *testing.T) {
+	nsp, reports := newPanicTestNamespace(PanicKeep)
+	socket, _ := newWriteTestSocket(nsp, "a")
+	nsp.Rpc().Handle("explode", func(context.Context, *RpcCall) (any, error) {
+		panic("boom")
+	})
+
+	args := callTestProcedure(t, socket, "explode", nil)
+	if rpcErr, ok := args[0].(*RpcError); !ok || rpcErr.Code != RpcCodeInternal {
+		t.Fatalf("expected an internal error, got %v", args)
+	}
+	expectPanicReport(t, reports, socket, "explode")
+}
@@ -1,10 +1,30 @@
 package socket
 
 import (
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/zishang520/engine.io/v2/log"
 	"github.com/zishang520/engine.io/v2/types"
 	"github.com/zishang520/socket.io-go-parser/v2/parser"
 )
 
+var parent_broadcast_adapter_log = log.NewLog("socket.io:parent-broadcast-adapter")
+
+// The server-side event carrying the operations on the child namespaces of a parent namespace between the Socket.IO
+// servers of the cluster.
+const PARENT_NAMESPACE_REQUEST_EVENT = "socket.io:parent-namespace"
+
+const (
+	ParentNamespaceFetchSockets      ParentNamespaceRequestType = "fetch_sockets"
+	ParentNamespaceAddSockets        ParentNamespaceRequestType = "add_sockets"
+	ParentNamespaceDelSockets        ParentNamespaceRequestType = "del_sockets"
+	ParentNamespaceDelSocketsPattern ParentNamespaceRequestType = "del_sockets_pattern"
+	ParentNamespaceDisconnectSockets ParentNamespaceRequestType = "disconnect_sockets"
+)
+
 type (
 	ParentBroadcastAdapterBuilder struct {
 		AdapterConstructor
@@ -12,12 +32,53 @@ type (
 		Children *types.Set[Namespace]
 	}
 
-	// A dummy adapter that only supports broadcasting to child (concrete) namespaces.
+	// An adapter which forwards the operations to the child (concrete) namespaces.
+	//
+	// The operations on the sockets (FetchSockets, AddSockets, DelSockets, DelSocketsPattern and DisconnectSockets) of
+	// a parent namespace created with a regular expression are also sent to the other Socket.IO servers of the
+	// cluster along with the regular expression, so that they apply to the namespaces which only exist on the other
+	// servers. The acknowledgements of the broadcasts are only collected from the sockets of the current server.
 	parentBroadcastAdapter struct {
 		Adapter
 
 		children *types.Set[Namespace]
 	}
+
+	ParentNamespaceRequestType string
+
+	// An operation on the child namespaces of a parent namespace, sent to the other Socket.IO servers of the cluster.
+	ParentNamespaceRequest struct {
+		Type ParentNamespaceRequestType `json:"type" mapstructure:"type" msgpack:"type"`
+		// The regular expression matching the names of the child namespaces
+		Pattern string `json:"pattern" mapstructure:"pattern" msgpack:"pattern"`
+
+		Rooms          []Room             `json:"rooms,omitempty" mapstructure:"rooms,omitempty" msgpack:"rooms,omitempty"`
+		Except         []Room             `json:"except,omitempty" mapstructure:"except,omitempty" msgpack:"except,omitempty"`
+		InAll          []Room             `json:"inAll,omitempty" mapstructure:"inAll,omitempty" msgpack:"inAll,omitempty"`
+		RoomPatterns   []string           `json:"roomPatterns,omitempty" mapstructure:"roomPatterns,omitempty" msgpack:"roomPatterns,omitempty"`
+		ExceptPatterns []string           `json:"exceptPatterns,omitempty" mapstructure:"exceptPatterns,omitempty" msgpack:"exceptPatterns,omitempty"`
+		Predicates     []*SocketPredicate `json:"predicates,omitempty" mapstructure:"predicates,omitempty" msgpack:"predicates,omitempty"`
+
+		// The rooms to join or leave
+		TargetRooms []Room `json:"targetRooms,omitempty" mapstructure:"targetRooms,omitempty" msgpack:"targetRooms,omitempty"`
+		// The patterns of the rooms to leave
+		TargetPatterns []string `json:"targetPatterns,omitempty" mapstructure:"targetPatterns,omitempty" msgpack:"targetPatterns,omitempty"`
+		// Whether to close the underlying connections upon disconnection
+		Close bool `json:"close,omitempty" mapstructure:"close,omitempty" msgpack:"close,omitempty"`
+	}
+
+	// The details of a socket fetched from another Socket.IO server.
+	parentNamespaceSocket struct {
+		SocketId      SocketId   `json:"id" mapstructure:"id" msgpack:"id"`
+		HandshakeData *Handshake `json:"handshake" mapstructure:"handshake" msgpack:"handshake"`
+		RoomList      []Room     `json:"rooms" mapstructure:"rooms" msgpack:"rooms"`
+		SocketData    any        `json:"data" mapstructure:"data" msgpack:"data"`
+	}
+
+	// The response of a Socket.IO server to a fetch_sockets request, sent as the only argument of the acknowledgement.
+	parentNamespaceResponse struct {
+		Sockets []*parentNamespaceSocket `json:"sockets" mapstructure:"sockets" msgpack:"sockets"`
+	}
 )
 
 func (b *ParentBroadcastAdapterBuilder) New(nsp Namespace) Adapter {
@@ -53,3 +114,295 @@ func (s *parentBroadcastAdapter) Broadcast(packet *parser.Packet, opts *Broadcas
 		nsp.Adapter().Broadcast(packet, opts)
 	}
 }
+
+func (s *parentBroadcastAdapter) BroadcastWithAck(packet *parser.Packet, opts *BroadcastOptions, clientCountCallback func(uint64), ack func([]any, error)) {
+	// the number of clients is reported once, as a single server
+	clientCount := uint64(0)
+	for _, nsp := range s.children.Keys() {
+		nsp.Adapter().BroadcastWithAck(packet, localOptions(opts), func(count uint64) {
+			clientCount += count
+		}, ack)
+	}
+	clientCountCallback(clientCount)
+}
+
+func (s *parentBroadcastAdapter) BroadcastWithSocketAck(packet *parser.Packet, opts *BroadcastOptions, targetsCallback func([]SocketId), ack func(SocketId, []any, error)) {
+	targets := []SocketId{}
+	for _, nsp := range s.children.Keys() {
+		broadcastWithSocketAck(nsp.Adapter(), packet, localOptions(opts), func(sids []SocketId) {
+			targets = append(targets, sids...)
+		}, ack)
+	}
+	targetsCallback(targets)
+}
+
+func (s *parentBroadcastAdapter) FetchSockets(opts *BroadcastOptions) func(func([]SocketDetails, error)) {
+	return func(callback func([]SocketDetails, error)) {
+		children := s.children.Keys()
+		request := s.request(ParentNamespaceFetchSockets, opts)
+
+		var mu sync.Mutex
+		sockets := []SocketDetails{}
+		var firstErr error
+		var pending atomic.Int64
+		pending.Store(int64(len(children)) + 1)
+		done := func(details []SocketDetails, err error) {
+			mu.Lock()
+			sockets = append(sockets, details...)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			if pending.Add(-1) == 0 {
+				callback(sockets, firstErr)
+			}
+		}
+
+		for _, nsp := range children {
+			nsp.Adapter().FetchSockets(s.childOptions(opts, request))(done)
+		}
+		if request == nil {
+			done(nil, nil)
+			return
+		}
+		s.forward(request, func(responses []any, err error) {
+			done(decodeParentNamespaceSockets(responses), err)
+		})
+	}
+}
+
+func (s *parentBroadcastAdapter) AddSockets(opts *BroadcastOptions, rooms []Room) {
+	request := s.request(ParentNamespaceAddSockets, opts)
+	for _, nsp := range s.children.Keys() {
+		nsp.Adapter().AddSockets(s.childOptions(opts, request), rooms)
+	}
+	if request != nil {
+		request.TargetRooms = rooms
+		s.forward(request, nil)
+	}
+}
+
+func (s *parentBroadcastAdapter) DelSockets(opts *BroadcastOptions, rooms []Room) {
+	request := s.request(ParentNamespaceDelSockets, opts)
+	for _, nsp := range s.children.Keys() {
+		nsp.Adapter().DelSockets(s.childOptions(opts, request), rooms)
+	}
+	if request != nil {
+		request.TargetRooms = rooms
+		s.forward(request, nil)
+	}
+}
+
+// The options are forwarded to the adapters of the child namespaces, which are built by the same constructor.
+func (s *parentBroadcastAdapter) SupportsRoomTargeting() bool {
+	return supportsRoomTargeting(s.Adapter)
+}
+
+func (s *parentBroadcastAdapter) DelSocketsPattern(opts *BroadcastOptions, patterns []string) {
+	request := s.request(ParentNamespaceDelSocketsPattern, opts)
+	for _, nsp := range s.children.Keys() {
+		delSocketsPattern(nsp.Adapter(), s.childOptions(opts, request), patterns)
+	}
+	if request != nil {
+		request.TargetPatterns = patterns
+		s.forward(request, nil)
+	}
+}
+
+func (s *parentBroadcastAdapter) DisconnectSockets(opts *BroadcastOptions, status bool) {
+	request := s.request(ParentNamespaceDisconnectSockets, opts)
+	for _, nsp := range s.children.Keys() {
+		nsp.Adapter().DisconnectSockets(s.childOptions(opts, request), status)
+	}
+	if request != nil {
+		request.Close = status
+		s.forward(request, nil)
+	}
+}
+
+// Returns the request to send to the other Socket.IO servers, or nil if the operation only applies to the current
+// server (local flag, parent namespace created with a function, or single server).
+func (s *parentBroadcastAdapter) request(requestType ParentNamespaceRequestType, opts *BroadcastOptions) *ParentNamespaceRequest {
+	if opts != nil && opts.Flags != nil && opts.Flags.Local {
+		return nil
+	}
+	parent, ok := s.Nsp().(ParentNamespace)
+	if !ok || parent.Regexp() == nil {
+		return nil
+	}
+	if s.Nsp().Server().Sockets().Adapter().ServerCount() <= 1 {
+		return nil
+	}
+	request := &ParentNamespaceRequest{
+		Type:    requestType,
+		Pattern: parent.Regexp().String(),
+	}
+	if opts != nil {
+		if opts.Rooms != nil {
+			request.Rooms = opts.Rooms.Keys()
+		}
+		if opts.Except != nil {
+			request.Except = opts.Except.Keys()
+		}
+		if opts.InAll != nil {
+			request.InAll = opts.InAll.Keys()
+		}
+		request.RoomPatterns = opts.RoomPatterns
+		request.ExceptPatterns = opts.ExceptPatterns
+		request.Predicates = opts.Predicates
+	}
+	return request
+}
+
+// The options of the operation on a child namespace, which must not be forwarded by the adapter of the child if the
+// request is sent to the other servers.
+func (s *parentBroadcastAdapter) childOptions(opts *BroadcastOptions, request *ParentNamespaceRequest) *BroadcastOptions {
+	if request == nil {
+		return opts
+	}
+	return localOptions(opts)
+}
+
+// Sends the request to the other Socket.IO servers, through the adapter of the main namespace.
+func (s *parentBroadcastAdapter) forward(request *ParentNamespaceRequest, ack func([]any, error)) {
+	parent_broadcast_adapter_log.Debug("forwarding %s request for pattern %s", request.Type, request.Pattern)
+	args := []any{PARENT_NAMESPACE_REQUEST_EVENT, request}
+	if ack != nil {
+		args = append(args, ack)
+	}
+	if err := s.Nsp().Server().Sockets().Adapter().ServerSideEmit(args); err != nil {
+		parent_broadcast_adapter_log.Debug("error while forwarding %s request: %v", request.Type, err)
+		if ack != nil {
+			ack(nil, err)
+		}
+	}
+}
+
+// Applies a request received from another Socket.IO server to the matching namespaces of the current server.
+func (s *Server) onParentNamespaceRequest(args ...any) {
+	var ack func([]any, error)
+	if l := len(args); l > 0 {
+		if fn, ok := args[l-1].(func([]any, error)); ok {
+			ack = fn
+			args = args[:l-1]
+		}
+	}
+	if len(args) == 0 {
+		return
+	}
+	request := &ParentNamespaceRequest{}
+	if err := mapstructure.Decode(args[0], request); err != nil {
+		server_log.Debug("invalid parent namespace request: %v", err)
+		return
+	}
+	regex, err := regexp.Compile(request.Pattern)
+	if err != nil {
+		server_log.Debug("invalid parent namespace pattern %s: %v", request.Pattern, err)
+		return
+	}
+
+	opts := &BroadcastOptions{
+		Rooms:          types.NewSet(request.Rooms...),
+		Except:         types.NewSet(request.Except...),
+		Flags:          &BroadcastFlags{Local: true},
+		RoomPatterns:   request.RoomPatterns,
+		ExceptPatterns: request.ExceptPatterns,
+		Predicates:     request.Predicates,
+	}
+	if len(request.InAll) > 0 {
+		opts.InAll = types.NewSet(request.InAll...)
+	}
+
+	response := &parentNamespaceResponse{Sockets: []*parentNamespaceSocket{}}
+	s._nsps.Range(func(name string, nsp Namespace) bool {
+		if !regex.MatchString(name) {
+			return true
+		}
+		switch request.Type {
+		case ParentNamespaceFetchSockets:
+			// the local operations of the in-memory adapters are synchronous
+			nsp.Adapter().FetchSockets(opts)(func(details []SocketDetails, _ error) {
+				for _, socket := range details {
+					response.Sockets = append(response.Sockets, &parentNamespaceSocket{
+						SocketId:      socket.Id(),
+						HandshakeData: socket.Handshake(),
+						RoomList:      socket.Rooms().Keys(),
+						SocketData:    socket.Data(),
+					})
+				}
+			})
+		case ParentNamespaceAddSockets:
+			nsp.Adapter().AddSockets(opts, request.TargetRooms)
+		case ParentNamespaceDelSockets:
+			nsp.Adapter().DelSockets(opts, request.TargetRooms)
+		case ParentNamespaceDelSocketsPattern:
+			delSocketsPattern(nsp.Adapter(), opts, request.TargetPatterns)
+		case ParentNamespaceDisconnectSockets:
+			nsp.Adapter().DisconnectSockets(opts, request.Close)
+		}
+		return true
+	})
+	if ack != nil {
+		ack([]any{response}, nil)
+	}
+}
+
+// Decodes the sockets sent by the other Socket.IO servers. Each response holds the arguments of the acknowledgement
+// of a server, whose first argument is a [parentNamespaceResponse].
+func decodeParentNamespaceSockets(responses []any) []SocketDetails {
+	sockets := []SocketDetails{}
+	for _, response := range responses {
+		args, ok := response.([]any)
+		if !ok || len(args) == 0 {
+			parent_broadcast_adapter_log.Debug("invalid parent namespace response: %v", response)
+			continue
+		}
+		// the response is only serialized if it comes from another process
+		result, ok := args[0].(*parentNamespaceResponse)
+		if !ok {
+			result = &parentNamespaceResponse{}
+			if err := mapstructure.Decode(args[0], result); err != nil {
+				parent_broadcast_adapter_log.Debug("invalid parent namespace response: %v", err)
+				continue
+			}
+		}
+		for _, socket := range result.Sockets {
+			if socket != nil {
+				sockets = append(sockets, socket)
+			}
+		}
+	}
+	return sockets
+}
+
+// Returns a copy of the options with the local flag.
+func localOptions(opts *BroadcastOptions) *BroadcastOptions {
+	if opts == nil {
+		return &BroadcastOptions{Flags: &BroadcastFlags{Local: true}}
+	}
+	local := *opts
+	if opts.Flags != nil {
+		flags := *opts.Flags
+		local.Flags = &flags
+	} else {
+		local.Flags = &BroadcastFlags{}
+	}
+	local.Flags.Local = true
+	return &local
+}
+
+func (s *parentNamespaceSocket) Id() SocketId {
+	return s.SocketId
+}
+
+func (s *parentNamespaceSocket) Handshake() *Handshake {
+	return s.HandshakeData
+}
+
+func (s *parentNamespaceSocket) Rooms() *types.Set[Room] {
+	return types.NewSet(s.RoomList...)
+}
+
+func (s *parentNamespaceSocket) Data() any {
+	return s.SocketData
+}
@@ -0,0 +1,163 @@
+package socket
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+)
+
+type (
+	// An adapter which sends the server-side emits to a peer server, serialized as they would be by a cluster adapter.
+	clusterTestAdapter struct {
+		Adapter
+
+		peer *Server
+	}
+
+	clusterTestAdapterBuilder struct {
+		peer *Server
+	}
+)
+
+func (b *clusterTestAdapterBuilder) New(nsp Namespace) Adapter {
+	return &clusterTestAdapter{Adapter: NewAdapterNew(nsp), peer: b.peer}
+}
+
+func (a *clusterTestAdapter) ServerCount() int64 {
+	return 2
+}
+
+func (a *clusterTestAdapter) ServerSideEmit(args []any) error {
+	ev := args[0].(string)
+	args = args[1:]
+	var ack func([]any, error)
+	if l := len(args); l > 0 {
+		if fn, ok := args[l-1].(func([]any, error)); ok {
+			ack = fn
+			args = args[:l-1]
+		}
+	}
+	payload := jsonRoundTrip(args).([]any)
+	if ack != nil {
+		// one response per server
+		payload = append(payload, func(response []any, err error) {
+			ack([]any{jsonRoundTrip(response)}, err)
+		})
+	}
+	a.peer.Sockets().OnServerSideEmit(ev, payload...)
+	return nil
+}
+
+func jsonRoundTrip(value any) any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		panic(err)
+	}
+	return decoded
+}
+
+// Returns a parent namespace with a child on the current server and another one on a peer server, each with a socket.
+func newParentTestNamespaces() (ParentNamespace, *Socket, *Socket) {
+	pattern := regexp.MustCompile(`^/tenant-\d+$`)
+
+	peer := NewServer(nil, nil)
+	remote, _ := newWriteTestSocket(peer.Of(pattern, nil).(ParentNamespace).CreateChild("/tenant-2"), "remote")
+
+	opts := DefaultServerOptions()
+	opts.SetAdapter(&clusterTestAdapterBuilder{peer: peer})
+	parent := NewServer(nil, opts).Of(pattern, nil).(ParentNamespace)
+	local, _ := newWriteTestSocket(parent.CreateChild("/tenant-1"), "local")
+	return parent, local, remote
+}
+
+func fetchTestSocketIds(t *testing.T, operator interface {
+	FetchSockets() func(func([]*RemoteSocket, error))
+}) []SocketId {
+	t.Helper()
+
+	result := make(chan []SocketId, 1)
+	operator.FetchSockets()(func(sockets []*RemoteSocket, err error) {
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		ids := []SocketId{}
+		for _, socket := range sockets {
+			ids = append(ids, socket.Id())
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		result <- ids
+	})
+	select {
+	case ids := <-result:
+		return ids
+	case <-time.After(time.Second):
+		t.Fatal("expected the sockets to be fetched")
+	}
+	return nil
+}
+
+func TestParentBroadcastAdapterFetchSockets(t *testing.T) {
+	parent, local, remote := newParentTestNamespaces()
+	remote.Join("premium")
+
+	if ids := fetchTestSocketIds(t, parent); len(ids) != 2 || ids[0] != "local" || ids[1] != "remote" {
+		t.Fatalf("expected the local and remote sockets, got %v", ids)
+	}
+	if ids := fetchTestSocketIds(t, parent.To("premium")); len(ids) != 1 || ids[0] != "remote" {
+		t.Fatalf("expected the remote socket of the room, got %v", ids)
+	}
+	if ids := fetchTestSocketIds(t, parent.Local()); len(ids) != 1 || ids[0] != local.Id() {
+		t.Fatalf("expected the local socket only, got %v", ids)
+	}
+}
+
+func TestParentBroadcastAdapterSocketOperations(t *testing.T) {
+	parent, local, remote := newParentTestNamespaces()
+
+	parent.SocketsJoin("room")
+	for _, socket := range []*Socket{local, remote} {
+		if !socket.Rooms().Has("room") {
+			t.Fatalf("expected socket %s to join the room", socket.Id())
+		}
+	}
+	parent.SocketsLeave("room")
+	for _, socket := range []*Socket{local, remote} {
+		if socket.Rooms().Has("room") {
+			t.Fatalf("expected socket %s to leave the room", socket.Id())
+		}
+	}
+
+	parent.DisconnectSockets(false)
+	if local.Connected() || remote.Connected() {
+		t.Fatal("expected every socket to be disconnected")
+	}
+}
+
+func TestDecodeParentNamespaceSockets(t *testing.T) {
+	socket := &parentNamespaceSocket{SocketId: "a", RoomList: []Room{"a", "room"}, SocketData: "data"}
+	responses := []any{
+		// a server with a single socket, in process and serialized
+		[]any{&parentNamespaceResponse{Sockets: []*parentNamespaceSocket{socket}}},
+		jsonRoundTrip([]any{&parentNamespaceResponse{Sockets: []*parentNamespaceSocket{{SocketId: "b"}}}}),
+		// a server without any socket
+		[]any{&parentNamespaceResponse{Sockets: []*parentNamespaceSocket{}}},
+		// invalid responses
+		nil,
+		[]any{},
+		[]any{"invalid"},
+	}
+
+	sockets := decodeParentNamespaceSockets(responses)
+	if len(sockets) != 2 || sockets[0].Id() != "a" || sockets[1].Id() != "b" {
+		t.Fatalf("unexpected sockets %v", sockets)
+	}
+	if rooms := sockets[0].Rooms(); !rooms.Has("room") || sockets[0].Data() != "data" {
+		t.Fatalf("unexpected details %v %v", rooms.Keys(), sockets[0].Data())
+	}
+}
@@ -1,7 +1,7 @@
 package socket
 
 import (
-	"errors"
+	"regexp"
 	"strconv"
 	"sync/atomic"
 
@@ -33,6 +33,8 @@ type parentNamespace struct {
 
 	adapter  Adapter
 	children *types.Set[Namespace]
+	pattern  *NamespacePattern
+	regex    *regexp.Regexp
 }
 
 func MakeParentNamespace() ParentNamespace {
@@ -47,9 +49,10 @@ func MakeParentNamespace() ParentNamespace {
 	return n
 }
 
-func NewParentNamespace(server *Server) ParentNamespace {
+func NewParentNamespace(server *Server, opts ...NamespaceOption) ParentNamespace {
 	n := MakeParentNamespace()
 
+	n.SetOptions(NewNamespaceOptions(opts...))
 	n.Construct(server, "/_"+strconv.FormatUint(count.Add(1)-1, 10))
 
 	return n
@@ -70,11 +73,54 @@ func (p *parentNamespace) Emit(ev string, args ...any) error {
 	return nil
 }
 
+func (p *parentNamespace) SetPattern(pattern *NamespacePattern) {
+	p.pattern = pattern
+	p.regex = pattern.Regexp()
+}
+
+func (p *parentNamespace) Pattern() *NamespacePattern {
+	return p.pattern
+}
+
+func (p *parentNamespace) SetRegexp(regex *regexp.Regexp) {
+	p.regex = regex
+}
+
+func (p *parentNamespace) Regexp() *regexp.Regexp {
+	return p.regex
+}
+
+func (p *parentNamespace) RemoveChild(namespace Namespace) bool {
+	if !p.children.Has(namespace) {
+		return false
+	}
+	p.children.Delete(namespace)
+	return true
+}
+
+func (p *parentNamespace) Children() []Namespace {
+	return p.children.Keys()
+}
+
 func (p *parentNamespace) CreateChild(name string) Namespace {
 	parent_namespace_log.Debug("creating child namespace %s", name)
-	namespace := NewNamespace(p.Server(), name)
+	namespace := MakeNamespace()
+	// the options of the parent namespace apply to its children
+	namespace.SetOptions(p.Options())
+	namespace.Construct(p.Server(), name)
+
+	if p.pattern != nil {
+		// the params are available to the middlewares, since the child is not yet registered
+		if params, ok := p.pattern.Match(name); ok {
+			namespace.SetParams(params)
+		}
+	}
 
 	namespace.Fns().Replace(p.Fns().All())
+	namespace.SetJoinGuard(p.JoinGuard())
+	namespace.SetMiddlewareTimeout(p.MiddlewareTimeout())
+	namespace.SetEventMiddlewareTimeout(p.EventMiddlewareTimeout())
+	namespace.Rpc().Inherit(p.Rpc())
 
 	namespace.On("connect", p.Listeners("connect")...)
 	namespace.On("connection", p.Listeners("connection")...)
@@ -96,14 +142,3 @@ func (p *parentNamespace) CreateChild(name string) Namespace {
 	p.Server().Sockets().EmitReserved("new_namespace", namespace)
 	return namespace
 }
-
-func (p *parentNamespace) FetchSockets() func(func([]*RemoteSocket, error)) {
-	return func(callback func([]*RemoteSocket, error)) {
-		// note: we could make the FetchSockets() method work for dynamic namespaces created with a regex (by sending the
-		// regex to the other Socket.IO servers, and returning the sockets of each matching namespace for example), but
-		// the behavior for namespaces created with a function is less clear
-		// note²: we cannot loop over each children namespace, because with multiple Socket.IO servers, a given namespace
-		// may exist on one node but not exist on another (since it is created upon client connection)
-		callback(nil, errors.New("FetchSockets() is not supported on parent namespaces"))
-	}
-}
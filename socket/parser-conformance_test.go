@@ -0,0 +1,118 @@
+package socket
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+// The parsers which must behave like the default parser, apart from the wire format.
+var conformanceParsers = map[string]func() parser.Parser{
+	"default":  parser.NewParser,
+	"msgpack":  func() parser.Parser { return NewMsgpackParser() },
+	"cbor":     func() parser.Parser { return NewCborParser() },
+	"protobuf": func() parser.Parser { return MustNewProtobufParser() },
+}
+
+var conformancePackets = []struct {
+	name string
+	// a new packet for each parser, since the default encoder replaces the binary data with placeholders
+	packet func() *parser.Packet
+}{
+	{"CONNECT", func() *parser.Packet { return &parser.Packet{Type: parser.CONNECT, Nsp: "/"} }},
+	{"CONNECT with auth", func() *parser.Packet {
+		return &parser.Packet{Type: parser.CONNECT, Nsp: "/admin", Data: map[string]any{"token": "abc"}}
+	}},
+	{"DISCONNECT", func() *parser.Packet { return &parser.Packet{Type: parser.DISCONNECT, Nsp: "/chat"} }},
+	{"EVENT", func() *parser.Packet {
+		return &parser.Packet{Type: parser.EVENT, Nsp: "/", Data: []any{"hello", "world", map[string]any{"count": 3, "ok": true}, []any{1.5, nil}}}
+	}},
+	{"EVENT with ack", func() *parser.Packet {
+		return &parser.Packet{Type: parser.EVENT, Nsp: "/chat", Id: conformanceId(42), Data: []any{"hello"}}
+	}},
+	{"EVENT with binary", func() *parser.Packet {
+		return &parser.Packet{Type: parser.EVENT, Nsp: "/", Data: []any{"upload", types.NewBytesBuffer([]byte{0x01, 0x02, 0x03})}}
+	}},
+	{"ACK", func() *parser.Packet {
+		return &parser.Packet{Type: parser.ACK, Nsp: "/", Id: conformanceId(7), Data: []any{"ok"}}
+	}},
+	{"ACK with binary", func() *parser.Packet {
+		return &parser.Packet{Type: parser.ACK, Nsp: "/", Id: conformanceId(8), Data: []any{[]byte{0xff}}}
+	}},
+	{"CONNECT_ERROR", func() *parser.Packet {
+		return &parser.Packet{Type: parser.CONNECT_ERROR, Nsp: "/admin", Data: map[string]any{"message": "Not authorized", "data": nil}}
+	}},
+}
+
+func conformanceId(id uint64) *uint64 {
+	return &id
+}
+
+// Returns the value with the binary data as byte slices and the numbers as float64, since the parsers decode them
+// differently.
+func conformanceValue(value any) any {
+	switch v := inlineFrameData(value).(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case []any:
+		for i, item := range v {
+			v[i] = conformanceValue(item)
+		}
+		return v
+	case map[string]any:
+		for k, item := range v {
+			v[k] = conformanceValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func TestParserConformance(t *testing.T) {
+	for name, newParser := range conformanceParsers {
+		t.Run(name, func(t *testing.T) {
+			for _, test := range conformancePackets {
+				t.Run(test.name, func(t *testing.T) {
+					p := newParser()
+					expected := test.packet()
+					expected.Data = conformanceValue(expected.Data)
+
+					var packets []*parser.Packet
+					decoder := p.NewDecoder()
+					decoder.On("decoded", func(args ...any) {
+						packets = append(packets, args[0].(*parser.Packet))
+					})
+					for _, buffer := range p.NewEncoder().Encode(test.packet()) {
+						if err := decoder.Add(buffer); err != nil {
+							t.Fatal(err)
+						}
+					}
+
+					if len(packets) != 1 {
+						t.Fatalf("expected a single packet, got %d", len(packets))
+					}
+					packet := packets[0]
+					if framePacketType(packet.Type) != expected.Type || packet.Nsp != expected.Nsp || !reflect.DeepEqual(packet.Id, expected.Id) {
+						t.Fatalf("unexpected packet %+v", packet)
+					}
+					if data := conformanceValue(packet.Data); !reflect.DeepEqual(data, expected.Data) {
+						t.Fatalf("unexpected data %#v, expected %#v", data, expected.Data)
+					}
+				})
+			}
+
+			for _, data := range []any{"9", []byte{0xff, 0xff}} {
+				if err := newParser().NewDecoder().Add(data); err == nil {
+					t.Fatalf("expected an error for %#v", data)
+				}
+			}
+		})
+	}
+}
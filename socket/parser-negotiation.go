@@ -0,0 +1,126 @@
+package socket
+
+import (
+	"io"
+	"net/textproto"
+	"sync"
+
+	_types "github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/engine.io-server-go-fasthttp/v2/engine"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+type (
+	// A parser which may be selected by the clients, with its encoder which is shared by the clients.
+	negotiatedParser struct {
+		parser  parser.Parser
+		encoder parser.Encoder
+	}
+
+	// A packet which was encoded for the clients using a given encoder.
+	encodedBroadcast struct {
+		packets []_types.BufferInterface
+		opts    *WriteOptions
+	}
+)
+
+func newNegotiatedParsers(parsers map[string]parser.Parser) map[string]*negotiatedParser {
+	negotiatedParsers := make(map[string]*negotiatedParser, len(parsers))
+	for name, p := range parsers {
+		if p != nil {
+			negotiatedParsers[name] = &negotiatedParser{parser: p, encoder: p.NewEncoder()}
+		}
+	}
+	return negotiatedParsers
+}
+
+// Returns the parser selected by the client during the handshake, nil if the client uses the parser of the server,
+// and false if the selected parser is unknown.
+func (s *Server) negotiateParser(conn engine.Socket) (*negotiatedParser, bool) {
+	if len(s.parsers) == 0 {
+		return nil, true
+	}
+
+	opts := s.opts.ParserNegotiation()
+	req := conn.Request()
+	name := req.Query().Peek(opts.Query())
+	if name == "" {
+		// the headers are stored in their canonical form
+		name = req.Headers().Peek(textproto.CanonicalMIMEHeaderKey(opts.Header()))
+	}
+	if name == "" {
+		return nil, true
+	}
+	p, ok := s.parsers[name]
+	return p, ok
+}
+
+// Returns the function which encodes a broadcast packet for a client, the packet being encoded once per parser in use
+// among the recipients (including the parser of the server), upon the first write.
+func (a *adapter) _encodeBroadcast(packet *parser.Packet, packetOpts *WriteOptions) func(*Client) ([]_types.BufferInterface, *WriteOptions) {
+	// the readers would be drained by the first encoder
+	packet.Data = materializeBinaryData(packet.Data)
+	encode := func(encoder parser.Encoder) *encodedBroadcast {
+		opts := *packetOpts
+		opts.WsPreEncodedFrame = nil
+		// the encoders may update the packet (e.g. its type, if it contains binary data)
+		p := *packet
+		return &encodedBroadcast{packets: a._encode(encoder, &p, &opts), opts: &opts}
+	}
+
+	if len(a.nsp.Server().parsers) == 0 {
+		var once sync.Once
+		var e *encodedBroadcast
+		return func(*Client) ([]_types.BufferInterface, *WriteOptions) {
+			once.Do(func() {
+				e = encode(a.encoder)
+			})
+			return e.packets, e.opts
+		}
+	}
+
+	var mu sync.Mutex
+	encoded := map[parser.Encoder]*encodedBroadcast{}
+	return func(client *Client) ([]_types.BufferInterface, *WriteOptions) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		e, ok := encoded[client.encoder]
+		if !ok {
+			e = encode(client.encoder)
+			encoded[client.encoder] = e
+		}
+		return e.packets, e.opts
+	}
+}
+
+// Reads the binary data which are not byte slices (the readers and the buffers), so that the data can be encoded
+// several times. The containers are copied if needed, while the data without binary is returned as is.
+func materializeBinaryData(data any) any {
+	if !parser.HasBinary(data) {
+		return data
+	}
+	switch tdata := data.(type) {
+	case []byte:
+		return tdata
+	case []any:
+		newData := make([]any, 0, len(tdata))
+		for _, v := range tdata {
+			newData = append(newData, materializeBinaryData(v))
+		}
+		return newData
+	case map[string]any:
+		newData := make(map[string]any, len(tdata))
+		for k, v := range tdata {
+			newData[k] = materializeBinaryData(v)
+		}
+		return newData
+	case io.Reader:
+		if c, ok := tdata.(io.Closer); ok {
+			defer c.Close()
+		}
+		rdata, _ := io.ReadAll(tdata)
+		return rdata
+	}
+	return data
+}
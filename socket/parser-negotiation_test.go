@@ -0,0 +1,79 @@
+package socket
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	_types "github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+type countingEncoder struct {
+	parser.Encoder
+
+	count int
+}
+
+func (e *countingEncoder) Encode(packet *parser.Packet) []_types.BufferInterface {
+	e.count++
+	return e.Encoder.Encode(packet)
+}
+
+func TestAdapterEncodeBroadcast(t *testing.T) {
+	opts := DefaultServerOptions()
+	parserNegotiation := &ParserNegotiation{}
+	parserNegotiation.SetParsers(map[string]parser.Parser{"msgpack": NewMsgpackParser()})
+	opts.SetParserNegotiation(parserNegotiation)
+	a := NewServer(nil, opts).Of("/", nil).Adapter().(*adapter)
+
+	serverEncoder := &countingEncoder{Encoder: a.encoder}
+	a.encoder = serverEncoder
+	msgpackEncoder := &countingEncoder{Encoder: NewMsgpackParser().NewEncoder()}
+
+	packet := &parser.Packet{Type: parser.EVENT, Nsp: "/", Data: []any{"upload", bytes.NewReader([]byte{0x01, 0x02, 0x03})}}
+	encode := a._encodeBroadcast(packet, &WriteOptions{})
+	if serverEncoder.count != 0 {
+		t.Fatal("expected the packet to be encoded upon the first write")
+	}
+
+	// the binary data must be available to both encoders
+	decoded := []*parser.Packet{}
+	for _, test := range []struct {
+		encoder parser.Encoder
+		parser  parser.Parser
+	}{
+		{msgpackEncoder, NewMsgpackParser()},
+		{serverEncoder, parser.NewParser()},
+		{msgpackEncoder, NewMsgpackParser()},
+		{serverEncoder, parser.NewParser()},
+	} {
+		packets, _ := encode(&Client{encoder: test.encoder})
+		decoder := test.parser.NewDecoder()
+		decoder.On("decoded", func(args ...any) {
+			decoded = append(decoded, args[0].(*parser.Packet))
+		})
+		// the buffers are shared by the writes, so they must not be consumed
+		for _, buffer := range packets {
+			var data any = buffer.Bytes()
+			if _, ok := buffer.(*_types.StringBuffer); ok {
+				data = buffer.String()
+			}
+			if err := decoder.Add(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if serverEncoder.count != 1 || msgpackEncoder.count != 1 {
+		t.Fatalf("expected the packet to be encoded once per encoder, got %d and %d", serverEncoder.count, msgpackEncoder.count)
+	}
+	if len(decoded) != 4 {
+		t.Fatalf("expected 4 decoded packets, got %d", len(decoded))
+	}
+	for _, packet := range decoded {
+		if data := inlineFrameData(packet.Data); !reflect.DeepEqual(data, []any{"upload", []byte{0x01, 0x02, 0x03}}) {
+			t.Fatalf("unexpected data %#v", data)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package socket
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/engine.io/v2/utils"
+)
+
+var presence_log = log.NewLog("socket.io:presence")
+
+var (
+	// the presence trackers of each namespace, see [presenceTrackers]
+	presenceTrackersOf   = map[Namespace]*presenceTrackers{}
+	presenceTrackersOfMu sync.Mutex
+)
+
+type (
+	// Extracts the identity of the user behind a socket (usually from [Socket.Data]), the socket is ignored when
+	// `false` is returned.
+	PresenceIdentity func(SocketDetails) (string, bool)
+
+	// The number of connections of a user in a room, on a given Socket.IO server. Updates are idempotent, so they
+	// can be replayed safely by a [PresenceTransport].
+	PresenceUpdate struct {
+		ServerId    string `json:"serverId" mapstructure:"serverId" msgpack:"serverId"`
+		Room        Room   `json:"room" mapstructure:"room" msgpack:"room"`
+		UserId      string `json:"userId" mapstructure:"userId" msgpack:"userId"`
+		Connections int    `json:"connections" mapstructure:"connections" msgpack:"connections"`
+	}
+
+	// A user present in a room.
+	PresenceMember struct {
+		UserId string `json:"userId" mapstructure:"userId" msgpack:"userId"`
+		// The number of connections (tabs, devices...) of the user in the room, across the cluster
+		Connections int `json:"connections" mapstructure:"connections" msgpack:"connections"`
+	}
+
+	// The users which joined or left a room.
+	PresenceDiff struct {
+		Room   Room     `json:"room" mapstructure:"room" msgpack:"room"`
+		Joined []string `json:"joined,omitempty" mapstructure:"joined,omitempty" msgpack:"joined,omitempty"`
+		Left   []string `json:"left,omitempty" mapstructure:"left,omitempty" msgpack:"left,omitempty"`
+	}
+
+	// Ships the presence updates between the Socket.IO servers of the cluster.
+	PresenceTransport interface {
+		// Sends an update of the current server to the other servers.
+		Publish(*PresenceUpdate) error
+
+		// Registers the function which is called with each update received from the other servers.
+		Subscribe(func(*PresenceUpdate))
+	}
+
+	PresenceOptions struct {
+		// Extracts the identity of the user behind a socket
+		Identity PresenceIdentity
+		// The transport used to share presence across the cluster, nil for a single server
+		Transport PresenceTransport
+		// The unique ID of the current server, generated if empty
+		ServerId string
+	}
+
+	presenceSubscription struct {
+		room Room
+		fn   func(*PresenceDiff)
+	}
+
+	// Tracks which users (not sockets) are in the rooms of a namespace, based on the `join-room` and `leave-room`
+	// events of its adapter. Several sockets of the same user collapse into a single presence entry.
+	//
+	//	presence := socket.NewPresence(io.Of("/chat", nil), &socket.PresenceOptions{
+	//		Identity: func(s socket.SocketDetails) (string, bool) {
+	//			user, ok := s.Data().(*User)
+	//			if !ok {
+	//				return "", false
+	//			}
+	//			return user.Id, true
+	//		},
+	//	})
+	//
+	//	presence.Subscribe("room-101", func(diff *socket.PresenceDiff) {
+	//		io.Of("/chat", nil).To("room-101").Emit("presence", diff)
+	//	})
+	//
+	//	members := presence.Members("room-101")
+	Presence struct {
+		nsp       Namespace
+		identity  PresenceIdentity
+		transport PresenceTransport
+		serverId  string
+
+		mu sync.Mutex
+		// the user behind each tracked socket, captured upon the first join
+		users map[SocketId]string
+		// the number of tracked rooms of each socket
+		refs map[SocketId]int
+		// the local sockets of each user in each room
+		local map[Room]map[string]map[SocketId]struct{}
+		// the number of connections of each user in each room, for each other server
+		remote map[Room]map[string]map[string]int
+
+		subscriptions   []*presenceSubscription
+		subscriptionsMu sync.RWMutex
+
+		closed atomic.Bool
+	}
+
+	// The presence trackers of a namespace. An emitter identifies its listeners by their code pointer, which is the
+	// same for all the method values (and closures) of a function, so removing the listeners of a tracker would remove
+	// the ones of the other trackers: the listeners are registered once per namespace and dispatch the events to its
+	// trackers instead.
+	presenceTrackers struct {
+		nsp     Namespace
+		adapter Adapter
+
+		mu       sync.RWMutex
+		trackers []*Presence
+	}
+)
+
+func MakePresence() *Presence {
+	p := &Presence{
+		users:  map[SocketId]string{},
+		refs:   map[SocketId]int{},
+		local:  map[Room]map[string]map[SocketId]struct{}{},
+		remote: map[Room]map[string]map[string]int{},
+	}
+
+	return p
+}
+
+func NewPresence(nsp Namespace, opts *PresenceOptions) *Presence {
+	p := MakePresence()
+
+	p.Construct(nsp, opts)
+
+	return p
+}
+
+func (p *Presence) Construct(nsp Namespace, opts *PresenceOptions) {
+	if opts == nil {
+		opts = &PresenceOptions{}
+	}
+	p.nsp = nsp
+	p.identity = opts.Identity
+	if p.identity == nil {
+		p.identity = func(socket SocketDetails) (string, bool) {
+			return string(socket.Id()), true
+		}
+	}
+	p.transport = opts.Transport
+	p.serverId = opts.ServerId
+	if p.serverId == "" {
+		p.serverId, _ = utils.Base64Id().GenerateId()
+	}
+
+	attachPresence(p)
+
+	if p.transport != nil {
+		p.transport.Subscribe(p.onupdate)
+	}
+}
+
+// The unique ID of the current server.
+func (p *Presence) ServerId() string {
+	return p.serverId
+}
+
+// Stops tracking the presence.
+func (p *Presence) Close() {
+	if p.closed.Swap(true) {
+		return
+	}
+	detachPresence(p)
+}
+
+// Adds a tracker to the trackers of its namespace, the listeners are registered along with the first one.
+func attachPresence(p *Presence) {
+	presenceTrackersOfMu.Lock()
+	defer presenceTrackersOfMu.Unlock()
+
+	t, ok := presenceTrackersOf[p.nsp]
+	if !ok {
+		t = &presenceTrackers{nsp: p.nsp, adapter: p.nsp.Adapter()}
+		t.adapter.On("join-room", t.onjoin)
+		t.adapter.On("leave-room", t.onleave)
+		// the sockets may join rooms in a middleware, before being tracked by the namespace
+		t.nsp.On("connection", t.onconnection)
+		presenceTrackersOf[p.nsp] = t
+	}
+	t.mu.Lock()
+	t.trackers = append(t.trackers, p)
+	t.mu.Unlock()
+}
+
+// Removes a tracker from the trackers of its namespace, the listeners are removed along with the last one.
+func detachPresence(p *Presence) {
+	presenceTrackersOfMu.Lock()
+	defer presenceTrackersOfMu.Unlock()
+
+	t, ok := presenceTrackersOf[p.nsp]
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	for i, tracker := range t.trackers {
+		if tracker == p {
+			t.trackers = append(t.trackers[:i:i], t.trackers[i+1:]...)
+			break
+		}
+	}
+	empty := len(t.trackers) == 0
+	t.mu.Unlock()
+	if empty {
+		t.adapter.RemoveListener("join-room", t.onjoin)
+		t.adapter.RemoveListener("leave-room", t.onleave)
+		t.nsp.EventEmitter().RemoveListener("connection", t.onconnection)
+		delete(presenceTrackersOf, p.nsp)
+	}
+}
+
+func (t *presenceTrackers) each(fn func(*Presence)) {
+	t.mu.RLock()
+	trackers := t.trackers
+	t.mu.RUnlock()
+	for _, p := range trackers {
+		fn(p)
+	}
+}
+
+func (t *presenceTrackers) onconnection(args ...any) {
+	t.each(func(p *Presence) { p.onconnection(args...) })
+}
+
+func (t *presenceTrackers) onjoin(args ...any) {
+	t.each(func(p *Presence) { p.onjoin(args...) })
+}
+
+func (t *presenceTrackers) onleave(args ...any) {
+	t.each(func(p *Presence) { p.onleave(args...) })
+}
+
+func (p *Presence) onconnection(args ...any) {
+	socket, ok := args[0].(*Socket)
+	if !ok {
+		return
+	}
+	for _, room := range socket.Rooms().Keys() {
+		p.add(room, socket)
+	}
+}
+
+func (p *Presence) onjoin(args ...any) {
+	room, _ := args[0].(Room)
+	id, _ := args[1].(SocketId)
+	if socket, ok := p.nsp.Sockets().Load(id); ok {
+		p.add(room, socket)
+	}
+}
+
+func (p *Presence) onleave(args ...any) {
+	room, _ := args[0].(Room)
+	id, _ := args[1].(SocketId)
+	p.remove(room, id)
+}
+
+func (p *Presence) add(room Room, socket SocketDetails) {
+	if p.closed.Load() || room == Room(socket.Id()) {
+		// the private room of each socket is not tracked
+		return
+	}
+
+	p.mu.Lock()
+	userId, ok := p.users[socket.Id()]
+	if !ok {
+		if userId, ok = p.identity(socket); !ok {
+			p.mu.Unlock()
+			return
+		}
+		p.users[socket.Id()] = userId
+	}
+	users, ok := p.local[room]
+	if !ok {
+		users = map[string]map[SocketId]struct{}{}
+		p.local[room] = users
+	}
+	sids, ok := users[userId]
+	if !ok {
+		sids = map[SocketId]struct{}{}
+		users[userId] = sids
+	}
+	if _, ok := sids[socket.Id()]; ok {
+		p.mu.Unlock()
+		return
+	}
+	before := p.connections(room, userId)
+	sids[socket.Id()] = struct{}{}
+	p.refs[socket.Id()]++
+	update := &PresenceUpdate{ServerId: p.serverId, Room: room, UserId: userId, Connections: len(sids)}
+	p.mu.Unlock()
+
+	presence_log.Debug("user %s joined room %s with socket %s", userId, room, socket.Id())
+	p.publish(update)
+	if before == 0 {
+		p.notify(&PresenceDiff{Room: room, Joined: []string{userId}})
+	}
+}
+
+func (p *Presence) remove(room Room, id SocketId) {
+	if p.closed.Load() {
+		return
+	}
+
+	p.mu.Lock()
+	userId, ok := p.users[id]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	sids := p.local[room][userId]
+	if _, ok := sids[id]; !ok {
+		p.mu.Unlock()
+		return
+	}
+	delete(sids, id)
+	if len(sids) == 0 {
+		delete(p.local[room], userId)
+		if len(p.local[room]) == 0 {
+			delete(p.local, room)
+		}
+	}
+	if p.refs[id]--; p.refs[id] <= 0 {
+		delete(p.refs, id)
+		delete(p.users, id)
+	}
+	after := p.connections(room, userId)
+	update := &PresenceUpdate{ServerId: p.serverId, Room: room, UserId: userId, Connections: len(sids)}
+	p.mu.Unlock()
+
+	presence_log.Debug("user %s left room %s with socket %s", userId, room, id)
+	p.publish(update)
+	if after == 0 {
+		p.notify(&PresenceDiff{Room: room, Left: []string{userId}})
+	}
+}
+
+// The number of connections of a user in a room, across the cluster. Must be called with the lock held.
+func (p *Presence) connections(room Room, userId string) int {
+	count := len(p.local[room][userId])
+	for _, c := range p.remote[room][userId] {
+		count += c
+	}
+	return count
+}
+
+func (p *Presence) publish(update *PresenceUpdate) {
+	if p.transport == nil {
+		return
+	}
+	if err := p.transport.Publish(update); err != nil {
+		presence_log.Debug("error while publishing presence update: %v", err)
+	}
+}
+
+// Called with each update received from another server.
+func (p *Presence) onupdate(update *PresenceUpdate) {
+	if update == nil || update.ServerId == p.serverId || p.closed.Load() {
+		return
+	}
+
+	p.mu.Lock()
+	before := p.connections(update.Room, update.UserId)
+	p.setRemote(update.ServerId, update.Room, update.UserId, update.Connections)
+	after := p.connections(update.Room, update.UserId)
+	p.mu.Unlock()
+
+	p.notifyChange(update.Room, update.UserId, before, after)
+}
+
+// Must be called with the lock held.
+func (p *Presence) setRemote(serverId string, room Room, userId string, connections int) {
+	users, ok := p.remote[room]
+	if !ok {
+		if connections <= 0 {
+			return
+		}
+		users = map[string]map[string]int{}
+		p.remote[room] = users
+	}
+	servers, ok := users[userId]
+	if !ok {
+		if connections <= 0 {
+			return
+		}
+		servers = map[string]int{}
+		users[userId] = servers
+	}
+	if connections > 0 {
+		servers[serverId] = connections
+		return
+	}
+	delete(servers, serverId)
+	if len(servers) == 0 {
+		delete(users, userId)
+		if len(users) == 0 {
+			delete(p.remote, room)
+		}
+	}
+}
+
+func (p *Presence) notifyChange(room Room, userId string, before, after int) {
+	if before == 0 && after > 0 {
+		p.notify(&PresenceDiff{Room: room, Joined: []string{userId}})
+	} else if before > 0 && after == 0 {
+		p.notify(&PresenceDiff{Room: room, Left: []string{userId}})
+	}
+}
+
+// Forgets the presence reported by a server which left the cluster.
+//
+// Param: serverId - the ID of the server
+func (p *Presence) RemoveServer(serverId string) {
+	type change struct {
+		room   Room
+		userId string
+		before int
+	}
+	changes := []*change{}
+
+	p.mu.Lock()
+	for room, users := range p.remote {
+		for userId, servers := range users {
+			if _, ok := servers[serverId]; ok {
+				changes = append(changes, &change{room, userId, p.connections(room, userId)})
+			}
+		}
+	}
+	for _, c := range changes {
+		p.setRemote(serverId, c.room, c.userId, 0)
+	}
+	diffs := map[Room]*PresenceDiff{}
+	for _, c := range changes {
+		if p.connections(c.room, c.userId) == 0 {
+			diff, ok := diffs[c.room]
+			if !ok {
+				diff = &PresenceDiff{Room: c.room}
+				diffs[c.room] = diff
+			}
+			diff.Left = append(diff.Left, c.userId)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, diff := range diffs {
+		p.notify(diff)
+	}
+}
+
+// Returns the state of the current server, which a [PresenceTransport] can send to a server joining the cluster.
+func (p *Presence) Snapshot() []*PresenceUpdate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	updates := []*PresenceUpdate{}
+	for room, users := range p.local {
+		for userId, sids := range users {
+			updates = append(updates, &PresenceUpdate{ServerId: p.serverId, Room: room, UserId: userId, Connections: len(sids)})
+		}
+	}
+	return updates
+}
+
+// Applies the updates received from another server, see [Presence.Snapshot].
+func (p *Presence) Apply(updates []*PresenceUpdate) {
+	for _, update := range updates {
+		p.onupdate(update)
+	}
+}
+
+// Returns the users present in a room, across the cluster.
+//
+// Param: room - the room
+func (p *Presence) Members(room Room) []*PresenceMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := map[string]int{}
+	for userId, sids := range p.local[room] {
+		counts[userId] += len(sids)
+	}
+	for userId, servers := range p.remote[room] {
+		for _, c := range servers {
+			counts[userId] += c
+		}
+	}
+	members := make([]*PresenceMember, 0, len(counts))
+	for userId, c := range counts {
+		members = append(members, &PresenceMember{UserId: userId, Connections: c})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].UserId < members[j].UserId
+	})
+	return members
+}
+
+// Whether a user is present in a room, across the cluster.
+//
+// Param: room - the room
+//
+// Param: userId - the identity of the user
+func (p *Presence) IsPresent(room Room, userId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.connections(room, userId) > 0
+}
+
+// Registers a function which is called each time users join or leave a room.
+//
+//	unsubscribe := presence.Subscribe("room-101", func(diff *socket.PresenceDiff) {
+//		fmt.Println(diff.Joined, diff.Left)
+//	})
+//	defer unsubscribe()
+//
+// Param: room - the room, or an empty room to be notified for all rooms
+//
+// Param: fn - the function
+//
+// Return: a function which removes the subscription
+func (p *Presence) Subscribe(room Room, fn func(*PresenceDiff)) func() {
+	subscription := &presenceSubscription{room: room, fn: fn}
+
+	p.subscriptionsMu.Lock()
+	p.subscriptions = append(p.subscriptions, subscription)
+	p.subscriptionsMu.Unlock()
+
+	return func() {
+		p.subscriptionsMu.Lock()
+		defer p.subscriptionsMu.Unlock()
+		for i, s := range p.subscriptions {
+			if s == subscription {
+				p.subscriptions = append(p.subscriptions[:i:i], p.subscriptions[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (p *Presence) notify(diff *PresenceDiff) {
+	p.subscriptionsMu.RLock()
+	subscriptions := append([]*presenceSubscription{}, p.subscriptions...)
+	p.subscriptionsMu.RUnlock()
+
+	for _, subscription := range subscriptions {
+		if subscription.room == "" || subscription.room == diff.Room {
+			subscription.fn(diff)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package socket
+
+import (
+	"testing"
+)
+
+func TestPresenceCloseKeepsOtherTrackers(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/presence", nil)
+	nsp.Sockets().Store("a", &Socket{id: "a"})
+	nsp.Sockets().Store("b", &Socket{id: "b"})
+
+	first := NewPresence(nsp, nil)
+	second := NewPresence(nsp, nil)
+
+	nsp.Adapter().Emit("join-room", Room("room"), SocketId("a"))
+	first.Close()
+	nsp.Adapter().Emit("join-room", Room("room"), SocketId("b"))
+
+	if !second.IsPresent("room", "a") || !second.IsPresent("room", "b") {
+		t.Fatalf("expected the remaining tracker to track both sockets, got %v", second.Members("room"))
+	}
+	if first.IsPresent("room", "b") {
+		t.Fatal("expected the closed tracker to ignore the joins")
+	}
+
+	second.Close()
+	if count := nsp.Adapter().ListenerCount("join-room"); count != 0 {
+		t.Fatalf("expected the listeners to be removed along with the last tracker, got %d", count)
+	}
+}
@@ -0,0 +1,342 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var protobuf_parser_log = log.NewLog("socket.io:protobuf-parser")
+
+// The field numbers of the envelope, see [ProtobufParser].
+const (
+	protobufPacketType protowire.Number = 1
+	protobufPacketNsp  protowire.Number = 2
+	protobufPacketId   protowire.Number = 3
+	protobufPacketArgs protowire.Number = 4
+	protobufPacketData protowire.Number = 5
+)
+
+type (
+	// A parser where each packet is encoded as a single Protobuf envelope:
+	//
+	//	syntax = "proto3";
+	//
+	//	import "google/protobuf/any.proto";
+	//	import "google/protobuf/struct.proto";
+	//
+	//	message Packet {
+	//	  uint32 type = 1;                       // from 0 (CONNECT) to 4 (CONNECT_ERROR)
+	//	  string nsp = 2;
+	//	  optional uint64 id = 3;
+	//	  repeated google.protobuf.Any args = 4; // EVENT and ACK packets
+	//	  google.protobuf.Value data = 5;        // CONNECT and CONNECT_ERROR packets
+	//	}
+	//
+	// The name of an event is the first argument, as a google.protobuf.StringValue. The arguments are encoded as:
+	//
+	// - the message itself, for a [proto.Message]
+	//
+	// - a google.protobuf.StringValue, for a string
+	//
+	// - a google.protobuf.BytesValue, for binary data (decoded as [types.BytesBuffer])
+	//
+	// - a google.protobuf.Value otherwise, like with the JSON encoding of the value (decoded as a map, a slice, a
+	// float64...)
+	//
+	// Only the messages of the registry of the parser are decoded, the other arguments are rejected:
+	//
+	//	p, err := socket.NewProtobufParser(&pb.Order{}, &pb.OrderStatus{})
+	//	if err != nil {
+	//		// the messages conflict with the ones of the registry
+	//	}
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetParser(p)
+	//	io := socket.NewServer(nil, opts)
+	//
+	//	io.On("connection", func(args ...any) {
+	//		client := args[0].(*socket.Socket)
+	//		client.On("order:create", func(args ...any) {
+	//			order := args[0].(*pb.Order)
+	//		})
+	//	})
+	ProtobufParser struct {
+		types   *protoregistry.Types
+		typesMu sync.RWMutex
+	}
+
+	protobufEncoder struct {
+	}
+)
+
+// Returns a parser whose registry contains the given messages, in addition to google.protobuf.StringValue,
+// google.protobuf.BytesValue and google.protobuf.Value. An error is returned if the messages cannot be registered, see
+// [ProtobufParser.Register].
+func NewProtobufParser(messages ...proto.Message) (*ProtobufParser, error) {
+	p := &ProtobufParser{types: &protoregistry.Types{}}
+	if err := p.Register(&wrapperspb.StringValue{}, &wrapperspb.BytesValue{}, &structpb.Value{}); err != nil {
+		return nil, err
+	}
+	if err := p.Register(messages...); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Like [NewProtobufParser], but panics if the messages cannot be registered.
+func MustNewProtobufParser(messages ...proto.Message) *ProtobufParser {
+	p, err := NewProtobufParser(messages...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Adds messages to the registry of the parser, so that the arguments of these types can be decoded.
+func (p *ProtobufParser) Register(messages ...proto.Message) error {
+	p.typesMu.Lock()
+	defer p.typesMu.Unlock()
+
+	for _, message := range messages {
+		mt := message.ProtoReflect().Type()
+		if _, err := p.types.FindMessageByName(mt.Descriptor().FullName()); err == nil {
+			continue
+		}
+		if err := p.types.RegisterMessage(mt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ProtobufParser) NewEncoder() parser.Encoder {
+	return &protobufEncoder{}
+}
+
+func (p *ProtobufParser) NewDecoder() parser.Decoder {
+	return newFrameDecoder(protobuf_parser_log, p.decodePacket)
+}
+
+// Returns a new message of the type of the google.protobuf.Any, if it is registered.
+func (p *ProtobufParser) newMessage(arg *anypb.Any) (proto.Message, error) {
+	p.typesMu.RLock()
+	defer p.typesMu.RUnlock()
+
+	mt, err := p.types.FindMessageByURL(arg.GetTypeUrl())
+	if err != nil {
+		return nil, fmt.Errorf("unregistered message %q", arg.GetTypeUrl())
+	}
+	return mt.New().Interface(), nil
+}
+
+// Encodes a packet as a single Protobuf envelope.
+func (e *protobufEncoder) Encode(packet *parser.Packet) []types.BufferInterface {
+	protobuf_parser_log.Debug("encoding packet %v", packet)
+
+	data, err := protobufEncodePacket(packet)
+	if err != nil {
+		protobuf_parser_log.Error("failed to encode packet %v: %v", packet, err)
+		return nil
+	}
+	return []types.BufferInterface{types.NewBytesBuffer(data)}
+}
+
+func protobufEncodePacket(packet *parser.Packet) (b []byte, err error) {
+	packetType := framePacketType(packet.Type)
+	b = protowire.AppendTag(b, protobufPacketType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(packetType-parser.CONNECT))
+	b = protowire.AppendTag(b, protobufPacketNsp, protowire.BytesType)
+	b = protowire.AppendString(b, packet.Nsp)
+	if packet.Id != nil {
+		b = protowire.AppendTag(b, protobufPacketId, protowire.VarintType)
+		b = protowire.AppendVarint(b, *packet.Id)
+	}
+
+	if packet.Data == nil {
+		return b, nil
+	}
+	switch packetType {
+	case parser.EVENT, parser.ACK:
+		args, ok := packet.Data.([]any)
+		if !ok {
+			return nil, errors.New("the data of an EVENT or ACK packet must be a slice")
+		}
+		for _, arg := range args {
+			rarg, err := protobufEncodeArg(arg)
+			if err != nil {
+				return nil, err
+			}
+			if b, err = protobufAppendMessage(b, protobufPacketArgs, rarg); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		value, err := protobufValue(packet.Data)
+		if err != nil {
+			return nil, err
+		}
+		if b, err = protobufAppendMessage(b, protobufPacketData, value); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func protobufAppendMessage(b []byte, num protowire.Number, message proto.Message) ([]byte, error) {
+	rmessage, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, rmessage), nil
+}
+
+func protobufEncodeArg(arg any) (*anypb.Any, error) {
+	if message, ok := arg.(proto.Message); ok {
+		return anypb.New(message)
+	}
+	switch rarg := inlineFrameData(arg).(type) {
+	case string:
+		return anypb.New(wrapperspb.String(rarg))
+	case []byte:
+		return anypb.New(wrapperspb.Bytes(rarg))
+	default:
+		value, err := protobufValue(rarg)
+		if err != nil {
+			return nil, err
+		}
+		return anypb.New(value)
+	}
+}
+
+// Returns the google.protobuf.Value of a value, which is converted like with its JSON encoding if needed (e.g. for a
+// struct).
+func protobufValue(data any) (*structpb.Value, error) {
+	if value, err := structpb.NewValue(inlineFrameData(data)); err == nil {
+		return value, nil
+	}
+	rdata, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var jdata any
+	if err := json.Unmarshal(rdata, &jdata); err != nil {
+		return nil, err
+	}
+	return structpb.NewValue(jdata)
+}
+
+func (p *ProtobufParser) decodePacket(b []byte) (*parser.Packet, error) {
+	packet := &parser.Packet{Type: parser.CONNECT}
+	var args []any
+	var data any
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, errors.New("invalid payload")
+		}
+		b = b[n:]
+
+		switch {
+		case num == protobufPacketType && typ == protowire.VarintType:
+			var t uint64
+			if t, n = protowire.ConsumeVarint(b); n >= 0 {
+				packetType, err := frameDecodePacketType(t)
+				if err != nil {
+					return nil, err
+				}
+				packet.Type = packetType
+			}
+		case num == protobufPacketNsp && typ == protowire.BytesType:
+			packet.Nsp, n = protowire.ConsumeString(b)
+		case num == protobufPacketId && typ == protowire.VarintType:
+			var id uint64
+			if id, n = protowire.ConsumeVarint(b); n >= 0 {
+				packet.Id = &id
+			}
+		case num == protobufPacketArgs && typ == protowire.BytesType:
+			var rarg []byte
+			if rarg, n = protowire.ConsumeBytes(b); n >= 0 {
+				arg, err := p.decodeArg(rarg)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+		case num == protobufPacketData && typ == protowire.BytesType:
+			var rdata []byte
+			if rdata, n = protowire.ConsumeBytes(b); n >= 0 {
+				value := &structpb.Value{}
+				if err := proto.Unmarshal(rdata, value); err != nil {
+					return nil, errors.New("invalid payload")
+				}
+				data = value.AsInterface()
+			}
+		default:
+			// unknown fields are skipped, like with the generated code
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, errors.New("invalid payload")
+		}
+		b = b[n:]
+	}
+
+	if packet.Nsp == "" {
+		return nil, errors.New("Illegal namespace")
+	}
+
+	switch packet.Type {
+	case parser.EVENT, parser.ACK:
+		if args == nil {
+			args = []any{}
+		}
+		data = args
+	default:
+		if args != nil {
+			return nil, errors.New("invalid payload")
+		}
+	}
+	if !isFramePayloadValid(packet.Type, data) {
+		return nil, errors.New("invalid payload")
+	}
+	packet.Data = data
+
+	return packet, nil
+}
+
+func (p *ProtobufParser) decodeArg(b []byte) (any, error) {
+	rarg := &anypb.Any{}
+	if err := proto.Unmarshal(b, rarg); err != nil {
+		return nil, errors.New("invalid payload")
+	}
+	message, err := p.newMessage(rarg)
+	if err != nil {
+		return nil, err
+	}
+	if err := rarg.UnmarshalTo(message); err != nil {
+		return nil, errors.New("invalid payload")
+	}
+
+	switch arg := message.(type) {
+	case *wrapperspb.StringValue:
+		return arg.GetValue(), nil
+	case *wrapperspb.BytesValue:
+		return types.NewBytesBuffer(arg.GetValue()), nil
+	case *structpb.Value:
+		return arg.AsInterface(), nil
+	}
+	return message, nil
+}
@@ -0,0 +1,289 @@
+package socket
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/engine.io/v2/types"
+	"github.com/zishang520/engine.io/v2/utils"
+)
+
+var room_history_log = log.NewLog("socket.io:room-history")
+
+type (
+	// An event retained in the history of a room.
+	RoomHistoryEntry struct {
+		Id        string `json:"id" mapstructure:"id" msgpack:"id"`
+		Room      Room   `json:"room" mapstructure:"room" msgpack:"room"`
+		EmittedAt int64  `json:"emittedAt" mapstructure:"emittedAt" msgpack:"emittedAt"`
+		// The event name followed by its arguments
+		Data []any `json:"data" mapstructure:"data" msgpack:"data"`
+
+		// The other rooms the recipients had to join (see [BroadcastOperator.InAll])
+		InAll []Room `json:"inAll,omitempty" mapstructure:"inAll,omitempty" msgpack:"inAll,omitempty"`
+		// The rooms whose members were excluded (see [BroadcastOperator.Except])
+		Except []Room `json:"except,omitempty" mapstructure:"except,omitempty" msgpack:"except,omitempty"`
+		// The patterns of the rooms whose members were excluded (see [BroadcastOperator.ExceptPattern])
+		ExceptPatterns []string `json:"exceptPatterns,omitempty" mapstructure:"exceptPatterns,omitempty" msgpack:"exceptPatterns,omitempty"`
+		// The conditions the recipients had to satisfy (see [BroadcastOperator.WherePredicate])
+		Predicates []*SocketPredicate `json:"predicates,omitempty" mapstructure:"predicates,omitempty" msgpack:"predicates,omitempty"`
+	}
+
+	// How the history of a room is bounded and replayed.
+	RoomHistoryOptions struct {
+		// The maximum number of retained events (0 means no limit)
+		MaxEvents int
+		// The maximum age of the retained events (0 means no limit)
+		MaxAge time.Duration
+		// Whether the retained events are replayed to the sockets joining the room
+		ReplayOnJoin bool
+	}
+
+	// Stores the history of the rooms, the in-memory [MemoryRoomHistoryStore] is used by default.
+	//
+	// A shared store (e.g. backed by Redis) makes the history of a room available to every Socket.IO server of the
+	// cluster.
+	RoomHistoryStore interface {
+		// Appends an event to the history of a room, and drops the events exceeding the given bounds.
+		Append(Room, *RoomHistoryEntry, *RoomHistoryOptions) error
+
+		// Returns the retained events of a room emitted after the given time (unix milliseconds), in order.
+		Range(Room, int64) ([]*RoomHistoryEntry, error)
+
+		// Removes the history of a room.
+		Delete(Room) error
+	}
+
+	memoryRoomHistory struct {
+		mu      sync.Mutex
+		entries []*RoomHistoryEntry
+	}
+
+	// The default in-memory [RoomHistoryStore].
+	MemoryRoomHistoryStore struct {
+		rooms *types.Map[Room, *memoryRoomHistory]
+	}
+
+	// The opt-in per-room history of a namespace, which is fed by [BroadcastOperator.History] emissions and replayed
+	// to late joiners.
+	//
+	//	nsp := io.Of("/chat", nil)
+	//	nsp.RoomHistory().Enable("general", &socket.RoomHistoryOptions{
+	//		MaxEvents:    50,
+	//		MaxAge:       10 * time.Minute,
+	//		ReplayOnJoin: true,
+	//	})
+	//
+	//	nsp.To("general").History().Emit("message", "hello")
+	RoomHistory struct {
+		rooms *types.Map[Room, *RoomHistoryOptions]
+		store RoomHistoryStore
+		mu    sync.RWMutex
+	}
+)
+
+func NewMemoryRoomHistoryStore() *MemoryRoomHistoryStore {
+	return &MemoryRoomHistoryStore{rooms: &types.Map[Room, *memoryRoomHistory]{}}
+}
+
+func (m *MemoryRoomHistoryStore) Append(room Room, entry *RoomHistoryEntry, opts *RoomHistoryOptions) error {
+	history, _ := m.rooms.LoadOrStore(room, &memoryRoomHistory{})
+
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	history.entries = append(history.entries, entry)
+	drop := 0
+	if opts != nil {
+		if opts.MaxEvents > 0 && len(history.entries) > opts.MaxEvents {
+			drop = len(history.entries) - opts.MaxEvents
+		}
+		if opts.MaxAge > 0 {
+			threshold := time.Now().Add(-opts.MaxAge).UnixMilli()
+			for drop < len(history.entries) && history.entries[drop].EmittedAt < threshold {
+				drop++
+			}
+		}
+	}
+	if drop > 0 {
+		history.entries = append(history.entries[:0:0], history.entries[drop:]...)
+	}
+	return nil
+}
+
+func (m *MemoryRoomHistoryStore) Range(room Room, since int64) ([]*RoomHistoryEntry, error) {
+	history, ok := m.rooms.Load(room)
+	if !ok {
+		return nil, nil
+	}
+
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	entries := []*RoomHistoryEntry{}
+	for _, entry := range history.entries {
+		if entry.EmittedAt > since {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryRoomHistoryStore) Delete(room Room) error {
+	m.rooms.Delete(room)
+	return nil
+}
+
+func MakeRoomHistory() *RoomHistory {
+	r := &RoomHistory{
+		rooms: &types.Map[Room, *RoomHistoryOptions]{},
+		store: NewMemoryRoomHistoryStore(),
+	}
+
+	return r
+}
+
+func NewRoomHistory() *RoomHistory {
+	return MakeRoomHistory()
+}
+
+// Sets the store of the history.
+//
+// Param: RoomHistoryStore - the store
+func (r *RoomHistory) SetStore(store RoomHistoryStore) *RoomHistory {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.store = store
+	return r
+}
+
+func (r *RoomHistory) Store() RoomHistoryStore {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.store
+}
+
+// Enables the history of a room.
+//
+// Param: room - the room
+//
+// Param: opts - the bounds of the history
+func (r *RoomHistory) Enable(room Room, opts *RoomHistoryOptions) *RoomHistory {
+	if opts == nil {
+		opts = &RoomHistoryOptions{}
+	}
+	r.rooms.Store(room, opts)
+	return r
+}
+
+// Disables the history of a room, and removes its retained events.
+//
+// Param: room - the room
+func (r *RoomHistory) Disable(room Room) *RoomHistory {
+	if _, ok := r.rooms.LoadAndDelete(room); ok {
+		if err := r.Store().Delete(room); err != nil {
+			room_history_log.Debug("error while deleting the history of room %s: %v", room, err)
+		}
+	}
+	return r
+}
+
+// Returns the options of a room, if its history is enabled.
+func (r *RoomHistory) Options(room Room) (*RoomHistoryOptions, bool) {
+	return r.rooms.Load(room)
+}
+
+// Appends an event to the history of the rooms it was broadcast to, the rooms without history are ignored.
+//
+// The event is retained by the rooms targeted with `Rooms` and `RoomPatterns` (or with `InAll` when there are none),
+// except the excluded ones. The other conditions (`InAll`, `Except`, `ExceptPatterns` and `Predicates`) are retained
+// along with the event, and checked against the socket when the event is replayed; the `Filter` function is not.
+//
+// Param: opts - the options of the broadcast
+//
+// Param: data - the event name followed by its arguments
+func (r *RoomHistory) Append(opts *BroadcastOptions, data []any) {
+	if opts == nil || r.rooms.Len() == 0 {
+		return
+	}
+
+	rooms := types.NewSet(roomKeys(opts.Rooms)...)
+	if len(opts.RoomPatterns) > 0 {
+		r.rooms.Range(func(room Room, _ *RoomHistoryOptions) bool {
+			if matchAnyRoomPattern(opts.RoomPatterns, room) {
+				rooms.Add(room)
+			}
+			return true
+		})
+	}
+	if rooms.Len() == 0 {
+		rooms.Add(roomKeys(opts.InAll)...)
+	}
+
+	now := time.Now().UnixMilli()
+	for _, room := range rooms.Keys() {
+		if (opts.Except != nil && opts.Except.Has(room)) || matchAnyRoomPattern(opts.ExceptPatterns, room) {
+			continue
+		}
+		roomOpts, ok := r.rooms.Load(room)
+		if !ok {
+			continue
+		}
+		entry := &RoomHistoryEntry{
+			Id:             utils.YeastDate(),
+			Room:           room,
+			EmittedAt:      now,
+			Data:           data,
+			InAll:          roomKeys(opts.InAll),
+			Except:         roomKeys(opts.Except),
+			ExceptPatterns: opts.ExceptPatterns,
+			Predicates:     opts.Predicates,
+		}
+		if err := r.Store().Append(room, entry, roomOpts); err != nil {
+			room_history_log.Debug("error while appending to the history of room %s: %v", room, err)
+		}
+	}
+}
+
+// Whether the event would have been broadcast to the socket, based on the conditions retained along with it.
+func (e *RoomHistoryEntry) Matches(socket SocketDetails) bool {
+	rooms := socket.Rooms()
+	if !hasAllRooms(rooms, e.InAll) {
+		return false
+	}
+	for _, room := range rooms.Keys() {
+		if slices.Contains(e.Except, room) || matchAnyRoomPattern(e.ExceptPatterns, room) {
+			return false
+		}
+	}
+	for _, predicate := range e.Predicates {
+		if !predicate.Match(socket) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the retained events of a room emitted after the given time, in order.
+//
+// Param: room - the room
+//
+// Param: since - only the events emitted after this time are returned (the zero time returns all events)
+func (r *RoomHistory) Range(room Room, since time.Time) ([]*RoomHistoryEntry, error) {
+	opts, ok := r.rooms.Load(room)
+	if !ok {
+		return nil, nil
+	}
+	after := int64(0)
+	if !since.IsZero() {
+		after = since.UnixMilli()
+	}
+	if opts.MaxAge > 0 {
+		after = max(after, time.Now().Add(-opts.MaxAge).UnixMilli()-1)
+	}
+	return r.Store().Range(room, after)
+}
@@ -0,0 +1,52 @@
+package socket
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zishang520/engine.io/v2/types"
+)
+
+func TestRoomHistoryAppendTargeting(t *testing.T) {
+	history := NewRoomHistory()
+	history.Enable("doc:1", nil)
+	history.Enable("doc:2", nil)
+	history.Enable("vip", nil)
+
+	history.Append(&BroadcastOptions{RoomPatterns: []string{"doc:*"}, ExceptPatterns: []string{"doc:2"}}, []any{"pattern"})
+	history.Append(&BroadcastOptions{InAll: types.NewSet[Room]("doc:1", "vip")}, []any{"in-all"})
+	history.Append(&BroadcastOptions{Rooms: types.NewSet[Room]("doc:1"), Except: types.NewSet[Room]("muted")}, []any{"except"})
+
+	events := func(room Room, rooms ...Room) (events []any) {
+		entries, err := history.Range(room, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		socket := &persistedSocketDetails{&SessionToPersist{Sid: "a", Rooms: types.NewSet(rooms...)}}
+		for _, entry := range entries {
+			if entry.Matches(socket) {
+				events = append(events, entry.Data[0])
+			}
+		}
+		return events
+	}
+
+	tests := []struct {
+		room     Room
+		rooms    []Room
+		expected []any
+	}{
+		{"doc:1", []Room{"doc:1"}, []any{"pattern", "except"}},
+		{"doc:1", []Room{"doc:1", "vip"}, []any{"pattern", "in-all", "except"}},
+		{"doc:1", []Room{"doc:1", "muted"}, []any{"pattern"}},
+		{"doc:2", []Room{"doc:2"}, nil},
+		{"vip", []Room{"vip", "doc:1"}, []any{"in-all"}},
+		{"vip", []Room{"vip"}, nil},
+	}
+	for _, test := range tests {
+		if actual := events(test.room, test.rooms...); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("room %s with rooms %v: expected %v, got %v", test.room, test.rooms, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package socket
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/zishang520/engine.io/v2/types"
+)
+
+const roomIndexShards = 64
+
+type (
+	// A member of a room. The reference to the [Socket] is resolved upon the first broadcast, since a socket may join
+	// rooms (in a middleware for example) before being tracked by its namespace.
+	roomMember struct {
+		id     SocketId
+		socket atomic.Pointer[Socket]
+	}
+
+	roomIndexShard struct {
+		mu    sync.RWMutex
+		rooms map[Room]map[SocketId]*roomMember
+	}
+
+	// The in-memory room index of an adapter. Rooms are spread over several shards, so that joining or leaving a room
+	// only locks a fraction of the index, and broadcasts only hold a read lock while collecting the members.
+	roomIndex struct {
+		shards [roomIndexShards]roomIndexShard
+		// the prefix index over the room names, updated along with the shards
+		trie *roomTrie
+		// reusable buffers for the members collected by a broadcast
+		buffers sync.Pool
+	}
+)
+
+func newRoomIndex() *roomIndex {
+	x := &roomIndex{trie: newRoomTrie()}
+	for i := range x.shards {
+		x.shards[i].rooms = map[Room]map[SocketId]*roomMember{}
+	}
+	x.buffers.New = func() any {
+		buffer := make([]*Socket, 0, 64)
+		return &buffer
+	}
+	return x
+}
+
+func (x *roomIndex) shard(room Room) *roomIndexShard {
+	// FNV-1a
+	hash := uint32(2166136261)
+	for i := 0; i < len(room); i++ {
+		hash ^= uint32(room[i])
+		hash *= 16777619
+	}
+	return &x.shards[hash%roomIndexShards]
+}
+
+// Adds a socket to a room, returns whether the room was created and whether the socket was added.
+func (x *roomIndex) Add(room Room, id SocketId) (created bool, added bool) {
+	created, added, _ = x.AddBounded(room, id, 0)
+	return created, added
+}
+
+// Adds a socket to a room unless the room already has `max` members (0 means no limit), returns whether the room was
+// created, whether the socket was added and whether the room was full. The capacity is checked under the lock of the
+// shard, so that concurrent additions cannot exceed it.
+func (x *roomIndex) AddBounded(room Room, id SocketId, max int) (created bool, added bool, full bool) {
+	shard := x.shard(room)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	members, ok := shard.rooms[room]
+	if _, ok := members[id]; ok {
+		return false, false, false
+	}
+	if max > 0 && len(members) >= max {
+		return false, false, true
+	}
+	if !ok {
+		members = map[SocketId]*roomMember{}
+		shard.rooms[room] = members
+		x.trie.Insert(room)
+		created = true
+	}
+	members[id] = &roomMember{id: id}
+	return created, true, false
+}
+
+// Removes a socket from a room, returns whether the socket was removed and whether the room was deleted (because it
+// no longer has any member).
+func (x *roomIndex) Delete(room Room, id SocketId) (removed bool, deleted bool) {
+	shard := x.shard(room)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	members, ok := shard.rooms[room]
+	if !ok {
+		return false, false
+	}
+	if _, ok := members[id]; ok {
+		delete(members, id)
+		removed = true
+	}
+	if len(members) == 0 {
+		delete(shard.rooms, room)
+		x.trie.Delete(room)
+		deleted = true
+	}
+	return removed, deleted
+}
+
+func (x *roomIndex) Has(room Room, id SocketId) bool {
+	shard := x.shard(room)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	_, ok := shard.rooms[room][id]
+	return ok
+}
+
+// Returns the number of members of a room, and whether the room exists.
+func (x *roomIndex) Len(room Room) (int, bool) {
+	shard := x.shard(room)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	members, ok := shard.rooms[room]
+	return len(members), ok
+}
+
+// Returns a copy of the index.
+func (x *roomIndex) Snapshot() *types.Map[Room, *types.Set[SocketId]] {
+	snapshot := &types.Map[Room, *types.Set[SocketId]]{}
+	for i := range x.shards {
+		shard := &x.shards[i]
+		shard.mu.RLock()
+		for room, members := range shard.rooms {
+			ids := types.NewSet[SocketId]()
+			for id := range members {
+				ids.Add(id)
+			}
+			snapshot.Store(room, ids)
+		}
+		shard.mu.RUnlock()
+	}
+	return snapshot
+}
+
+// Returns the rooms matching the given pattern.
+func (x *roomIndex) Match(pattern string) []Room {
+	return x.trie.Match(pattern)
+}
+
+// Appends the members of a room to the buffer. The `accept` function is called with the shard read lock held, so it
+// must not modify the index.
+func (x *roomIndex) Collect(room Room, buffer []*Socket, resolve func(*roomMember) *Socket, accept func(*Socket) bool) []*Socket {
+	shard := x.shard(room)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	for _, member := range shard.rooms[room] {
+		if socket := resolve(member); socket != nil && (accept == nil || accept(socket)) {
+			buffer = append(buffer, socket)
+		}
+	}
+	return buffer
+}
+
+func (x *roomIndex) getBuffer() *[]*Socket {
+	return x.buffers.Get().(*[]*Socket)
+}
+
+func (x *roomIndex) putBuffer(buffer *[]*Socket) {
+	clear(*buffer)
+	*buffer = (*buffer)[:0]
+	x.buffers.Put(buffer)
+}
@@ -0,0 +1,145 @@
+package socket
+
+import (
+	"strings"
+	"sync"
+)
+
+type (
+	roomTrieNode struct {
+		children map[byte]*roomTrieNode
+		// whether a room ends at this node
+		room bool
+	}
+
+	// A prefix index over the room names, so that the rooms matching a pattern can be found without scanning every
+	// room of the namespace.
+	roomTrie struct {
+		mu   sync.RWMutex
+		root *roomTrieNode
+	}
+)
+
+func newRoomTrie() *roomTrie {
+	return &roomTrie{root: &roomTrieNode{}}
+}
+
+func (t *roomTrie) Insert(room Room) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node := t.root
+	for i := 0; i < len(room); i++ {
+		if node.children == nil {
+			node.children = map[byte]*roomTrieNode{}
+		}
+		child, ok := node.children[room[i]]
+		if !ok {
+			child = &roomTrieNode{}
+			node.children[room[i]] = child
+		}
+		node = child
+	}
+	node.room = true
+}
+
+func (t *roomTrie) Delete(room Room) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// keep track of the path, in order to prune the branches which no longer lead to a room
+	path := make([]*roomTrieNode, 0, len(room)+1)
+	node := t.root
+	path = append(path, node)
+	for i := 0; i < len(room); i++ {
+		child, ok := node.children[room[i]]
+		if !ok {
+			return
+		}
+		node = child
+		path = append(path, node)
+	}
+	node.room = false
+
+	for i := len(room); i > 0; i-- {
+		if n := path[i]; n.room || len(n.children) > 0 {
+			break
+		}
+		delete(path[i-1].children, room[i-1])
+	}
+}
+
+// Returns the rooms matching the given pattern.
+func (t *roomTrie) Match(pattern string) []Room {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	prefix := roomPatternPrefix(pattern)
+	node := t.root
+	for i := 0; i < len(prefix); i++ {
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			return nil
+		}
+		node = child
+	}
+
+	// "prefix*" matches the whole subtree, there is no need to check each room
+	prefixOnly := pattern == prefix+"*"
+	rooms := []Room{}
+	var walk func(*roomTrieNode, []byte)
+	walk = func(node *roomTrieNode, name []byte) {
+		if node.room && (prefixOnly || matchRoomPattern(pattern, Room(name))) {
+			rooms = append(rooms, Room(name))
+		}
+		for c, child := range node.children {
+			walk(child, append(name, c))
+		}
+	}
+	walk(node, []byte(prefix))
+	return rooms
+}
+
+// Returns the literal part of the pattern, before the first wildcard.
+func roomPatternPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// Whether the given room matches the pattern, where `*` matches any sequence of characters and `?` matches a single
+// character.
+func matchRoomPattern(pattern string, room Room) bool {
+	p, r := 0, 0
+	star, mark := -1, 0
+	for r < len(room) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == room[r]):
+			p++
+			r++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, r
+			p++
+		case star >= 0:
+			p = star + 1
+			mark++
+			r = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func matchAnyRoomPattern(patterns []string, room Room) bool {
+	for _, pattern := range patterns {
+		if matchRoomPattern(pattern, room) {
+			return true
+		}
+	}
+	return false
+}
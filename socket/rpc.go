@@ -0,0 +1,432 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/engine.io/v2/types"
+)
+
+var rpc_log = log.NewLog("socket.io:rpc")
+
+// The codes of the errors raised by the RPC layer itself, the procedures are free to use any other code.
+const (
+	RpcCodeInvalidParams   = -32602
+	RpcCodeInternal        = -32603
+	RpcCodeTimeout         = -32000
+	RpcCodeDisconnected    = -32001
+	RpcCodeInvalidResponse = -32002
+)
+
+type (
+	// A structured error, sent as the first argument of the acknowledgement.
+	//
+	//	{ "code": -32602, "message": "invalid params", "data": null }
+	RpcError struct {
+		Code    int    `json:"code" mapstructure:"code" msgpack:"code"`
+		Message string `json:"message" mapstructure:"message" msgpack:"message"`
+		Data    any    `json:"data,omitempty" mapstructure:"data,omitempty" msgpack:"data,omitempty"`
+	}
+
+	// A call, either received from a client (a procedure) or sent to a client (see [Socket.Call]).
+	RpcCall struct {
+		Socket *Socket
+		Method string
+		Params any
+		// Whether the call is sent to the client
+		Outbound bool
+		// The attempt number, starting at 1 (see [RpcRetryPolicy])
+		Attempt int
+	}
+
+	// Handles a call, the result is sent as the second argument of the acknowledgement.
+	RpcHandler func(context.Context, *RpcCall) (any, error)
+
+	// Wraps the handling of the calls, and must call `next` to proceed.
+	RpcMiddleware func(ctx context.Context, call *RpcCall, next RpcHandler) (any, error)
+
+	// How the idempotent calls sent to the clients are retried upon timeout.
+	RpcRetryPolicy struct {
+		// The maximum number of attempts, including the first one
+		Attempts int
+		// The delay before the second attempt, which is multiplied by the attempt number for the next ones
+		Backoff time.Duration
+		// The timeout of each attempt (0 means that the first attempt lasts until the deadline of the context, which
+		// leaves no time for the next ones)
+		Timeout time.Duration
+	}
+
+	// The metrics of a method, see [Rpc.Metrics].
+	RpcMethodMetrics struct {
+		Calls         uint64        `json:"calls" mapstructure:"calls" msgpack:"calls"`
+		Errors        uint64        `json:"errors" mapstructure:"errors" msgpack:"errors"`
+		Timeouts      uint64        `json:"timeouts" mapstructure:"timeouts" msgpack:"timeouts"`
+		Retries       uint64        `json:"retries" mapstructure:"retries" msgpack:"retries"`
+		TotalDuration time.Duration `json:"totalDuration" mapstructure:"totalDuration" msgpack:"totalDuration"`
+		MaxDuration   time.Duration `json:"maxDuration" mapstructure:"maxDuration" msgpack:"maxDuration"`
+	}
+
+	rpcResponse struct {
+		args []any
+		err  error
+	}
+
+	rpcMetrics struct {
+		calls         atomic.Uint64
+		errors        atomic.Uint64
+		timeouts      atomic.Uint64
+		retries       atomic.Uint64
+		totalDuration atomic.Int64
+		maxDuration   atomic.Int64
+	}
+
+	// The RPC layer of a namespace, built on top of the acknowledgements.
+	//
+	// The clients call a procedure by emitting an event named after the method, with the params as the first argument
+	// and an acknowledgement, which is called with `(error, result)`:
+	//
+	//	socket.RegisterProcedure(io.Of("/", nil).Rpc(), "user:get", func(ctx context.Context, client *socket.Socket, req *GetUserRequest) (*User, error) {
+	//		user, ok := users[req.Id]
+	//		if !ok {
+	//			return nil, &socket.RpcError{Code: 404, Message: "user not found"}
+	//		}
+	//		return user, nil
+	//	})
+	//
+	//	// client side
+	//	const [err, user] = await socket.emitWithAck("user:get", { id: 1 });
+	//
+	// Each call is handled on its own goroutine, with a context which is cancelled once the socket disconnects.
+	//
+	// The server calls the clients with [Socket.Call], the clients answering in the same way.
+	Rpc struct {
+		parent      *Rpc
+		procedures  *types.Map[string, RpcHandler]
+		retries     *types.Map[string, *RpcRetryPolicy]
+		middlewares *types.Slice[RpcMiddleware]
+		metrics     *types.Map[string, *rpcMetrics]
+	}
+)
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func NewRpc() *Rpc {
+	return &Rpc{
+		procedures:  &types.Map[string, RpcHandler]{},
+		retries:     &types.Map[string, *RpcRetryPolicy]{},
+		middlewares: types.NewSlice[RpcMiddleware](),
+		metrics:     &types.Map[string, *rpcMetrics]{},
+	}
+}
+
+// Registers a procedure with typed request and response, the params of the call are decoded into the request.
+//
+// Param: rpc - the RPC layer of a namespace
+//
+// Param: method - the name of the procedure, which is the name of the event emitted by the clients
+//
+// Param: handler - the procedure, an [RpcError] is sent as is, any other error is sent with the [RpcCodeInternal] code
+func RegisterProcedure[Req any, Resp any](rpc *Rpc, method string, handler func(context.Context, *Socket, *Req) (*Resp, error)) {
+	rpc.Handle(method, func(ctx context.Context, call *RpcCall) (any, error) {
+		req := new(Req)
+		if call.Params != nil {
+			if err := mapstructure.Decode(call.Params, req); err != nil {
+				return nil, &RpcError{Code: RpcCodeInvalidParams, Message: err.Error()}
+			}
+		}
+		return handler(ctx, call.Socket, req)
+	})
+}
+
+// Registers an untyped procedure, see [RegisterProcedure].
+func (r *Rpc) Handle(method string, handler RpcHandler) *Rpc {
+	r.procedures.Store(method, handler)
+	return r
+}
+
+// Adds a middleware, which wraps the procedures and the calls sent to the clients.
+//
+//	nsp.Rpc().Use(func(ctx context.Context, call *socket.RpcCall, next socket.RpcHandler) (any, error) {
+//		if !call.Outbound && call.Socket.Data() == nil {
+//			return nil, &socket.RpcError{Code: 401, Message: "unauthorized"}
+//		}
+//		return next(ctx, call)
+//	})
+func (r *Rpc) Use(fn RpcMiddleware) *Rpc {
+	r.middlewares.Push(fn)
+	return r
+}
+
+// Marks a method as idempotent, so that the calls sent to the clients are retried upon timeout.
+//
+// Param: policy - the retry policy, or nil to disable retries
+func (r *Rpc) SetRetryPolicy(method string, policy *RpcRetryPolicy) *Rpc {
+	if policy == nil {
+		r.retries.Delete(method)
+	} else {
+		r.retries.Store(method, policy)
+	}
+	return r
+}
+
+// Makes the procedures, middlewares and retry policies of the given RPC layer available, used by the child namespaces
+// of a [ParentNamespace].
+func (r *Rpc) Inherit(parent *Rpc) *Rpc {
+	r.parent = parent
+	return r
+}
+
+// Returns the metrics of each method, for the procedures and the calls sent to the clients.
+func (r *Rpc) Metrics() map[string]*RpcMethodMetrics {
+	metrics := map[string]*RpcMethodMetrics{}
+	r.metrics.Range(func(method string, m *rpcMetrics) bool {
+		metrics[method] = &RpcMethodMetrics{
+			Calls:         m.calls.Load(),
+			Errors:        m.errors.Load(),
+			Timeouts:      m.timeouts.Load(),
+			Retries:       m.retries.Load(),
+			TotalDuration: time.Duration(m.totalDuration.Load()),
+			MaxDuration:   time.Duration(m.maxDuration.Load()),
+		}
+		return true
+	})
+	return metrics
+}
+
+func (r *Rpc) procedure(method string) (RpcHandler, bool) {
+	if handler, ok := r.procedures.Load(method); ok {
+		return handler, true
+	}
+	if r.parent != nil {
+		return r.parent.procedure(method)
+	}
+	return nil, false
+}
+
+func (r *Rpc) retryPolicy(method string) *RpcRetryPolicy {
+	if policy, ok := r.retries.Load(method); ok {
+		return policy
+	}
+	if r.parent != nil {
+		return r.parent.retryPolicy(method)
+	}
+	return nil
+}
+
+// The middlewares of the parent come first.
+func (r *Rpc) allMiddlewares() []RpcMiddleware {
+	if r.parent == nil {
+		return r.middlewares.All()
+	}
+	return append(r.parent.allMiddlewares(), r.middlewares.All()...)
+}
+
+func (r *Rpc) methodMetrics(method string) *rpcMetrics {
+	m, _ := r.metrics.LoadOrStore(method, &rpcMetrics{})
+	return m
+}
+
+func (m *rpcMetrics) record(duration time.Duration, err error) {
+	m.calls.Add(1)
+	m.totalDuration.Add(int64(duration))
+	for max := m.maxDuration.Load(); int64(duration) > max; max = m.maxDuration.Load() {
+		if m.maxDuration.CompareAndSwap(max, int64(duration)) {
+			break
+		}
+	}
+	if err != nil {
+		m.errors.Add(1)
+		if rpcErr := toRpcError(err); rpcErr.Code == RpcCodeTimeout {
+			m.timeouts.Add(1)
+		}
+	}
+}
+
+// Runs the middlewares and the handler, and records the metrics of the call.
+func (r *Rpc) invoke(ctx context.Context, call *RpcCall, handler RpcHandler) (any, error) {
+	fns := r.allMiddlewares()
+	next := handler
+	for i := len(fns) - 1; i >= 0; i-- {
+		fn, h := fns[i], next
+		next = func(ctx context.Context, call *RpcCall) (any, error) {
+			return fn(ctx, call, h)
+		}
+	}
+	start := time.Now()
+	result, err := next(ctx, call)
+	r.methodMetrics(call.Method).record(time.Since(start), err)
+	return result, err
+}
+
+// Handles an incoming event if it matches a procedure, returns whether it did.
+//
+// The procedure is called on the goroutine of the event (see [Socket.dispatch]), so a long-running procedure does not
+// block the other events of the socket, and its context is cancelled once the socket disconnects.
+func (r *Rpc) handle(socket *Socket, event []any) bool {
+	method, ok := event[0].(string)
+	if !ok {
+		return false
+	}
+	handler, ok := r.procedure(method)
+	if !ok {
+		return false
+	}
+
+	args := event[1:]
+	var ack func([]any, error)
+	if l := len(args); l > 0 {
+		if fn, ok := args[l-1].(func([]any, error)); ok {
+			ack = fn
+			args = args[:l-1]
+		}
+	}
+	call := &RpcCall{Socket: socket, Method: method, Attempt: 1}
+	if len(args) > 0 {
+		call.Params = args[0]
+	}
+
+	rpc_log.Debug("calling procedure %s", method)
+	defer func() {
+		if value := recover(); value != nil {
+			// the client is answered before the panic is reported by the socket
+			if ack != nil {
+				ack([]any{&RpcError{Code: RpcCodeInternal, Message: "internal error"}}, nil)
+			}
+			panic(value)
+		}
+	}()
+	ctx, cancel := socket.lifetimeContext()
+	defer cancel()
+	result, err := r.invoke(ctx, call, handler)
+	if ack == nil {
+		// a notification, the result is discarded
+		return true
+	}
+	if err != nil {
+		ack([]any{toRpcError(err)}, nil)
+	} else {
+		ack([]any{nil, result}, nil)
+	}
+	return true
+}
+
+// Calls a method of a client, see [Socket.Call].
+func (r *Rpc) Call(ctx context.Context, socket *Socket, method string, req any, resp any) error {
+	attempts := 1
+	var backoff, timeout time.Duration
+	if policy := r.retryPolicy(method); policy != nil && policy.Attempts > 1 {
+		attempts, backoff, timeout = policy.Attempts, policy.Backoff, policy.Timeout
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := r.attempt(ctx, timeout, &RpcCall{
+			Socket:   socket,
+			Method:   method,
+			Params:   req,
+			Outbound: true,
+			Attempt:  attempt,
+		})
+		if err == nil {
+			if resp != nil && result != nil {
+				if err := mapstructure.Decode(result, resp); err != nil {
+					return &RpcError{Code: RpcCodeInvalidResponse, Message: err.Error()}
+				}
+			}
+			return nil
+		}
+		if attempt >= attempts || toRpcError(err).Code != RpcCodeTimeout || ctx.Err() != nil {
+			return err
+		}
+		rpc_log.Debug("retrying call %s (attempt %d)", method, attempt+1)
+		r.methodMetrics(method).retries.Add(1)
+		select {
+		case <-ctx.Done():
+			return &RpcError{Code: RpcCodeTimeout, Message: ctx.Err().Error()}
+		case <-time.After(backoff * time.Duration(attempt)):
+		}
+	}
+}
+
+// Sends a call to the client, within the given timeout if any.
+func (r *Rpc) attempt(ctx context.Context, timeout time.Duration, call *RpcCall) (any, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return r.invoke(ctx, call, r.emit)
+}
+
+// Sends a call to the client, and waits for its acknowledgement or the end of the context.
+func (r *Rpc) emit(ctx context.Context, call *RpcCall) (any, error) {
+	socket := call.Socket
+	if !socket.Connected() {
+		return nil, &RpcError{Code: RpcCodeDisconnected, Message: "the socket is disconnected"}
+	}
+	if SOCKET_RESERVED_EVENTS.Has(call.Method) {
+		return nil, &RpcError{Code: RpcCodeInternal, Message: fmt.Sprintf(`"%s" is a reserved event name`, call.Method)}
+	}
+	// the flags of the socket are left untouched, as they are shared with the concurrent emits
+	flags := &BroadcastFlags{}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, &RpcError{Code: RpcCodeTimeout, Message: context.DeadlineExceeded.Error()}
+		}
+		// the acknowledgement callback is released once the deadline is reached
+		flags.Timeout = &timeout
+	}
+
+	responses := make(chan *rpcResponse, 1)
+	id := socket.emit(call.Method, []any{call.Params, func(args []any, err error) {
+		// the acknowledgement may race with its timeout
+		select {
+		case responses <- &rpcResponse{args, err}:
+		default:
+		}
+	}}, flags)
+
+	select {
+	case <-ctx.Done():
+		socket.acks.Delete(*id)
+		return nil, &RpcError{Code: RpcCodeTimeout, Message: ctx.Err().Error()}
+	case <-socket.closed:
+		socket.acks.Delete(*id)
+		return nil, &RpcError{Code: RpcCodeDisconnected, Message: "the socket is disconnected"}
+	case response := <-responses:
+		if response.err != nil {
+			return nil, &RpcError{Code: RpcCodeTimeout, Message: response.err.Error()}
+		}
+		return parseRpcResponse(response.args)
+	}
+}
+
+// Parses the `(error, result)` arguments of an acknowledgement.
+func parseRpcResponse(args []any) (any, error) {
+	if len(args) > 0 && args[0] != nil {
+		rpcErr := &RpcError{}
+		if err := mapstructure.Decode(args[0], rpcErr); err != nil || rpcErr.Message == "" {
+			rpcErr = &RpcError{Code: RpcCodeInternal, Message: fmt.Sprint(args[0])}
+		}
+		return nil, rpcErr
+	}
+	if len(args) > 1 {
+		return args[1], nil
+	}
+	return nil, nil
+}
+
+func toRpcError(err error) *RpcError {
+	var rpcErr *RpcError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	return &RpcError{Code: RpcCodeInternal, Message: err.Error()}
+}
@@ -0,0 +1,218 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+type (
+	rpcTestSumRequest struct {
+		A int `mapstructure:"a"`
+		B int `mapstructure:"b"`
+	}
+
+	rpcTestSumResponse struct {
+		Sum int `mapstructure:"sum"`
+	}
+)
+
+// Calls a procedure as a client would, and returns the arguments of the acknowledgement.
+func callTestProcedure(t *testing.T, socket *Socket, method string, params any) []any {
+	t.Helper()
+
+	response := make(chan []any, 1)
+	socket.dispatch([]any{method, params, func(args []any, _ error) {
+		response <- args
+	}})
+	select {
+	case args := <-response:
+		return args
+	case <-time.After(time.Second):
+		t.Fatalf("expected a response to %s", method)
+	}
+	return nil
+}
+
+// Returns the ids of the calls written to the connection.
+func rpcTestCallIds(conn *writeTestConn, method string) []uint64 {
+	ids := []uint64{}
+	for _, write := range conn.Writes() {
+		if i := strings.Index(write, `["`+method+`"`); i > 1 && write[0] == '2' {
+			if id, err := strconv.ParseUint(write[1:i], 10, 64); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func TestRpcTypedProcedure(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	socket, _ := newWriteTestSocket(nsp, "a")
+	RegisterProcedure(nsp.Rpc(), "sum", func(_ context.Context, _ *Socket, req *rpcTestSumRequest) (*rpcTestSumResponse, error) {
+		return &rpcTestSumResponse{Sum: req.A + req.B}, nil
+	})
+
+	args := callTestProcedure(t, socket, "sum", map[string]any{"a": 1, "b": 2})
+	if len(args) != 2 || args[0] != nil {
+		t.Fatalf("unexpected response %v", args)
+	}
+	if resp, ok := args[1].(*rpcTestSumResponse); !ok || resp.Sum != 3 {
+		t.Fatalf("unexpected result %v", args[1])
+	}
+
+	args = callTestProcedure(t, socket, "sum", "not an object")
+	if rpcErr, ok := args[0].(*RpcError); !ok || rpcErr.Code != RpcCodeInvalidParams {
+		t.Fatalf("expected an invalid params error, got %v", args)
+	}
+}
+
+func TestRpcErrors(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	socket, _ := newWriteTestSocket(nsp, "a")
+	nsp.Rpc().Handle("get", func(context.Context, *RpcCall) (any, error) {
+		return nil, &RpcError{Code: 404, Message: "not found", Data: "user"}
+	})
+	nsp.Rpc().Handle("fail", func(context.Context, *RpcCall) (any, error) {
+		return nil, errors.New("boom")
+	})
+
+	args := callTestProcedure(t, socket, "get", nil)
+	if rpcErr, ok := args[0].(*RpcError); !ok || rpcErr.Code != 404 || rpcErr.Message != "not found" || rpcErr.Data != "user" {
+		t.Fatalf("expected the error of the procedure, got %v", args)
+	}
+	args = callTestProcedure(t, socket, "fail", nil)
+	if rpcErr, ok := args[0].(*RpcError); !ok || rpcErr.Code != RpcCodeInternal || rpcErr.Message != "boom" {
+		t.Fatalf("expected an internal error, got %v", args)
+	}
+
+	// the errors sent by the clients
+	if _, err := parseRpcResponse([]any{map[string]any{"code": 403, "message": "forbidden"}}); toRpcError(err).Code != 403 {
+		t.Fatalf("expected the error of the client, got %v", err)
+	}
+	if _, err := parseRpcResponse([]any{"oops"}); toRpcError(err).Code != RpcCodeInternal {
+		t.Fatalf("expected an internal error, got %v", err)
+	}
+	if result, err := parseRpcResponse([]any{nil, 42}); err != nil || result != 42 {
+		t.Fatalf("unexpected response %v %v", result, err)
+	}
+}
+
+func TestRpcCallRetry(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	socket, conn := newWriteTestSocket(nsp, "a")
+	nsp.Rpc().SetRetryPolicy("ping", &RpcRetryPolicy{Attempts: 3, Backoff: time.Millisecond, Timeout: 20 * time.Millisecond})
+
+	// the client never answers
+	err := socket.Call(context.Background(), "ping", nil, nil)
+	if toRpcError(err).Code != RpcCodeTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if ids := rpcTestCallIds(conn, "ping"); len(ids) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(ids))
+	}
+	metrics := nsp.Rpc().Metrics()["ping"]
+	if metrics.Calls != 3 || metrics.Timeouts != 3 || metrics.Retries != 2 {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+
+	// the client answers the second attempt
+	done := make(chan error, 1)
+	var resp rpcTestSumResponse
+	go func() {
+		done <- socket.Call(context.Background(), "ping", map[string]any{"a": 1}, &resp)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for len(rpcTestCallIds(conn, "ping")) < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	ids := rpcTestCallIds(conn, "ping")
+	if len(ids) != 5 {
+		t.Fatalf("expected a second attempt, got %d calls", len(ids))
+	}
+	socket.onack(&parser.Packet{Type: parser.ACK, Id: &ids[4], Data: []any{nil, map[string]any{"sum": 2}}})
+	if err := <-done; err != nil || resp.Sum != 2 {
+		t.Fatalf("unexpected response %+v %v", resp, err)
+	}
+}
+
+func TestRpcDisconnect(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	socket, _ := newWriteTestSocket(nsp, "a")
+
+	cancelled := make(chan struct{})
+	nsp.Rpc().Handle("wait", func(ctx context.Context, _ *RpcCall) (any, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	socket.dispatch([]any{"wait", nil, func([]any, error) {}})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- socket.Call(context.Background(), "ping", nil, nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	socket.Client().onclose("transport close")
+
+	select {
+	case err := <-done:
+		if toRpcError(err).Code != RpcCodeDisconnected {
+			t.Fatalf("expected a disconnection error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the call to fail upon disconnection")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the context of the procedure to be cancelled upon disconnection")
+	}
+	if err := socket.Call(context.Background(), "ping", nil, nil); toRpcError(err).Code != RpcCodeDisconnected {
+		t.Fatalf("expected a disconnection error, got %v", err)
+	}
+}
+
+func TestRpcMiddlewareAndMetrics(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+	socket, _ := newWriteTestSocket(nsp, "a")
+
+	var calls atomic.Int32
+	nsp.Rpc().Use(func(ctx context.Context, call *RpcCall, next RpcHandler) (any, error) {
+		calls.Add(1)
+		if call.Params == "forbidden" {
+			return nil, &RpcError{Code: 401, Message: "unauthorized"}
+		}
+		return next(ctx, call)
+	})
+	nsp.Rpc().Handle("echo", func(_ context.Context, call *RpcCall) (any, error) {
+		return call.Params, nil
+	})
+
+	if args := callTestProcedure(t, socket, "echo", "hello"); args[1] != "hello" {
+		t.Fatalf("unexpected response %v", args)
+	}
+	if args := callTestProcedure(t, socket, "echo", "forbidden"); toRpcError(args[0].(*RpcError)).Code != 401 {
+		t.Fatalf("expected the error of the middleware, got %v", args)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected the middleware to be called twice, got %d", calls.Load())
+	}
+	metrics := nsp.Rpc().Metrics()["echo"]
+	if metrics.Calls != 2 || metrics.Errors != 1 || metrics.Timeouts != 0 || metrics.MaxDuration > metrics.TotalDuration {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+
+	// the child namespaces inherit the procedures and the middlewares
+	child := NewRpc().Inherit(nsp.Rpc())
+	if _, ok := child.procedure("echo"); !ok || len(child.allMiddlewares()) != 1 {
+		t.Fatal("expected the procedure and the middleware to be inherited")
+	}
+}
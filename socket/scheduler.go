@@ -0,0 +1,337 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/engine.io/v2/types"
+	"github.com/zishang520/engine.io/v2/utils"
+)
+
+var scheduler_log = log.NewLog("socket.io:scheduler")
+
+type (
+	// An event scheduled with [BroadcastOperator.EmitAt] or [BroadcastOperator.EmitAfter].
+	ScheduledEmit struct {
+		Id string `json:"id" mapstructure:"id" msgpack:"id"`
+		// The name of the namespace
+		Nsp string `json:"nsp" mapstructure:"nsp" msgpack:"nsp"`
+		// The time of the emission (unix milliseconds)
+		At int64 `json:"at" mapstructure:"at" msgpack:"at"`
+		// The targeted sockets
+		Opts *ScheduledEmitOptions `json:"opts" mapstructure:"opts" msgpack:"opts"`
+		// The event name followed by its arguments
+		Data []any `json:"data" mapstructure:"data" msgpack:"data"`
+	}
+
+	// The targeted sockets of a [ScheduledEmit], in a serializable form (the `Filter` function of the
+	// [BroadcastOptions] is not persisted).
+	ScheduledEmitOptions struct {
+		Rooms          []Room             `json:"rooms,omitempty" mapstructure:"rooms,omitempty" msgpack:"rooms,omitempty"`
+		Except         []Room             `json:"except,omitempty" mapstructure:"except,omitempty" msgpack:"except,omitempty"`
+		InAll          []Room             `json:"inAll,omitempty" mapstructure:"inAll,omitempty" msgpack:"inAll,omitempty"`
+		RoomPatterns   []string           `json:"roomPatterns,omitempty" mapstructure:"roomPatterns,omitempty" msgpack:"roomPatterns,omitempty"`
+		ExceptPatterns []string           `json:"exceptPatterns,omitempty" mapstructure:"exceptPatterns,omitempty" msgpack:"exceptPatterns,omitempty"`
+		Predicates     []*SocketPredicate `json:"predicates,omitempty" mapstructure:"predicates,omitempty" msgpack:"predicates,omitempty"`
+		Flags          *BroadcastFlags    `json:"flags,omitempty" mapstructure:"flags,omitempty" msgpack:"flags,omitempty"`
+	}
+
+	// Persists the scheduled events, so that they are emitted even if the server restarts in the meantime. The
+	// pending events of the store are scheduled again by [Scheduler.Restore].
+	ScheduledEmitStore interface {
+		Save(*ScheduledEmit) error
+
+		Delete(string) error
+
+		// Returns every pending event.
+		Load() ([]*ScheduledEmit, error)
+	}
+
+	// The handle of a scheduled event.
+	ScheduledEmitHandle struct {
+		scheduler *Scheduler
+		emit      *ScheduledEmit
+	}
+
+	scheduledEntry struct {
+		emit *ScheduledEmit
+		// nil for the restored events, whose namespace is resolved upon emission
+		operator *BroadcastOperator
+		timer    *wheelTimer
+	}
+
+	// Emits the events scheduled with [BroadcastOperator.EmitAt] and [BroadcastOperator.EmitAfter], see
+	// [ScheduledEmits] for the options.
+	Scheduler struct {
+		server  *Server
+		opts    *ScheduledEmits
+		wheel   *timerWheel
+		entries *types.Map[string, *scheduledEntry]
+
+		mu     sync.RWMutex
+		closed bool
+	}
+)
+
+// Returns the serializable form of the options of a broadcast.
+func NewScheduledEmitOptions(opts *BroadcastOptions) *ScheduledEmitOptions {
+	if opts == nil {
+		return &ScheduledEmitOptions{}
+	}
+	return &ScheduledEmitOptions{
+		Rooms:          roomKeys(opts.Rooms),
+		Except:         roomKeys(opts.Except),
+		InAll:          roomKeys(opts.InAll),
+		RoomPatterns:   opts.RoomPatterns,
+		ExceptPatterns: opts.ExceptPatterns,
+		Predicates:     opts.Predicates,
+		Flags:          opts.Flags,
+	}
+}
+
+// Returns the options of the broadcast.
+func (o *ScheduledEmitOptions) BroadcastOptions() *BroadcastOptions {
+	if o == nil {
+		return &BroadcastOptions{Rooms: types.NewSet[Room](), Except: types.NewSet[Room]()}
+	}
+	opts := &BroadcastOptions{
+		Rooms:          types.NewSet(o.Rooms...),
+		Except:         types.NewSet(o.Except...),
+		RoomPatterns:   o.RoomPatterns,
+		ExceptPatterns: o.ExceptPatterns,
+		Predicates:     o.Predicates,
+		Flags:          o.Flags,
+	}
+	if len(o.InAll) > 0 {
+		opts.InAll = types.NewSet(o.InAll...)
+	}
+	return opts
+}
+
+func MakeScheduler() *Scheduler {
+	s := &Scheduler{
+		entries: &types.Map[string, *scheduledEntry]{},
+	}
+
+	return s
+}
+
+func NewScheduler(server *Server, opts *ScheduledEmits) *Scheduler {
+	s := MakeScheduler()
+
+	s.Construct(server, opts)
+
+	return s
+}
+
+func (s *Scheduler) Construct(server *Server, opts *ScheduledEmits) {
+	if opts == nil {
+		opts = &ScheduledEmits{}
+	}
+	s.server = server
+	s.opts = opts
+	s.wheel = newTimerWheel(opts.Tick(), opts.Slots())
+}
+
+// Schedules again the pending events of the store, if any. It must be called once the namespaces are registered,
+// since the events are emitted to the namespaces which exist at the time of the emission (the events of the other
+// namespaces are dropped, the namespaces are not created):
+//
+//	io := socket.NewServer(nil, opts)
+//	io.Of("/chat", nil).On("connection", onconnection)
+//
+//	if err := io.Scheduler().Restore(); err != nil {
+//		// some events could not be restored
+//	}
+//
+// An event which cannot be scheduled does not prevent the other ones from being restored, the errors are joined with
+// [errors.Join].
+func (s *Scheduler) Restore() error {
+	store := s.opts.Store()
+	if store == nil {
+		return nil
+	}
+	emits, err := store.Load()
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	restored := 0
+	for _, emit := range emits {
+		if emit == nil || emit.Id == "" {
+			errs = append(errs, errors.New("invalid scheduled event"))
+			continue
+		}
+		if _, ok := s.entries.Load(emit.Id); ok {
+			// already restored
+			continue
+		}
+		// the targeted sockets are resolved upon emission
+		if err := s.schedule(emit, nil, false); err != nil {
+			errs = append(errs, fmt.Errorf("scheduled event %s: %w", emit.Id, err))
+			continue
+		}
+		restored++
+	}
+	scheduler_log.Debug("restored %d scheduled events", restored)
+	return errors.Join(errs...)
+}
+
+// Schedules an event.
+//
+// Param: operator - the targeted sockets
+//
+// Param: at - the time of the emission
+//
+// Param: data - the event name followed by its arguments
+func (s *Scheduler) Schedule(operator *BroadcastOperator, at time.Time, data []any) (*ScheduledEmitHandle, error) {
+	id, err := utils.Base64Id().GenerateId()
+	if err != nil {
+		return nil, err
+	}
+	emit := &ScheduledEmit{
+		Id:   id,
+		Nsp:  operator.adapter.Nsp().Name(),
+		At:   at.UnixMilli(),
+		Opts: NewScheduledEmitOptions(operator.broadcastOptions()),
+		Data: data,
+	}
+	if err := s.schedule(emit, operator, true); err != nil {
+		return nil, err
+	}
+	return &ScheduledEmitHandle{scheduler: s, emit: emit}, nil
+}
+
+func (s *Scheduler) schedule(emit *ScheduledEmit, operator *BroadcastOperator, persist bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return errors.New("the scheduler is closed")
+	}
+	if store := s.opts.Store(); persist && store != nil {
+		if err := store.Save(emit); err != nil {
+			return err
+		}
+	}
+	entry := &scheduledEntry{emit: emit, operator: operator}
+	s.entries.Store(emit.Id, entry)
+	entry.timer = s.wheel.Add(time.UnixMilli(emit.At), func() {
+		if _, ok := s.entries.LoadAndDelete(emit.Id); ok {
+			s.fire(entry)
+		}
+	})
+	scheduler_log.Debug("scheduled event %s at %d", emit.Id, emit.At)
+	return nil
+}
+
+func (s *Scheduler) fire(entry *scheduledEntry) {
+	if store := s.opts.Store(); store != nil {
+		if err := store.Delete(entry.emit.Id); err != nil {
+			scheduler_log.Debug("error while deleting scheduled event %s: %v", entry.emit.Id, err)
+		}
+	}
+	if len(entry.emit.Data) == 0 {
+		return
+	}
+	ev, ok := entry.emit.Data[0].(string)
+	if !ok {
+		scheduler_log.Debug("ignoring scheduled event %s with an invalid name", entry.emit.Id)
+		return
+	}
+	operator := entry.operator
+	if operator == nil {
+		nsp, ok := s.server._nsps.Load(entry.emit.Nsp)
+		if !ok {
+			scheduler_log.Debug("ignoring scheduled event %s of the unknown namespace %s", entry.emit.Id, entry.emit.Nsp)
+			return
+		}
+		operator = newBroadcastOperatorFromOptions(nsp.Adapter(), entry.emit.Opts.BroadcastOptions())
+	}
+	// the predicates and the outgoing listeners run on the goroutine of the timer wheel
+	defer recoverNamespacePanic(operator.adapter.Nsp(), ev)
+
+	if err := operator.Emit(ev, entry.emit.Data[1:]...); err != nil {
+		scheduler_log.Debug("error while emitting scheduled event %s: %v", entry.emit.Id, err)
+	}
+}
+
+// Cancels a scheduled event, returns whether it was pending.
+func (s *Scheduler) Cancel(id string) bool {
+	entry, ok := s.entries.LoadAndDelete(id)
+	if !ok {
+		return false
+	}
+	s.wheel.Remove(entry.timer)
+	if store := s.opts.Store(); store != nil {
+		if err := store.Delete(id); err != nil {
+			scheduler_log.Debug("error while deleting scheduled event %s: %v", id, err)
+		}
+	}
+	return true
+}
+
+// Returns the pending events.
+func (s *Scheduler) Pending() []*ScheduledEmit {
+	emits := []*ScheduledEmit{}
+	s.entries.Range(func(_ string, entry *scheduledEntry) bool {
+		emits = append(emits, entry.emit)
+		return true
+	})
+	sort.Slice(emits, func(i, j int) bool {
+		return emits[i].At < emits[j].At
+	})
+	return emits
+}
+
+// Stops the scheduler. The pending events are either emitted right away or cancelled, depending on
+// [ScheduledEmits.FlushOnClose]. The cancelled events are kept in the store, so that they are scheduled again by the
+// next server.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.wheel.Stop()
+
+	entries := []*scheduledEntry{}
+	s.entries.Range(func(id string, entry *scheduledEntry) bool {
+		if _, ok := s.entries.LoadAndDelete(id); ok {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	if !s.opts.FlushOnClose() {
+		scheduler_log.Debug("cancelling %d scheduled events", len(entries))
+		return
+	}
+	scheduler_log.Debug("flushing %d scheduled events", len(entries))
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].emit.At < entries[j].emit.At
+	})
+	for _, entry := range entries {
+		s.fire(entry)
+	}
+}
+
+func (h *ScheduledEmitHandle) Id() string {
+	return h.emit.Id
+}
+
+// Returns the time of the emission.
+func (h *ScheduledEmitHandle) At() time.Time {
+	return time.UnixMilli(h.emit.At)
+}
+
+// Cancels the emission, returns whether it was still pending.
+func (h *ScheduledEmitHandle) Cancel() bool {
+	return h.scheduler.Cancel(h.emit.Id)
+}
@@ -0,0 +1,137 @@
+package socket
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zishang520/engine.io/v2/types"
+)
+
+func TestScheduledEmitOptionsSerialization(t *testing.T) {
+	timeout := 5 * time.Second
+	opts := &BroadcastOptions{
+		Rooms:          types.NewSet[Room]("room-1", "room-2"),
+		Except:         types.NewSet[Room]("room-3"),
+		InAll:          types.NewSet[Room]("vip"),
+		RoomPatterns:   []string{"doc:*"},
+		ExceptPatterns: []string{"guest:*"},
+		Predicates:     []*SocketPredicate{{Field: "data.tier", Operator: PredicateEq, Value: "gold"}},
+		Flags:          &BroadcastFlags{Timeout: &timeout, History: true},
+	}
+	emit := &ScheduledEmit{Id: "id", Nsp: "/", At: 1, Opts: NewScheduledEmitOptions(opts), Data: []any{"event"}}
+
+	data, err := json.Marshal(emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &ScheduledEmit{}
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	ropts := restored.Opts.BroadcastOptions()
+	sorted := func(rooms *types.Set[Room]) []Room {
+		keys := roomKeys(rooms)
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		return keys
+	}
+	if actual := sorted(ropts.Rooms); !reflect.DeepEqual(actual, []Room{"room-1", "room-2"}) {
+		t.Errorf("unexpected rooms %v", actual)
+	}
+	if actual := sorted(ropts.Except); !reflect.DeepEqual(actual, []Room{"room-3"}) {
+		t.Errorf("unexpected except %v", actual)
+	}
+	if actual := sorted(ropts.InAll); !reflect.DeepEqual(actual, []Room{"vip"}) {
+		t.Errorf("unexpected inAll %v", actual)
+	}
+	if !reflect.DeepEqual(ropts.RoomPatterns, opts.RoomPatterns) || !reflect.DeepEqual(ropts.ExceptPatterns, opts.ExceptPatterns) {
+		t.Errorf("unexpected patterns %v %v", ropts.RoomPatterns, ropts.ExceptPatterns)
+	}
+	if len(ropts.Predicates) != 1 || ropts.Predicates[0].Field != "data.tier" {
+		t.Errorf("unexpected predicates %v", ropts.Predicates)
+	}
+	if ropts.Flags == nil || !ropts.Flags.History || ropts.Flags.Timeout == nil || *ropts.Flags.Timeout != timeout {
+		t.Errorf("unexpected flags %+v", ropts.Flags)
+	}
+}
+
+type memoryScheduledEmitStore struct {
+	mu    sync.Mutex
+	emits []*ScheduledEmit
+}
+
+func (m *memoryScheduledEmitStore) Save(emit *ScheduledEmit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.emits = append(m.emits, emit)
+	return nil
+}
+
+func (m *memoryScheduledEmitStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.emits = slices.DeleteFunc(m.emits, func(emit *ScheduledEmit) bool {
+		return emit != nil && emit.Id == id
+	})
+	return nil
+}
+
+func (m *memoryScheduledEmitStore) Load() ([]*ScheduledEmit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.emits), nil
+}
+
+func TestSchedulerRestore(t *testing.T) {
+	at := time.Now().Add(20 * time.Millisecond).UnixMilli()
+	store := &memoryScheduledEmitStore{emits: []*ScheduledEmit{
+		{Id: "restored", Nsp: "/restored", At: at, Data: []any{"hello", "restored"}},
+		nil,
+		{Id: "missing", Nsp: "/missing", At: at, Data: []any{"hello", "missing"}},
+	}}
+	scheduledEmits := &ScheduledEmits{}
+	scheduledEmits.SetTick(5 * time.Millisecond)
+	scheduledEmits.SetStore(store)
+	opts := DefaultServerOptions()
+	opts.SetScheduledEmits(scheduledEmits)
+	io := NewServer(nil, opts)
+	defer io.Scheduler().Close()
+
+	// the events are not restored by the construction of the server
+	if pending := io.Scheduler().Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending event, got %d", len(pending))
+	}
+
+	_, conn := newWriteTestSocket(io.Of("/restored", nil), "a")
+	if err := io.Scheduler().Restore(); err == nil {
+		t.Fatal("expected an error for the invalid event")
+	}
+	// the valid events are restored despite the invalid one, once
+	if err := io.Scheduler().Restore(); err == nil || len(io.Scheduler().Pending()) != 2 {
+		t.Fatalf("expected 2 pending events, got %d", len(io.Scheduler().Pending()))
+	}
+
+	expectWrites(t, conn, `2/restored,["hello","restored"]`)
+	deadline := time.Now().Add(time.Second)
+	for len(io.Scheduler().Pending()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if pending := io.Scheduler().Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending event, got %d", len(pending))
+	}
+	// the event of the unknown namespace is dropped, without creating the namespace
+	if _, ok := io._nsps.Load("/missing"); ok {
+		t.Fatal("expected the namespace not to be created")
+	}
+	if emits, _ := store.Load(); len(emits) != 1 || emits[0] != nil {
+		t.Fatalf("expected only the invalid event to be kept in the store, got %v", emits)
+	}
+}
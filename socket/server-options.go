@@ -1,6 +1,7 @@
 package socket
 
 import (
+	"runtime"
 	"time"
 
 	"github.com/zishang520/engine.io-server-go-fasthttp/v2/config"
@@ -16,6 +17,169 @@ type (
 		skipMiddlewares *bool
 	}
 
+	// The fan-out executor of the broadcasts: instead of writing the packet to every recipient on the goroutine of the
+	// emitter, the recipients are sharded across a bounded pool of workers.
+	//
+	// The packets broadcast to a given socket are always written in order, since a socket is always handled by the
+	// same worker. However, they may be written after a packet sent afterwards with [Socket.Emit] if the broadcast
+	// does not wait for the workers.
+	//
+	//	executor := &socket.BroadcastExecutor{}
+	//	executor.SetWorkers(8)
+	//	executor.SetWait(true)
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetBroadcastExecutor(executor)
+	BroadcastExecutor struct {
+		// The number of workers.
+		workers *int
+
+		// The number of pending batches per worker, before a broadcast blocks.
+		queueSize *int
+
+		// Whether a broadcast waits for the packet to be written to every recipient before returning.
+		wait *bool
+	}
+
+	// The options of the scheduler of the events emitted with [BroadcastOperator.EmitAt] and
+	// [BroadcastOperator.EmitAfter].
+	//
+	//	scheduledEmits := &socket.ScheduledEmits{}
+	//	scheduledEmits.SetStore(myStore)
+	//	scheduledEmits.SetFlushOnClose(true)
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetScheduledEmits(scheduledEmits)
+	ScheduledEmits struct {
+		// The duration of a tick of the timer wheel, which is the precision of the scheduled emissions.
+		tick *time.Duration
+
+		// The number of slots of the timer wheel.
+		slots *int
+
+		// The store of the scheduled events, they are only kept in memory if not set (see [Scheduler.Restore]).
+		store ScheduledEmitStore
+
+		// Whether the pending events are emitted when the server is closed (they are cancelled otherwise).
+		flushOnClose *bool
+	}
+
+	// The high-water marks of the outbound buffer of a client (the packets which were written but not yet sent over
+	// the transport), and what happens when a client exceeds them.
+	//
+	//	slowConsumer := &socket.SlowConsumer{}
+	//	slowConsumer.SetMaxBufferedBytes(1 << 20)
+	//	slowConsumer.SetPolicy(socket.SlowConsumerDropOldest)
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetSlowConsumer(slowConsumer)
+	//
+	//	io.On("slow_consumer", func(args ...any) {
+	//		socket := args[0].(*socket.Socket)
+	//		stats := args[1].(*socket.SlowConsumerStats)
+	//	})
+	SlowConsumer struct {
+		// The maximum number of buffered bytes (0 means no limit).
+		maxBufferedBytes *int64
+
+		// The maximum number of buffered packets (0 means no limit).
+		maxBufferedPackets *int64
+
+		// What happens to the packets written while a high-water mark is exceeded.
+		policy *SlowConsumerPolicy
+	}
+
+	// How the panics of the handlers (listeners, middlewares and acknowledgement callbacks) are handled. The panics
+	// are always recovered, logged and reported to the error handler if any, then the policy is applied.
+	//
+	//	panicRecovery := &socket.PanicRecovery{}
+	//	panicRecovery.SetPolicy(socket.PanicDisconnect)
+	//	panicRecovery.SetErrorHandler(func(client *socket.Socket, event string, value any, stack []byte) {
+	//		sentry.CaptureMessage(fmt.Sprintf("%s %s: %v", client.Nsp().Name(), event, value))
+	//	})
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetPanicRecovery(panicRecovery)
+	PanicRecovery struct {
+		// Called with each recovered panic.
+		errorHandler ErrorHandler
+
+		// What happens to the socket once a panic was recovered.
+		policy *PanicPolicy
+	}
+
+	// The parsers which may be selected by the clients during the handshake, with a query parameter or a header, in
+	// place of the parser of the server. The clients which do not select a parser use the parser of the server, while
+	// the ones which select an unknown parser are rejected.
+	//
+	//	parserNegotiation := &socket.ParserNegotiation{}
+	//	parserNegotiation.SetParsers(map[string]parser.Parser{
+	//		"msgpack": socket.NewMsgpackParser(),
+	//	})
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetParserNegotiation(parserNegotiation)
+	//
+	//	// client side: io("https://example.com", { parser: msgpackParser, query: { parser: "msgpack" } })
+	ParserNegotiation struct {
+		// The parsers, by name.
+		parsers map[string]parser.Parser
+
+		// The query parameter of the handshake which contains the name of the parser.
+		query *string
+
+		// The header of the handshake which contains the name of the parser, if the query parameter is not set.
+		header *string
+	}
+
+	// The flow control of the binary streams, see [Socket.OpenStream] and [Socket.OnStream].
+	//
+	//	streaming := &socket.Streaming{}
+	//	streaming.SetChunkSize(256 << 10)
+	//	streaming.SetMaxInFlight(4)
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetStreaming(streaming)
+	Streaming struct {
+		// The maximum size of a chunk, in bytes.
+		chunkSize *int
+
+		// The maximum number of chunks which were sent but not yet acknowledged.
+		maxInFlight *int
+	}
+
+	// The limits of the incoming packets, in addition to the "maxHttpBufferSize" option of the Engine.IO server, which
+	// may be overridden for a namespace with [WithPacketLimits].
+	//
+	// The limits of the server are checked as the packets are received, and the connection is closed with the reason
+	// of the violated limit (e.g. "packet too large"). The limits of the namespaces and of the events are checked once
+	// the packets are decoded, and the policy is applied.
+	//
+	//	packetLimits := &socket.PacketLimits{}
+	//	packetLimits.SetMaxArgs(4)
+	//	packetLimits.SetMaxPayloadSize(64 << 10)
+	//	packetLimits.SetMaxEventPayloadSizes(map[string]int64{"avatar:upload": 1 << 20})
+	//	packetLimits.SetPolicy(socket.PacketLimitDrop)
+	//
+	//	opts := socket.DefaultServerOptions()
+	//	opts.SetPacketLimits(packetLimits)
+	PacketLimits struct {
+		// The maximum number of arguments of an event (0 means no limit).
+		maxArgs *int
+
+		// The maximum size of a packet, including its binary attachments, in bytes (0 means no limit).
+		maxPayloadSize *int64
+
+		// The maximum size of the packets of the given events, in place of maxPayloadSize.
+		maxEventPayloadSizes map[string]int64
+
+		// The maximum number of binary attachments of a packet (0 means no limit).
+		maxAttachments *int
+
+		// What happens to the decoded packets which exceed a limit.
+		policy *PacketLimitPolicy
+	}
+
 	ServerOptionsInterface interface {
 		config.ServerOptionsInterface
 		config.AttachOptionsInterface
@@ -43,6 +207,34 @@ type (
 		SetCleanupEmptyChildNamespaces(bool)
 		GetRawCleanupEmptyChildNamespaces() *bool
 		CleanupEmptyChildNamespaces() bool
+
+		SetBroadcastExecutor(*BroadcastExecutor)
+		GetRawBroadcastExecutor() *BroadcastExecutor
+		BroadcastExecutor() *BroadcastExecutor
+
+		SetSlowConsumer(*SlowConsumer)
+		GetRawSlowConsumer() *SlowConsumer
+		SlowConsumer() *SlowConsumer
+
+		SetScheduledEmits(*ScheduledEmits)
+		GetRawScheduledEmits() *ScheduledEmits
+		ScheduledEmits() *ScheduledEmits
+
+		SetPanicRecovery(*PanicRecovery)
+		GetRawPanicRecovery() *PanicRecovery
+		PanicRecovery() *PanicRecovery
+
+		SetParserNegotiation(*ParserNegotiation)
+		GetRawParserNegotiation() *ParserNegotiation
+		ParserNegotiation() *ParserNegotiation
+
+		SetStreaming(*Streaming)
+		GetRawStreaming() *Streaming
+		Streaming() *Streaming
+
+		SetPacketLimits(*PacketLimits)
+		GetRawPacketLimits() *PacketLimits
+		PacketLimits() *PacketLimits
 	}
 
 	ServerOptions struct {
@@ -68,6 +260,27 @@ type (
 
 		// Whether to remove child namespaces that have no sockets connected to them
 		cleanupEmptyChildNamespaces *bool
+
+		// The fan-out executor of the broadcasts, the packets are written on the goroutine of the emitter if not set.
+		broadcastExecutor *BroadcastExecutor
+
+		// The high-water marks of the outbound buffer of the clients, the buffer is not limited if not set.
+		slowConsumer *SlowConsumer
+
+		// The options of the scheduler of the events emitted with [BroadcastOperator.EmitAt].
+		scheduledEmits *ScheduledEmits
+
+		// How the panics of the handlers are handled.
+		panicRecovery *PanicRecovery
+
+		// The parsers which may be selected by the clients, all the clients use the parser of the server if not set.
+		parserNegotiation *ParserNegotiation
+
+		// The flow control of the binary streams.
+		streaming *Streaming
+
+		// The limits of the incoming packets, the packets are only limited by the Engine.IO server if not set.
+		packetLimits *PacketLimits
 	}
 )
 
@@ -99,6 +312,298 @@ func (c *ConnectionStateRecovery) SkipMiddlewares() bool {
 	return *c.skipMiddlewares
 }
 
+func (b *BroadcastExecutor) SetWorkers(workers int) {
+	b.workers = &workers
+}
+func (b *BroadcastExecutor) GetRawWorkers() *int {
+	return b.workers
+}
+func (b *BroadcastExecutor) Workers() int {
+	if b.workers == nil || *b.workers <= 0 {
+		return runtime.NumCPU()
+	}
+
+	return *b.workers
+}
+
+func (b *BroadcastExecutor) SetQueueSize(queueSize int) {
+	b.queueSize = &queueSize
+}
+func (b *BroadcastExecutor) GetRawQueueSize() *int {
+	return b.queueSize
+}
+func (b *BroadcastExecutor) QueueSize() int {
+	if b.queueSize == nil || *b.queueSize < 0 {
+		return 1024
+	}
+
+	return *b.queueSize
+}
+
+func (b *BroadcastExecutor) SetWait(wait bool) {
+	b.wait = &wait
+}
+func (b *BroadcastExecutor) GetRawWait() *bool {
+	return b.wait
+}
+func (b *BroadcastExecutor) Wait() bool {
+	if b.wait == nil {
+		return false
+	}
+
+	return *b.wait
+}
+
+func (p *PanicRecovery) SetErrorHandler(errorHandler ErrorHandler) {
+	p.errorHandler = errorHandler
+}
+func (p *PanicRecovery) GetRawErrorHandler() ErrorHandler {
+	return p.errorHandler
+}
+func (p *PanicRecovery) ErrorHandler() ErrorHandler {
+	return p.errorHandler
+}
+
+func (p *PanicRecovery) SetPolicy(policy PanicPolicy) {
+	p.policy = &policy
+}
+func (p *PanicRecovery) GetRawPolicy() *PanicPolicy {
+	return p.policy
+}
+func (p *PanicRecovery) Policy() PanicPolicy {
+	if p.policy == nil {
+		return PanicKeep
+	}
+
+	return *p.policy
+}
+
+func (p *ParserNegotiation) SetParsers(parsers map[string]parser.Parser) {
+	p.parsers = parsers
+}
+func (p *ParserNegotiation) GetRawParsers() map[string]parser.Parser {
+	return p.parsers
+}
+func (p *ParserNegotiation) Parsers() map[string]parser.Parser {
+	return p.parsers
+}
+
+func (p *ParserNegotiation) SetQuery(query string) {
+	p.query = &query
+}
+func (p *ParserNegotiation) GetRawQuery() *string {
+	return p.query
+}
+func (p *ParserNegotiation) Query() string {
+	if p.query == nil {
+		return "parser"
+	}
+
+	return *p.query
+}
+
+func (p *ParserNegotiation) SetHeader(header string) {
+	p.header = &header
+}
+func (p *ParserNegotiation) GetRawHeader() *string {
+	return p.header
+}
+func (p *ParserNegotiation) Header() string {
+	if p.header == nil {
+		return "X-Socket-IO-Parser"
+	}
+
+	return *p.header
+}
+
+func (s *Streaming) SetChunkSize(chunkSize int) {
+	s.chunkSize = &chunkSize
+}
+func (s *Streaming) GetRawChunkSize() *int {
+	return s.chunkSize
+}
+func (s *Streaming) ChunkSize() int {
+	if s.chunkSize == nil || *s.chunkSize <= 0 {
+		return 64 << 10
+	}
+
+	return *s.chunkSize
+}
+
+func (s *Streaming) SetMaxInFlight(maxInFlight int) {
+	s.maxInFlight = &maxInFlight
+}
+func (s *Streaming) GetRawMaxInFlight() *int {
+	return s.maxInFlight
+}
+func (s *Streaming) MaxInFlight() int {
+	if s.maxInFlight == nil || *s.maxInFlight <= 0 {
+		return 8
+	}
+
+	return *s.maxInFlight
+}
+
+func (l *PacketLimits) SetMaxArgs(maxArgs int) {
+	l.maxArgs = &maxArgs
+}
+func (l *PacketLimits) GetRawMaxArgs() *int {
+	return l.maxArgs
+}
+func (l *PacketLimits) MaxArgs() int {
+	if l.maxArgs == nil {
+		return 0
+	}
+
+	return *l.maxArgs
+}
+
+func (l *PacketLimits) SetMaxPayloadSize(maxPayloadSize int64) {
+	l.maxPayloadSize = &maxPayloadSize
+}
+func (l *PacketLimits) GetRawMaxPayloadSize() *int64 {
+	return l.maxPayloadSize
+}
+func (l *PacketLimits) MaxPayloadSize() int64 {
+	if l.maxPayloadSize == nil {
+		return 0
+	}
+
+	return *l.maxPayloadSize
+}
+
+func (l *PacketLimits) SetMaxEventPayloadSizes(maxEventPayloadSizes map[string]int64) {
+	l.maxEventPayloadSizes = maxEventPayloadSizes
+}
+func (l *PacketLimits) GetRawMaxEventPayloadSizes() map[string]int64 {
+	return l.maxEventPayloadSizes
+}
+func (l *PacketLimits) MaxEventPayloadSizes() map[string]int64 {
+	return l.maxEventPayloadSizes
+}
+
+func (l *PacketLimits) SetMaxAttachments(maxAttachments int) {
+	l.maxAttachments = &maxAttachments
+}
+func (l *PacketLimits) GetRawMaxAttachments() *int {
+	return l.maxAttachments
+}
+func (l *PacketLimits) MaxAttachments() int {
+	if l.maxAttachments == nil {
+		return 0
+	}
+
+	return *l.maxAttachments
+}
+
+func (l *PacketLimits) SetPolicy(policy PacketLimitPolicy) {
+	l.policy = &policy
+}
+func (l *PacketLimits) GetRawPolicy() *PacketLimitPolicy {
+	return l.policy
+}
+func (l *PacketLimits) Policy() PacketLimitPolicy {
+	if l.policy == nil {
+		return PacketLimitDisconnect
+	}
+
+	return *l.policy
+}
+
+func (c *SlowConsumer) SetMaxBufferedBytes(maxBufferedBytes int64) {
+	c.maxBufferedBytes = &maxBufferedBytes
+}
+func (c *SlowConsumer) GetRawMaxBufferedBytes() *int64 {
+	return c.maxBufferedBytes
+}
+func (c *SlowConsumer) MaxBufferedBytes() int64 {
+	if c.maxBufferedBytes == nil {
+		return 0
+	}
+
+	return *c.maxBufferedBytes
+}
+
+func (c *SlowConsumer) SetMaxBufferedPackets(maxBufferedPackets int64) {
+	c.maxBufferedPackets = &maxBufferedPackets
+}
+func (c *SlowConsumer) GetRawMaxBufferedPackets() *int64 {
+	return c.maxBufferedPackets
+}
+func (c *SlowConsumer) MaxBufferedPackets() int64 {
+	if c.maxBufferedPackets == nil {
+		return 0
+	}
+
+	return *c.maxBufferedPackets
+}
+
+func (c *SlowConsumer) SetPolicy(policy SlowConsumerPolicy) {
+	c.policy = &policy
+}
+func (c *SlowConsumer) GetRawPolicy() *SlowConsumerPolicy {
+	return c.policy
+}
+func (c *SlowConsumer) Policy() SlowConsumerPolicy {
+	if c.policy == nil {
+		return SlowConsumerDropVolatile
+	}
+
+	return *c.policy
+}
+
+func (e *ScheduledEmits) SetTick(tick time.Duration) {
+	e.tick = &tick
+}
+func (e *ScheduledEmits) GetRawTick() *time.Duration {
+	return e.tick
+}
+func (e *ScheduledEmits) Tick() time.Duration {
+	if e.tick == nil || *e.tick <= 0 {
+		return time.Duration(100 * time.Millisecond)
+	}
+
+	return *e.tick
+}
+
+func (e *ScheduledEmits) SetSlots(slots int) {
+	e.slots = &slots
+}
+func (e *ScheduledEmits) GetRawSlots() *int {
+	return e.slots
+}
+func (e *ScheduledEmits) Slots() int {
+	if e.slots == nil || *e.slots <= 0 {
+		return 512
+	}
+
+	return *e.slots
+}
+
+func (e *ScheduledEmits) SetStore(store ScheduledEmitStore) {
+	e.store = store
+}
+func (e *ScheduledEmits) GetRawStore() ScheduledEmitStore {
+	return e.store
+}
+func (e *ScheduledEmits) Store() ScheduledEmitStore {
+	return e.store
+}
+
+func (e *ScheduledEmits) SetFlushOnClose(flushOnClose bool) {
+	e.flushOnClose = &flushOnClose
+}
+func (e *ScheduledEmits) GetRawFlushOnClose() *bool {
+	return e.flushOnClose
+}
+func (e *ScheduledEmits) FlushOnClose() bool {
+	if e.flushOnClose == nil {
+		return false
+	}
+
+	return *e.flushOnClose
+}
+
 func DefaultServerOptions() *ServerOptions {
 	a := &ServerOptions{}
 	return a
@@ -273,3 +778,101 @@ func (s *ServerOptions) CleanupEmptyChildNamespaces() bool {
 
 	return *s.cleanupEmptyChildNamespaces
 }
+
+func (s *ServerOptions) SetBroadcastExecutor(broadcastExecutor *BroadcastExecutor) {
+	s.broadcastExecutor = broadcastExecutor
+}
+func (s *ServerOptions) GetRawBroadcastExecutor() *BroadcastExecutor {
+	return s.broadcastExecutor
+}
+func (s *ServerOptions) BroadcastExecutor() *BroadcastExecutor {
+	if s.broadcastExecutor == nil {
+		return &BroadcastExecutor{}
+	}
+
+	return s.broadcastExecutor
+}
+
+func (s *ServerOptions) SetSlowConsumer(slowConsumer *SlowConsumer) {
+	s.slowConsumer = slowConsumer
+}
+func (s *ServerOptions) GetRawSlowConsumer() *SlowConsumer {
+	return s.slowConsumer
+}
+func (s *ServerOptions) SlowConsumer() *SlowConsumer {
+	if s.slowConsumer == nil {
+		return &SlowConsumer{}
+	}
+
+	return s.slowConsumer
+}
+
+func (s *ServerOptions) SetScheduledEmits(scheduledEmits *ScheduledEmits) {
+	s.scheduledEmits = scheduledEmits
+}
+func (s *ServerOptions) GetRawScheduledEmits() *ScheduledEmits {
+	return s.scheduledEmits
+}
+func (s *ServerOptions) ScheduledEmits() *ScheduledEmits {
+	if s.scheduledEmits == nil {
+		return &ScheduledEmits{}
+	}
+
+	return s.scheduledEmits
+}
+
+func (s *ServerOptions) SetPanicRecovery(panicRecovery *PanicRecovery) {
+	s.panicRecovery = panicRecovery
+}
+func (s *ServerOptions) GetRawPanicRecovery() *PanicRecovery {
+	return s.panicRecovery
+}
+func (s *ServerOptions) PanicRecovery() *PanicRecovery {
+	if s.panicRecovery == nil {
+		return &PanicRecovery{}
+	}
+
+	return s.panicRecovery
+}
+
+func (s *ServerOptions) SetParserNegotiation(parserNegotiation *ParserNegotiation) {
+	s.parserNegotiation = parserNegotiation
+}
+func (s *ServerOptions) GetRawParserNegotiation() *ParserNegotiation {
+	return s.parserNegotiation
+}
+func (s *ServerOptions) ParserNegotiation() *ParserNegotiation {
+	if s.parserNegotiation == nil {
+		return &ParserNegotiation{}
+	}
+
+	return s.parserNegotiation
+}
+
+func (s *ServerOptions) SetStreaming(streaming *Streaming) {
+	s.streaming = streaming
+}
+func (s *ServerOptions) GetRawStreaming() *Streaming {
+	return s.streaming
+}
+func (s *ServerOptions) Streaming() *Streaming {
+	if s.streaming == nil {
+		return &Streaming{}
+	}
+
+	return s.streaming
+}
+
+func (s *ServerOptions) SetPacketLimits(packetLimits *PacketLimits) {
+	s.packetLimits = packetLimits
+}
+func (s *ServerOptions) GetRawPacketLimits() *PacketLimits {
+	return s.packetLimits
+}
+func (s *ServerOptions) PacketLimits() *PacketLimits {
+	if s.packetLimits == nil {
+		return &PacketLimits{}
+	}
+
+	return s.packetLimits
+}
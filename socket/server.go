@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -75,6 +76,10 @@ type (
 		// @private
 		encoder parser.Encoder
 		// @private
+		//
+		// The parsers which may be selected by the clients, see [ParserNegotiation].
+		parsers map[string]*negotiatedParser
+		// @private
 		_nsps *types.Map[string, Namespace]
 		// @private
 		parentNsps *types.Map[ParentNspNameMatchFn, ParentNamespace]
@@ -95,6 +100,12 @@ type (
 		_connectTimeout time.Duration
 		httpServer      *f_types.HttpServer
 		_corsMiddleware engine.Middleware
+		// @private
+		//
+		// The fan-out executor of the broadcasts, nil unless configured.
+		executor *fanOutExecutor
+		// @private
+		scheduler *Scheduler
 	}
 )
 
@@ -123,6 +134,11 @@ func (s *Server) Engine() engine.BaseServer {
 	return s.engine
 }
 
+// Returns the scheduler of the events emitted with [BroadcastOperator.EmitAt] and [BroadcastOperator.EmitAfter].
+func (s *Server) Scheduler() *Scheduler {
+	return s.scheduler
+}
+
 func (s *Server) Encoder() parser.Encoder {
 	return s.encoder
 }
@@ -141,7 +157,11 @@ func (s *Server) Construct(srv any, opts *ServerOptions) {
 		s._parser = parser.NewParser()
 	}
 	s.encoder = s._parser.NewEncoder()
+	s.parsers = newNegotiatedParsers(opts.ParserNegotiation().Parsers())
 	s.opts = opts
+	if opts.GetRawBroadcastExecutor() != nil {
+		s.executor = newFanOutExecutor(opts.BroadcastExecutor())
+	}
 	if opts.GetRawAdapter() != nil {
 		s.SetAdapter(opts.Adapter())
 	} else {
@@ -153,6 +173,8 @@ func (s *Server) Construct(srv any, opts *ServerOptions) {
 	}
 	s.sockets = s.Of("/", nil)
 
+	s.scheduler = NewScheduler(s, opts.ScheduledEmits())
+
 	s.StrictEventEmitter = s.sockets.EventEmitter()
 
 	if srv != nil {
@@ -388,6 +410,17 @@ func (s *Server) serve(ctx *fasthttp.RequestCtx) {
 		return
 	}
 	filename := filepath.Base(strconv.B2S(ctx.Path()))
+	variant := ""
+	if _, ok := s._parser.(*MsgpackParser); ok {
+		// the client must be able to decode the packets of the server
+		msgpackFilename, ok := msgpackClientFilename(filename)
+		if !ok {
+			server_log.Debug("no msgpack build of client %s", filename)
+			ctx.Error("file not found", fasthttp.StatusNotFound)
+			return
+		}
+		filename, variant = msgpackFilename, "-msgpack"
+	}
 	isMap := dotMapRegex.MatchString(filename)
 	_type := "source"
 	if isMap {
@@ -395,7 +428,7 @@ func (s *Server) serve(ctx *fasthttp.RequestCtx) {
 	}
 	// Per the standard, ETags must be quoted:
 	// https://tools.ietf.org/html/rfc7232#section-2.3
-	expectedEtag := `"` + clientVersion + `"`
+	expectedEtag := `"` + clientVersion + variant + `"`
 	weakEtag := "W/" + expectedEtag
 
 	if etag := strconv.B2S(ctx.Request.Header.Peek("If-None-Match")); etag != "" {
@@ -418,6 +451,21 @@ func (s *Server) serve(ctx *fasthttp.RequestCtx) {
 	s.sendFile(filename, ctx)
 }
 
+// Returns the bundle of the client including the msgpack parser, in place of the bundles including the default one.
+// The ESM bundles are not available with the msgpack parser, so they are not served at all, since their clients could
+// not decode any packet.
+func msgpackClientFilename(filename string) (string, bool) {
+	switch filename {
+	case "socket.io.js", "socket.io.min.js":
+		return "socket.io.msgpack.min.js", true
+	case "socket.io.js.map", "socket.io.min.js.map":
+		return "socket.io.msgpack.min.js.map", true
+	case "socket.io.esm.min.js", "socket.io.esm.min.js.map":
+		return "", false
+	}
+	return filename, true
+}
+
 func (Server) sendFile(filename string, ctx *fasthttp.RequestCtx) {
 	_file, err := os.Executable()
 	if err != nil {
@@ -492,6 +540,11 @@ func (s *Server) Bind(egs engine.BaseServer) *Server {
 func (s *Server) onconnection(conns ...any) {
 	conn := conns[0].(engine.Socket)
 	server_log.Debug("incoming connection with id %s", conn.Id())
+	if _, ok := s.negotiateParser(conn); !ok {
+		server_log.Debug("unknown parser, close the connection %s", conn.Id())
+		conn.Close(false)
+		return
+	}
 	client := NewClient(s, conn)
 	if conn.Protocol() == 3 {
 		client.connect("/", nil)
@@ -512,13 +565,20 @@ func (s *Server) onconnection(conns ...any) {
 //		namespace.Emit("hello")
 //	})
 //
+//	// with options overriding the ones of the server
+//	chat := io.Of("/chat", nil, socket.WithAdapter(redisAdapterBuilder), socket.WithMaxSockets(10000))
+//	telemetry := io.Of("/telemetry", nil, socket.WithRecovery(nil))
+//
 // Param: string | *regexp.Regexp | ParentNspNameMatchFn - nsp name
 //
 // Param: func(...any) - nsp `connection` ev handler
-func (s *Server) Of(name any, fn func(...any)) Namespace {
+//
+// Param: opts - the options overriding the ones of the server, which apply to the child namespaces of a parent
+// namespace. They are ignored if the namespace already exists.
+func (s *Server) Of(name any, fn func(...any), opts ...NamespaceOption) Namespace {
 	switch n := name.(type) {
 	case ParentNspNameMatchFn:
-		parentNsp := NewParentNamespace(s)
+		parentNsp := NewParentNamespace(s, opts...)
 		server_log.Debug("initializing parent namespace %s", parentNsp.Name())
 
 		s.parentNsps.Store(n, parentNsp)
@@ -528,7 +588,7 @@ func (s *Server) Of(name any, fn func(...any)) Namespace {
 		}
 		return parentNsp
 	case *regexp.Regexp:
-		parentNsp := NewParentNamespace(s)
+		parentNsp := NewParentNamespace(s, opts...)
 		server_log.Debug("initializing parent namespace %s", parentNsp.Name())
 
 		nfn := func(nsp string, _ any, next func(error, bool)) {
@@ -536,6 +596,7 @@ func (s *Server) Of(name any, fn func(...any)) Namespace {
 		}
 		s.parentNsps.Store(ParentNspNameMatchFn(&nfn), parentNsp)
 		s.parentNamespacesFromRegExp.Store(n, parentNsp)
+		parentNsp.SetRegexp(n)
 
 		if fn != nil {
 			parentNsp.On("connect", fn)
@@ -559,6 +620,9 @@ func (s *Server) Of(name any, fn func(...any)) Namespace {
 	var namespace Namespace
 
 	if nsp, ok := s._nsps.Load(n); ok {
+		if len(opts) > 0 {
+			server_log.Debug("namespace %s already exists - ignoring the options", n)
+		}
 		namespace = nsp
 	} else {
 		s.parentNamespacesFromRegExp.Range(func(regex *regexp.Regexp, parentNamespace ParentNamespace) bool {
@@ -575,7 +639,7 @@ func (s *Server) Of(name any, fn func(...any)) Namespace {
 		}
 
 		server_log.Debug("initializing namespace %s", n)
-		namespace = NewNamespace(s, n)
+		namespace = NewNamespace(s, n, opts...)
 		s._nsps.Store(n, namespace)
 		if n != "/" {
 			s.sockets.EmitReserved("new_namespace", namespace)
@@ -588,10 +652,167 @@ func (s *Server) Of(name any, fn func(...any)) Namespace {
 	return namespace
 }
 
+// Creates a dynamic namespace with a route-style pattern, each `:name` segment matching a single segment of the name
+// of the child namespaces. The extracted parameters are available with [Namespace.Params], including in the
+// middlewares.
+//
+//	boards, err := io.OfPattern("/tenant/:tenantId/board/:boardId", nil)
+//	if err != nil {
+//		// the pattern is invalid
+//	}
+//	boards.On("connection", func(args ...any) {
+//		socket := args[0].(*socket.Socket)
+//		params := socket.Nsp().Params() // map[boardId:7 tenantId:42] for the "/tenant/42/board/7" namespace
+//	})
+//
+// An error is returned if the pattern is invalid, see [ParseNamespacePattern].
+//
+// Param: pattern - the pattern, like "/tenant/:tenantId"
+//
+// Param: func(...any) - nsp `connection` ev handler
+//
+// Param: opts - the options of the child namespaces, see [Server.Of]
+func (s *Server) OfPattern(pattern string, fn func(...any), opts ...NamespaceOption) (ParentNamespace, error) {
+	p, err := ParseNamespacePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	parentNsp := NewParentNamespace(s, opts...)
+	parentNsp.SetPattern(p)
+	server_log.Debug("initializing parent namespace %s with pattern %s", parentNsp.Name(), pattern)
+
+	// the name is validated before the creation of the child namespace
+	nfn := func(nsp string, _ any, next func(error, bool)) {
+		_, ok := p.Match(nsp)
+		next(nil, ok)
+	}
+	s.parentNsps.Store(ParentNspNameMatchFn(&nfn), parentNsp)
+	s.parentNamespacesFromRegExp.Store(p.Regexp(), parentNsp)
+
+	if fn != nil {
+		parentNsp.On("connect", fn)
+	}
+	return parentNsp, nil
+}
+
+// Returns the concrete namespaces (including the child namespaces of the parent namespaces), sorted by name.
+func (s *Server) Namespaces() []Namespace {
+	nsps := []Namespace{}
+	s._nsps.Range(func(_ string, nsp Namespace) bool {
+		nsps = append(nsps, nsp)
+		return true
+	})
+	sort.Slice(nsps, func(i, j int) bool {
+		return nsps[i].Name() < nsps[j].Name()
+	})
+	return nsps
+}
+
+// Deletes a namespace: the new connections are rejected, the sockets are disconnected, the adapter is closed and the
+// namespace is removed from its parent namespace, if any. The "delete_namespace" event is then emitted on the main
+// namespace.
+//
+// A parent namespace (see [Server.Of] with a regular expression or a function, and [Server.OfPattern]) is deleted with
+// its name, like "/_0": no child namespace is created anymore, and each of its child namespaces is deleted as well.
+//
+//	io.On("delete_namespace", func(args ...any) {
+//		namespace := args[0].(socket.Namespace)
+//	})
+//
+//	io.DeleteNamespace("/tenant-42", &socket.DeleteNamespaceOptions{
+//		Reason: "tenant deleted",
+//	})
+//
+// Note: a client may still create a dynamic namespace with the same name afterwards, if it matches a parent namespace.
+//
+// Param: name - the name of the namespace, the main namespace cannot be deleted
+//
+// Param: opts - the options, may be nil
+func (s *Server) DeleteNamespace(name string, opts *DeleteNamespaceOptions) error {
+	if name == "/" {
+		return errors.New("the main namespace cannot be deleted")
+	}
+	if opts == nil {
+		opts = &DeleteNamespaceOptions{}
+	}
+	reason := opts.Reason
+	if reason == "" {
+		reason = "server namespace delete"
+	}
+
+	if nsp, ok := s._nsps.LoadAndDelete(name); ok {
+		s.deleteNamespace(nsp, reason, opts.Close)
+		return nil
+	}
+
+	if parentNsp := s.removeParentNamespace(name); parentNsp != nil {
+		server_log.Debug("deleting parent namespace %s", name)
+		for _, nsp := range parentNsp.Children() {
+			parentNsp.RemoveChild(nsp)
+			// the child may have been deleted in the meantime
+			if _, ok := s._nsps.LoadAndDelete(nsp.Name()); ok {
+				s.deleteNamespace(nsp, reason, opts.Close)
+			}
+		}
+		parentNsp.Adapter().Close()
+
+		s.sockets.EmitReserved("delete_namespace", parentNsp)
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf(`namespace "%s" does not exist`, name))
+}
+
+// Unregisters the parent namespace with the given name, so that no child namespace is created anymore.
+func (s *Server) removeParentNamespace(name string) (parentNsp ParentNamespace) {
+	s.parentNsps.Range(func(fn ParentNspNameMatchFn, pnsp ParentNamespace) bool {
+		if pnsp.Name() != name {
+			return true
+		}
+		s.parentNsps.Delete(fn)
+		parentNsp = pnsp
+		return false
+	})
+	if parentNsp != nil {
+		if regex := parentNsp.Regexp(); regex != nil {
+			s.parentNamespacesFromRegExp.Delete(regex)
+		}
+	}
+	return parentNsp
+}
+
+// Deletes a namespace which was removed from the registered namespaces.
+func (s *Server) deleteNamespace(nsp Namespace, reason string, close bool) {
+	server_log.Debug("deleting namespace %s", nsp.Name())
+
+	nsp.Drain()
+	// the namespace must not be closed again by the cleanup of the parent namespace
+	nsp.Cleanup(nil)
+	s.parentNsps.Range(func(_ ParentNspNameMatchFn, parent ParentNamespace) bool {
+		return !parent.RemoveChild(nsp)
+	})
+
+	nsp.Sockets().Range(func(_ SocketId, socket *Socket) bool {
+		socket.disconnect(reason)
+		// the connection is kept for the other namespaces of the client
+		if close && socket.client.sockets.Len() == 0 {
+			socket.client.close()
+		}
+		return true
+	})
+	nsp.Adapter().Close()
+
+	s.sockets.EmitReserved("delete_namespace", nsp)
+}
+
 // Closes server connection
 //
 // Param: [fn] optional, called as `fn(error)` on error OR all conns closed
 func (s *Server) Close(fn func(error)) {
+	// the pending scheduled events may be flushed to the sockets, before they are closed
+	s.scheduler.Close()
+
 	s._nsps.Range(func(_ string, nsp Namespace) bool {
 		nsp.Sockets().Range(func(_ SocketId, socket *Socket) bool {
 			socket._onclose("server shutting down")
@@ -602,6 +823,10 @@ func (s *Server) Close(fn func(error)) {
 		return true
 	})
 
+	if s.executor != nil {
+		s.executor.Close()
+	}
+
 	if s.httpServer != nil {
 		s.httpServer.Close(fn)
 	} else {
@@ -656,6 +881,30 @@ func (s *Server) In(room ...Room) *BroadcastOperator {
 	return s.sockets.In(room...)
 }
 
+// Targets the sockets which have joined all the given rooms when emitting.
+//
+//	// the “foo” event will be broadcast to the clients which are both in the “tenant:42” and in the “channel:general” rooms
+//	io.InAll("tenant:42", "channel:general").Emit("foo", "bar")
+//
+// Param: Room - a [Room], or a [Room] slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (s *Server) InAll(room ...Room) *BroadcastOperator {
+	return s.sockets.InAll(room...)
+}
+
+// Targets the rooms matching a pattern when emitting.
+//
+//	// the “foo” event will be broadcast to all connected clients in a room starting with “user:”
+//	io.ToPattern("user:*").Emit("foo", "bar")
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (s *Server) ToPattern(pattern ...string) *BroadcastOperator {
+	return s.sockets.ToPattern(pattern...)
+}
+
 // Excludes a room when emitting.
 //
 //	// the "foo" event will be broadcast to all connected clients, except the ones that are in the "room-101" room
@@ -675,6 +924,19 @@ func (s *Server) Except(room ...Room) *BroadcastOperator {
 	return s.sockets.Except(room...)
 }
 
+// Excludes the rooms matching a pattern when emitting.
+//
+//	// the “foo” event will be broadcast to all connected clients, except the ones that are in a room starting with
+//	// “guest:”
+//	io.ExceptPattern("guest:*").Emit("foo", "bar")
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (s *Server) ExceptPattern(pattern ...string) *BroadcastOperator {
+	return s.sockets.ExceptPattern(pattern...)
+}
+
 // Sends a `message` event to all clients.
 //
 // This method mimics the WebSocket.send() method.
@@ -776,6 +1038,20 @@ func (s *Server) Volatile() *BroadcastOperator {
 	return s.sockets.Volatile()
 }
 
+// Sets a modifier for a subsequent event emission that at most one event emitted with the given key is delivered to
+// each client per window, the first one right away and the last of the following ones once the window expires.
+//
+//	io.Coalesce("price:AAPL", 100*time.Millisecond).Emit("price", "AAPL", 187.2)
+//
+// Param: key - the coalescing key
+//
+// Param: window - the window
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (s *Server) Coalesce(key string, window time.Duration) *BroadcastOperator {
+	return s.sockets.Coalesce(key, window)
+}
+
 // Sets a modifier for a subsequent event emission that the event data will only be broadcast to the current node.
 //
 //	// the “foo” event will be broadcast to all connected clients on this node
@@ -801,6 +1077,31 @@ func (s *Server) Timeout(timeout time.Duration) *BroadcastOperator {
 	return s.sockets.Timeout(timeout)
 }
 
+// Only targets the sockets for which the given function returns `true`.
+//
+//	// the “foo” event will be broadcast to the admins
+//	io.Where(func(socket socket.SocketDetails) bool {
+//		data, ok := socket.Data().(*User)
+//		return ok && data.Role == "admin"
+//	}).Emit("foo", "bar")
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (s *Server) Where(fn func(SocketDetails) bool) *BroadcastOperator {
+	return s.sockets.Where(fn)
+}
+
+// Only targets the sockets whose field matches the given value.
+//
+// Note: this method also works within a cluster of multiple Socket.IO servers, with a compatible [Adapter].
+//
+//	// the “foo” event will be broadcast to the admins
+//	io.WhereField("data.role", socket.PredicateEq, "admin").Emit("foo", "bar")
+//
+// Return: a new [BroadcastOperator] instance for chaining
+func (s *Server) WhereField(field string, operator PredicateOperator, value any) *BroadcastOperator {
+	return s.sockets.WhereField(field, operator, value)
+}
+
 // Returns the matching socket instances
 //
 // Note: this method also works within a cluster of multiple Socket.IO servers, with a compatible [Adapter].
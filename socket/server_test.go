@@ -0,0 +1,78 @@
+package socket
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestServerDeleteParentNamespace(t *testing.T) {
+	io := NewServer(nil, nil)
+
+	parentNsp, err := io.OfPattern("/tenant/:tenantId", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := parentNsp.CreateChild("/tenant/42")
+
+	deleted := []Namespace{}
+	io.On("delete_namespace", func(args ...any) {
+		deleted = append(deleted, args[0].(Namespace))
+	})
+
+	if err := io.DeleteNamespace(parentNsp.Name(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := io._nsps.Load("/tenant/42"); ok {
+		t.Fatal("expected the child namespace to be deleted")
+	}
+	if len(parentNsp.Children()) != 0 {
+		t.Fatalf("expected no child namespace, got %d", len(parentNsp.Children()))
+	}
+	if io.parentNsps.Len() != 0 || io.parentNamespacesFromRegExp.Len() != 0 {
+		t.Fatal("expected the parent namespace to be unregistered")
+	}
+	if len(deleted) != 2 || deleted[0] != child || deleted[1] != parentNsp {
+		t.Fatalf("unexpected deleted namespaces %v", deleted)
+	}
+
+	if err := io.DeleteNamespace(parentNsp.Name(), nil); err == nil {
+		t.Fatal("expected an error for a deleted namespace")
+	}
+}
+
+func TestMsgpackClientFilename(t *testing.T) {
+	for _, test := range []struct {
+		filename string
+		expected string
+		ok       bool
+	}{
+		{"socket.io.js", "socket.io.msgpack.min.js", true},
+		{"socket.io.min.js", "socket.io.msgpack.min.js", true},
+		{"socket.io.js.map", "socket.io.msgpack.min.js.map", true},
+		{"socket.io.min.js.map", "socket.io.msgpack.min.js.map", true},
+		{"socket.io.msgpack.min.js", "socket.io.msgpack.min.js", true},
+		{"socket.io.msgpack.min.js.map", "socket.io.msgpack.min.js.map", true},
+		// there is no msgpack build of the ESM bundles
+		{"socket.io.esm.min.js", "", false},
+		{"socket.io.esm.min.js.map", "", false},
+	} {
+		if filename, ok := msgpackClientFilename(test.filename); filename != test.expected || ok != test.ok {
+			t.Errorf("%s: expected %q %v, got %q %v", test.filename, test.expected, test.ok, filename, ok)
+		}
+	}
+}
+
+func TestServeMsgpackClientEsm(t *testing.T) {
+	opts := DefaultServerOptions()
+	opts.SetParser(NewMsgpackParser())
+	io := NewServer(nil, opts)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodGet)
+	ctx.Request.SetRequestURI("/socket.io/socket.io.esm.min.js")
+	io.serve(ctx)
+	if status := ctx.Response.StatusCode(); status != fasthttp.StatusNotFound {
+		t.Fatalf("expected the ESM bundle not to be served, got status %d", status)
+	}
+}
@@ -13,6 +13,11 @@ type (
 		AdapterConstructor
 	}
 
+	// The details of a disconnected socket, used to evaluate the targeting conditions of the missed packets.
+	persistedSocketDetails struct {
+		*SessionToPersist
+	}
+
 	sessionAwareAdapter struct {
 		Adapter
 
@@ -50,7 +55,7 @@ func NewSessionAwareAdapter(nsp Namespace) SessionAwareAdapter {
 
 func (s *sessionAwareAdapter) Construct(nsp Namespace) {
 	s.Adapter.Construct(nsp)
-	s.maxDisconnectionDuration = nsp.Server().Opts().ConnectionStateRecovery().MaxDisconnectionDuration()
+	s.maxDisconnectionDuration = nsp.Options().ConnectionStateRecovery().MaxDisconnectionDuration()
 
 	timer := utils.SetInterval(func() {
 		threshold := time.Now().UnixMilli() - s.maxDisconnectionDuration
@@ -68,6 +73,34 @@ func (s *sessionAwareAdapter) Construct(nsp Namespace) {
 	timer.Unref()
 }
 
+func (s *sessionAwareAdapter) BroadcastWithSocketAck(packet *parser.Packet, opts *BroadcastOptions, targetsCallback func([]SocketId), ack func(SocketId, []any, error)) {
+	broadcastWithSocketAck(s.Adapter, packet, opts, targetsCallback, ack)
+}
+
+func (s *sessionAwareAdapter) DelSocketsPattern(opts *BroadcastOptions, patterns []string) {
+	delSocketsPattern(s.Adapter, opts, patterns)
+}
+
+func (s *sessionAwareAdapter) SupportsRoomTargeting() bool {
+	return supportsRoomTargeting(s.Adapter)
+}
+
+func (s *sessionAwareAdapter) SetRoomMeta(room Room, meta *RoomMeta) {
+	s.Adapter.(RoomRegistryAdapter).SetRoomMeta(room, meta)
+}
+
+func (s *sessionAwareAdapter) RoomMeta(room Room) (*RoomMeta, bool) {
+	return s.Adapter.(RoomRegistryAdapter).RoomMeta(room)
+}
+
+func (s *sessionAwareAdapter) DelRoomMeta(room Room) {
+	s.Adapter.(RoomRegistryAdapter).DelRoomMeta(room)
+}
+
+func (s *sessionAwareAdapter) CheckJoin(socket SocketDetails, room Room) error {
+	return s.Adapter.(RoomRegistryAdapter).CheckJoin(socket, room)
+}
+
 func (s *sessionAwareAdapter) PersistSession(session *SessionToPersist) {
 	_session := &SessionWithTimestamp{SessionToPersist: session, DisconnectedAt: time.Now().UnixMilli()}
 	s.sessions.Store(_session.Pid, _session)
@@ -106,7 +139,7 @@ func (s *sessionAwareAdapter) RestoreSession(pid PrivateSessionId, offset string
 		if err != nil {
 			break
 		}
-		if shouldIncludePacket(session.Rooms, packet.Opts) {
+		if shouldIncludePacket(session.Rooms, packet.Opts) && packet.Opts.Matches(&persistedSocketDetails{session.SessionToPersist}) {
 			missedPackets = append(missedPackets, packet.Data)
 			missedNum++
 		}
@@ -142,18 +175,38 @@ func (s *sessionAwareAdapter) Broadcast(packet *parser.Packet, opts *BroadcastOp
 }
 
 func shouldIncludePacket(sessionRooms *types.Set[Room], opts *BroadcastOptions) bool {
-	included := opts.Rooms.Len() == 0
+	included := opts.Rooms.Len() == 0 && len(opts.RoomPatterns) == 0
 	notExcluded := true
 	for _, room := range sessionRooms.Keys() {
 		if included && !notExcluded {
 			break
 		}
-		if !included && opts.Rooms.Has(room) {
+		if !included && (opts.Rooms.Has(room) || matchAnyRoomPattern(opts.RoomPatterns, room)) {
 			included = true
 		}
-		if notExcluded && opts.Except.Has(room) {
+		if notExcluded && (opts.Except.Has(room) || matchAnyRoomPattern(opts.ExceptPatterns, room)) {
 			notExcluded = false
 		}
 	}
-	return included && notExcluded
+	return included && notExcluded && hasAllRooms(sessionRooms, roomKeys(opts.InAll))
+}
+
+func (p *persistedSocketDetails) Id() SocketId {
+	return p.Sid
+}
+
+// Returns the handshake of the session, which is empty if it was persisted without one.
+func (p *persistedSocketDetails) Handshake() *Handshake {
+	if p.SessionToPersist.Handshake == nil {
+		return &Handshake{}
+	}
+	return p.SessionToPersist.Handshake
+}
+
+func (p *persistedSocketDetails) Rooms() *types.Set[Room] {
+	return p.SessionToPersist.Rooms
+}
+
+func (p *persistedSocketDetails) Data() any {
+	return p.SessionToPersist.Data
 }
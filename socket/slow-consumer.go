@@ -0,0 +1,310 @@
+package socket
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	_types "github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/engine.io-server-go-fasthttp/v2/transports"
+	"github.com/zishang520/engine.io/v2/log"
+)
+
+var slow_consumer_log = log.NewLog("socket.io:slow-consumer")
+
+type (
+	// What happens to the packets written to a client while one of the high-water marks of its outbound buffer is
+	// exceeded.
+	SlowConsumerPolicy string
+
+	// The state of the outbound buffer of a client, emitted with the "slow_consumer" event of the namespaces.
+	SlowConsumerStats struct {
+		// The id of the underlying Engine.IO connection
+		ClientId string `json:"clientId" mapstructure:"clientId" msgpack:"clientId"`
+		// The number of buffered bytes (written to the engine but not yet sent, or held back)
+		BufferedBytes int64 `json:"bufferedBytes" mapstructure:"bufferedBytes" msgpack:"bufferedBytes"`
+		// The number of buffered packets
+		BufferedPackets int64 `json:"bufferedPackets" mapstructure:"bufferedPackets" msgpack:"bufferedPackets"`
+		// The number of packets dropped since the connection
+		Dropped uint64 `json:"dropped" mapstructure:"dropped" msgpack:"dropped"`
+		// The policy of the server
+		Policy SlowConsumerPolicy `json:"policy" mapstructure:"policy" msgpack:"policy"`
+	}
+
+	// A packet held back by the [SlowConsumerDropOldest] policy.
+	pendingWrite struct {
+		encodedPackets []_types.BufferInterface
+		opts           *WriteOptions
+		// whether the packet must not be dropped (e.g. CONNECT or ACK packets)
+		critical bool
+		size     int64
+	}
+
+	// The outbound buffer of a client.
+	clientOutbound struct {
+		opts *SlowConsumer
+
+		// the packets written to the engine which were not sent yet, decremented once the transport is drained
+		bytes   atomic.Int64
+		packets atomic.Int64
+		dropped atomic.Uint64
+
+		// guards the backlog, and is held while writing to the engine so that the packets are written in order
+		mu             sync.Mutex
+		backlog        []*pendingWrite
+		backlogBytes   int64
+		backlogPackets int64
+		backlogged     atomic.Bool
+		// whether a high-water mark is currently exceeded
+		slow   bool
+		closed atomic.Bool
+	}
+)
+
+const (
+	// Volatile packets are dropped, the other packets are buffered as usual.
+	SlowConsumerDropVolatile SlowConsumerPolicy = "drop-volatile"
+	// Packets are held back until the transport is drained, and the oldest held back packets are dropped (except the
+	// CONNECT, DISCONNECT and ACK packets).
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop-oldest"
+	// The client is disconnected with the "slow consumer" reason.
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+func newClientOutbound(opts *SlowConsumer) *clientOutbound {
+	return &clientOutbound{opts: opts}
+}
+
+// Whether writing the given bytes and packets would exceed one of the high-water marks. A client which has nothing
+// buffered never exceeds them, even with a packet larger than the marks.
+func (o *clientOutbound) exceeds(size int64, count int64) bool {
+	bytes := o.bytes.Load() + o.backlogBytes
+	packets := o.packets.Load() + o.backlogPackets
+	if bytes == 0 && packets == 0 {
+		return false
+	}
+	if max := o.opts.MaxBufferedBytes(); max > 0 && bytes+size > max {
+		return true
+	}
+	if max := o.opts.MaxBufferedPackets(); max > 0 && packets+count > max {
+		return true
+	}
+	return false
+}
+
+// Whether the held back packets alone exceed one of the high-water marks.
+func (o *clientOutbound) backlogExceeds() bool {
+	if max := o.opts.MaxBufferedBytes(); max > 0 && o.backlogBytes > max {
+		return true
+	}
+	if max := o.opts.MaxBufferedPackets(); max > 0 && o.backlogPackets > max {
+		return true
+	}
+	return false
+}
+
+// Drops the oldest non-critical packets of the backlog, until the held back packets no longer exceed the high-water
+// marks (the packets written to the engine are not taken into account, the newest packet would always be dropped
+// otherwise).
+func (o *clientOutbound) evict() {
+	for i := 0; i < len(o.backlog) && o.backlogExceeds(); {
+		if w := o.backlog[i]; !w.critical {
+			o.backlog = append(o.backlog[:i], o.backlog[i+1:]...)
+			o.backlogBytes -= w.size
+			o.backlogPackets -= int64(len(w.encodedPackets))
+			o.dropped.Add(1)
+		} else {
+			i++
+		}
+	}
+	o.backlogged.Store(len(o.backlog) > 0)
+}
+
+// Returns the stats if the client just became slow, nil otherwise.
+func (o *clientOutbound) markSlow(clientId string) *SlowConsumerStats {
+	if o.slow {
+		return nil
+	}
+	o.slow = true
+	return o.stats(clientId)
+}
+
+func (o *clientOutbound) stats(clientId string) *SlowConsumerStats {
+	return &SlowConsumerStats{
+		ClientId:        clientId,
+		BufferedBytes:   o.bytes.Load() + o.backlogBytes,
+		BufferedPackets: o.packets.Load() + o.backlogPackets,
+		Dropped:         o.dropped.Load(),
+		Policy:          o.opts.Policy(),
+	}
+}
+
+// Returns the state of the outbound buffer of the client, nil if the server has no [SlowConsumer] option.
+func (c *Client) OutboundStats() *SlowConsumerStats {
+	o := c.outbound
+	if o == nil {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.stats(c.id)
+}
+
+// Writes the encoded packets to the engine, applying the [CoalesceOptions] of the emission and the
+// [SlowConsumerPolicy] of the server if any.
+//
+// Param: nsp - the namespace of the packets, the coalescing keys are scoped by namespace
+func (c *Client) writeToEngine(nsp string, encodedPackets []_types.BufferInterface, opts *WriteOptions, critical bool) {
+	if opts.Coalesce != nil && opts.Coalesce.Window > 0 {
+		c.coalesce(nsp, encodedPackets, opts, critical)
+		return
+	}
+	// the packets held back by a coalescing window were emitted before this one
+	c.flushCoalesced(math.MaxUint64)
+	c.writeOutbound(encodedPackets, opts, critical)
+}
+
+// Writes the encoded packets to the engine, applying the [SlowConsumerPolicy] of the server if any.
+func (c *Client) writeOutbound(encodedPackets []_types.BufferInterface, opts *WriteOptions, critical bool) {
+	if opts.Volatile && !c.conn.Transport().Writable() {
+		client_log.Debug("volatile packet is discarded since the transport is not currently writable")
+		return
+	}
+
+	o := c.outbound
+	if o == nil {
+		for _, encodedPacket := range encodedPackets {
+			c.write(encodedPacket, opts, nil)
+		}
+		return
+	}
+
+	w := &pendingWrite{
+		encodedPackets: encodedPackets,
+		opts:           opts,
+		critical:       critical,
+	}
+	for _, encodedPacket := range encodedPackets {
+		w.size += int64(encodedPacket.Len())
+	}
+
+	var stats *SlowConsumerStats
+	disconnect := false
+
+	o.mu.Lock()
+	switch {
+	case len(o.backlog) == 0 && !o.exceeds(w.size, int64(len(encodedPackets))):
+		o.slow = false
+		c.send(w)
+	case o.opts.Policy() == SlowConsumerDisconnect:
+		o.dropped.Add(1)
+		stats = o.markSlow(c.id)
+		disconnect = true
+	case o.opts.Policy() == SlowConsumerDropOldest:
+		// the packets are held back (even if the marks are no longer exceeded) as long as the backlog is not empty,
+		// in order to preserve their order
+		o.backlog = append(o.backlog, w)
+		o.backlogBytes += w.size
+		o.backlogPackets += int64(len(encodedPackets))
+		o.evict()
+		stats = o.markSlow(c.id)
+	default:
+		if opts.Volatile {
+			o.dropped.Add(1)
+		} else {
+			c.send(w)
+		}
+		stats = o.markSlow(c.id)
+	}
+	o.mu.Unlock()
+
+	if stats == nil && !disconnect {
+		return
+	}
+	if opts.fromExecutor {
+		go c.onslowConsumer(stats, disconnect)
+	} else {
+		c.onslowConsumer(stats, disconnect)
+	}
+}
+
+// Emits the "slow_consumer" event if the client just became slow, and disconnects it if required by the policy.
+func (c *Client) onslowConsumer(stats *SlowConsumerStats, disconnect bool) {
+	if stats != nil {
+		c.onslow(stats)
+	}
+	if disconnect && c.outbound.closed.CompareAndSwap(false, true) {
+		slow_consumer_log.Debug("closing slow client %s", c.id)
+		if "open" == c.conn.ReadyState() {
+			c.conn.Close(false)
+			c.onclose("slow consumer")
+		}
+	}
+}
+
+// Writes a packet to the engine, the outbound buffer is decremented once the transport is drained.
+//
+// Must be called with the lock of the outbound buffer held.
+func (c *Client) send(w *pendingWrite) {
+	o := c.outbound
+	for _, encodedPacket := range w.encodedPackets {
+		size := int64(encodedPacket.Len())
+		o.bytes.Add(size)
+		o.packets.Add(1)
+		c.write(encodedPacket, w.opts, func(transports.Transport) {
+			o.bytes.Add(-size)
+			o.packets.Add(-1)
+			// the drain may be emitted while writing to the engine, so the backlog is flushed on another goroutine
+			if o.backlogged.Load() {
+				go c.flushBacklog()
+			}
+		})
+	}
+}
+
+func (c *Client) write(encodedPacket _types.BufferInterface, opts *WriteOptions, callback func(transports.Transport)) {
+	switch data := encodedPacket.(type) {
+	case *_types.StringBuffer:
+		c.conn.Write(_types.NewStringBuffer(data.Bytes()), &opts.Options, callback)
+	case *_types.BytesBuffer:
+		c.conn.Write(_types.NewBytesBuffer(data.Bytes()), &opts.Options, callback)
+	}
+}
+
+// Writes the held back packets to the engine, as long as the high-water marks are not exceeded.
+func (c *Client) flushBacklog() {
+	o := c.outbound
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for len(o.backlog) > 0 {
+		w := o.backlog[0]
+		// the packet is not part of the buffered packets while checking the marks
+		o.backlogBytes -= w.size
+		o.backlogPackets -= int64(len(w.encodedPackets))
+		if o.exceeds(w.size, int64(len(w.encodedPackets))) {
+			o.backlogBytes += w.size
+			o.backlogPackets += int64(len(w.encodedPackets))
+			break
+		}
+		o.backlog[0] = nil
+		o.backlog = o.backlog[1:]
+		c.send(w)
+	}
+	o.backlogged.Store(len(o.backlog) > 0)
+	if len(o.backlog) == 0 {
+		o.slow = false
+	}
+}
+
+// Emits the "slow_consumer" event on the namespaces of the client.
+func (c *Client) onslow(stats *SlowConsumerStats) {
+	slow_consumer_log.Debug("client %s exceeds the high-water marks (%d bytes, %d packets)", c.id, stats.BufferedBytes, stats.BufferedPackets)
+	c.sockets.Range(func(_ SocketId, socket *Socket) bool {
+		socket.Nsp().EmitReserved("slow_consumer", socket, stats)
+		return true
+	})
+}
@@ -0,0 +1,160 @@
+package socket
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// Returns a socket of a server with the given slow consumer policy, with the "slow_consumer" events of its namespace.
+func newSlowConsumerTestSocket(policy SlowConsumerPolicy, maxBufferedPackets int64) (*Socket, *writeTestConn, chan *SlowConsumerStats) {
+	slowConsumer := &SlowConsumer{}
+	slowConsumer.SetPolicy(policy)
+	slowConsumer.SetMaxBufferedPackets(maxBufferedPackets)
+	opts := DefaultServerOptions()
+	opts.SetSlowConsumer(slowConsumer)
+	nsp := NewServer(nil, opts).Of("/", nil)
+
+	slow := make(chan *SlowConsumerStats, 10)
+	nsp.On("slow_consumer", func(args ...any) {
+		slow <- args[1].(*SlowConsumerStats)
+	})
+	socket, conn := newWriteTestSocket(nsp, "a")
+	return socket, conn, slow
+}
+
+func expectWrites(t *testing.T, conn *writeTestConn, expected ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		writes := conn.Writes()
+		if slices.Equal(writes, expected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the writes %v, got %v", expected, writes)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func expectSlowConsumer(t *testing.T, slow chan *SlowConsumerStats) *SlowConsumerStats {
+	t.Helper()
+
+	select {
+	case stats := <-slow:
+		return stats
+	case <-time.After(time.Second):
+		t.Fatal("expected a slow_consumer event")
+	}
+	return nil
+}
+
+func TestSlowConsumerDropVolatile(t *testing.T) {
+	socket, conn, slow := newSlowConsumerTestSocket(SlowConsumerDropVolatile, 2)
+
+	socket.Emit("a")
+	socket.Emit("b")
+	// the buffer is full, the volatile packets are dropped and the other ones are buffered as usual
+	socket.Volatile().Emit("c")
+	socket.Emit("d")
+	expectWrites(t, conn, `2["a"]`, `2["b"]`, `2["d"]`)
+
+	stats := expectSlowConsumer(t, slow)
+	if stats.Policy != SlowConsumerDropVolatile || stats.BufferedPackets != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// the event is only emitted when the client becomes slow
+	select {
+	case stats := <-slow:
+		t.Fatalf("unexpected slow_consumer event %+v", stats)
+	default:
+	}
+	if dropped := socket.Client().OutboundStats().Dropped; dropped != 1 {
+		t.Fatalf("expected 1 dropped packet, got %d", dropped)
+	}
+
+	conn.drain()
+	if stats := socket.Client().OutboundStats(); stats.BufferedPackets != 0 || stats.BufferedBytes != 0 {
+		t.Fatalf("expected an empty buffer, got %+v", stats)
+	}
+	socket.Volatile().Emit("e")
+	expectWrites(t, conn, `2["a"]`, `2["b"]`, `2["d"]`, `2["e"]`)
+}
+
+func TestSlowConsumerDropOldest(t *testing.T) {
+	socket, conn, slow := newSlowConsumerTestSocket(SlowConsumerDropOldest, 2)
+
+	socket.Emit("a")
+	socket.Emit("b")
+	// the packets are held back, the oldest ones being dropped once the backlog exceeds the marks
+	socket.Emit("c")
+	socket.Emit("d")
+	socket.Emit("e")
+	expectWrites(t, conn, `2["a"]`, `2["b"]`)
+
+	expectSlowConsumer(t, slow)
+	stats := socket.Client().OutboundStats()
+	if stats.Dropped != 1 || stats.BufferedPackets != 4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// the backlog is flushed once the transport is drained
+	conn.drain()
+	expectWrites(t, conn, `2["a"]`, `2["b"]`, `2["d"]`, `2["e"]`)
+
+	// the packets are no longer held back
+	conn.drain()
+	socket.Emit("f")
+	expectWrites(t, conn, `2["a"]`, `2["b"]`, `2["d"]`, `2["e"]`, `2["f"]`)
+	if stats := socket.Client().OutboundStats(); stats.Dropped != 1 || stats.BufferedPackets != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSlowConsumerDropOldestFlushesWithinMarks(t *testing.T) {
+	socket, conn, _ := newSlowConsumerTestSocket(SlowConsumerDropOldest, 2)
+
+	socket.Emit("a")
+	socket.Emit("b")
+	socket.Emit("c")
+	socket.Emit("d")
+
+	// only two packets can be written once the transport is drained
+	conn.drain()
+	expectWrites(t, conn, `2["a"]`, `2["b"]`, `2["c"]`, `2["d"]`)
+	socket.Emit("e")
+	expectWrites(t, conn, `2["a"]`, `2["b"]`, `2["c"]`, `2["d"]`)
+
+	conn.drain()
+	expectWrites(t, conn, `2["a"]`, `2["b"]`, `2["c"]`, `2["d"]`, `2["e"]`)
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	socket, conn, slow := newSlowConsumerTestSocket(SlowConsumerDisconnect, 1)
+
+	reason := make(chan string, 1)
+	socket.On("disconnect", func(args ...any) {
+		reason <- args[0].(string)
+	})
+
+	socket.Emit("a")
+	socket.Emit("b")
+	expectWrites(t, conn, `2["a"]`)
+
+	if stats := expectSlowConsumer(t, slow); stats.Policy != SlowConsumerDisconnect || stats.Dropped != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	select {
+	case r := <-reason:
+		if r != "slow consumer" {
+			t.Fatalf(`expected the "slow consumer" reason, got %s`, r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the socket to be disconnected")
+	}
+	if conn.ReadyState() != "closed" || socket.Connected() {
+		t.Fatal("expected the connection to be closed")
+	}
+}
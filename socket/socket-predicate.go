@@ -0,0 +1,289 @@
+package socket
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type (
+	// The comparison applied by a [SocketPredicate].
+	PredicateOperator string
+
+	// A serializable condition on the details of a socket (id, handshake, rooms or data).
+	//
+	// Unlike the function given to [BroadcastOperator.Where], a predicate can be shipped to the other Socket.IO servers
+	// of the cluster, so it is honoured by compatible cluster adapters.
+	//
+	//	// the “foo” event will be broadcast to the admins of the “room-101” room
+	//	io.To("room-101").WhereField("data.role", socket.PredicateEq, "admin").Emit("foo", "bar")
+	SocketPredicate struct {
+		// A dot-separated path, starting with "id", "handshake", "rooms" or "data" (e.g. "data.profile.role",
+		// "handshake.query.token.0").
+		Field    string            `json:"field" mapstructure:"field" msgpack:"field"`
+		Operator PredicateOperator `json:"op" mapstructure:"op" msgpack:"op"`
+		Value    any               `json:"value,omitempty" mapstructure:"value,omitempty" msgpack:"value,omitempty"`
+
+		// the segments of Field, split once since the predicate is matched against each socket
+		path atomic.Pointer[[]string]
+	}
+)
+
+const (
+	PredicateEq       PredicateOperator = "eq"
+	PredicateNe       PredicateOperator = "ne"
+	PredicateIn       PredicateOperator = "in"
+	PredicateNin      PredicateOperator = "nin"
+	PredicateGt       PredicateOperator = "gt"
+	PredicateGte      PredicateOperator = "gte"
+	PredicateLt       PredicateOperator = "lt"
+	PredicateLte      PredicateOperator = "lte"
+	PredicateExists   PredicateOperator = "exists"
+	PredicateContains PredicateOperator = "contains"
+	PredicatePrefix   PredicateOperator = "prefix"
+)
+
+func NewSocketPredicate(field string, operator PredicateOperator, value any) *SocketPredicate {
+	p := &SocketPredicate{Field: field, Operator: operator, Value: value}
+	p.fieldPath()
+	return p
+}
+
+// Returns the segments of the field, the predicates which were decoded (e.g. received from another server) being split
+// upon their first match.
+func (p *SocketPredicate) fieldPath() []string {
+	if path := p.path.Load(); path != nil {
+		return *path
+	}
+	path := strings.Split(p.Field, ".")
+	p.path.Store(&path)
+	return path
+}
+
+func (p *SocketPredicate) String() string {
+	return fmt.Sprintf("%s %s %v", p.Field, p.Operator, p.Value)
+}
+
+// Whether the given socket satisfies the predicate.
+func (p *SocketPredicate) Match(socket SocketDetails) bool {
+	value, found := resolveSocketField(socket, p.fieldPath())
+
+	switch p.Operator {
+	case PredicateExists:
+		exists := found && value != nil
+		if want, ok := p.Value.(bool); ok {
+			return exists == want
+		}
+		return exists
+	case PredicateNe:
+		return !found || !predicateEqual(value, p.Value)
+	case PredicateNin:
+		return !found || !predicateIn(value, p.Value)
+	}
+
+	if !found {
+		return false
+	}
+
+	switch p.Operator {
+	case PredicateEq:
+		return predicateEqual(value, p.Value)
+	case PredicateIn:
+		return predicateIn(value, p.Value)
+	case PredicateGt:
+		c, ok := predicateCompare(value, p.Value)
+		return ok && c > 0
+	case PredicateGte:
+		c, ok := predicateCompare(value, p.Value)
+		return ok && c >= 0
+	case PredicateLt:
+		c, ok := predicateCompare(value, p.Value)
+		return ok && c < 0
+	case PredicateLte:
+		c, ok := predicateCompare(value, p.Value)
+		return ok && c <= 0
+	case PredicateContains:
+		if s, ok := predicateString(value); ok {
+			sub, ok := predicateString(p.Value)
+			return ok && strings.Contains(s, sub)
+		}
+		return predicateIn(p.Value, value)
+	case PredicatePrefix:
+		s, ok := predicateString(value)
+		prefix, _ok := predicateString(p.Value)
+		return ok && _ok && strings.HasPrefix(s, prefix)
+	}
+
+	socket_log.Debug("unknown predicate operator %s", p.Operator)
+	return false
+}
+
+// Resolves the segments of a dot-separated path against the details of a socket.
+func resolveSocketField(socket SocketDetails, path []string) (any, bool) {
+	var root any
+	switch path[0] {
+	case "id":
+		root = string(socket.Id())
+	case "handshake":
+		root = socket.Handshake()
+	case "rooms":
+		rooms := []any{}
+		if r := socket.Rooms(); r != nil {
+			for _, room := range r.Keys() {
+				rooms = append(rooms, string(room))
+			}
+		}
+		root = rooms
+	case "data":
+		root = socket.Data()
+	default:
+		return nil, false
+	}
+
+	value := reflect.ValueOf(root)
+	for _, key := range path[1:] {
+		for value.IsValid() && (value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface) {
+			if value.IsNil() {
+				return nil, false
+			}
+			value = value.Elem()
+		}
+		if !value.IsValid() {
+			return nil, false
+		}
+
+		switch value.Kind() {
+		case reflect.Map:
+			if value.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			value = value.MapIndex(reflect.ValueOf(key).Convert(value.Type().Key()))
+		case reflect.Struct:
+			value = predicateStructField(value, key)
+		case reflect.Slice, reflect.Array:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= value.Len() {
+				return nil, false
+			}
+			value = value.Index(i)
+		default:
+			return nil, false
+		}
+		if !value.IsValid() {
+			return nil, false
+		}
+	}
+
+	if !value.IsValid() || !value.CanInterface() {
+		return nil, false
+	}
+	return value.Interface(), true
+}
+
+// Looks up a struct field by its json/msgpack tag, then by its (case-insensitive) name.
+func predicateStructField(value reflect.Value, key string) reflect.Value {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		for _, tag := range []string{"json", "msgpack", "mapstructure"} {
+			if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name == key {
+				return value.Field(i)
+			}
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.IsExported() && strings.EqualFold(field.Name, key) {
+			return value.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+func predicateNumber(v any) (float64, bool) {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	}
+	return 0, false
+}
+
+func predicateString(v any) (string, bool) {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.String {
+		return value.String(), true
+	}
+	return "", false
+}
+
+func predicateEqual(a, b any) bool {
+	if x, ok := predicateNumber(a); ok {
+		y, ok := predicateNumber(b)
+		return ok && x == y
+	}
+	if x, ok := predicateString(a); ok {
+		y, ok := predicateString(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Whether value equals one of the elements of the list.
+func predicateIn(value any, list any) bool {
+	l := reflect.ValueOf(list)
+	if l.Kind() != reflect.Slice && l.Kind() != reflect.Array {
+		return false
+	}
+	for i := 0; i < l.Len(); i++ {
+		if predicateEqual(value, l.Index(i).Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+func predicateCompare(a, b any) (int, bool) {
+	if x, ok := predicateNumber(a); ok {
+		y, ok := predicateNumber(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	if x, ok := predicateString(a); ok {
+		y, ok := predicateString(b)
+		return strings.Compare(x, y), ok
+	}
+	return 0, false
+}
+
+// Whether the given socket satisfies the [BroadcastOptions.Filter] and every [BroadcastOptions.Predicates].
+func (o *BroadcastOptions) Matches(socket SocketDetails) bool {
+	if o == nil {
+		return true
+	}
+	if o.Filter != nil && !o.Filter(socket) {
+		return false
+	}
+	for _, predicate := range o.Predicates {
+		if !predicate.Match(socket) {
+			return false
+		}
+	}
+	return true
+}
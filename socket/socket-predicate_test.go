@@ -0,0 +1,103 @@
+package socket
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/zishang520/engine.io/v2/types"
+)
+
+type predicateTestProfile struct {
+	Role  string   `json:"role"`
+	Level int      `json:"level"`
+	Tags  []string `json:"tags"`
+	Name  string
+}
+
+func TestSocketPredicateMatch(t *testing.T) {
+	socket := &persistedSocketDetails{&SessionToPersist{
+		Sid:   "a",
+		Rooms: types.NewSet[Room]("a", "room-1"),
+		Data: map[string]any{
+			"tier":    "gold",
+			"score":   42,
+			"profile": &predicateTestProfile{Role: "admin", Level: 3, Tags: []string{"beta", "staff"}, Name: "alice"},
+			"missing": nil,
+		},
+		Handshake: &Handshake{Query: map[string][]string{"token": {"abc"}}},
+	}}
+
+	for _, test := range []struct {
+		field    string
+		operator PredicateOperator
+		value    any
+		match    bool
+	}{
+		// the roots
+		{"id", PredicateEq, "a", true},
+		{"rooms", PredicateContains, "room-1", true},
+		{"rooms", PredicateContains, "room-2", false},
+		{"handshake.query.token.0", PredicateEq, "abc", true},
+		{"unknown", PredicateExists, nil, false},
+
+		// nested paths, through maps, pointers, struct tags and names, and slices
+		{"data.tier", PredicateEq, "gold", true},
+		{"data.profile.role", PredicateEq, "admin", true},
+		{"data.profile.Role", PredicateEq, "admin", true},
+		{"data.profile.name", PredicateEq, "alice", true},
+		{"data.profile.tags.1", PredicateEq, "staff", true},
+		{"data.profile.tags", PredicateContains, "beta", true},
+
+		// missing fields
+		{"data.profile.tags.5", PredicateExists, nil, false},
+		{"data.profile.email", PredicateExists, false, true},
+		{"data.missing", PredicateExists, nil, false},
+		{"data.missing.field", PredicateEq, "x", false},
+		{"data.tier.length", PredicateEq, 4, false},
+		{"data.unknown", PredicateNe, "gold", true},
+		{"data.unknown", PredicateNin, []any{"gold"}, true},
+		{"data.unknown", PredicateEq, nil, false},
+
+		// the operators, the numbers of different types being compared by value
+		{"data.score", PredicateEq, 42.0, true},
+		{"data.score", PredicateNe, uint8(42), false},
+		{"data.score", PredicateIn, []any{1, 42}, true},
+		{"data.score", PredicateNin, []int{1, 2}, true},
+		{"data.score", PredicateGt, 41, true},
+		{"data.score", PredicateGte, 42, true},
+		{"data.score", PredicateLt, 42, false},
+		{"data.score", PredicateLte, 42.5, true},
+		{"data.tier", PredicateGt, "bronze", true},
+		{"data.tier", PredicatePrefix, "go", true},
+		{"data.tier", PredicateContains, "ol", true},
+		{"data.profile.level", PredicateGte, 3, true},
+
+		// the type mismatches never match
+		{"data.score", PredicateEq, "42", false},
+		{"data.tier", PredicateGt, 1, false},
+		{"data.score", PredicatePrefix, "4", false},
+		{"data.score", PredicateIn, "42", false},
+		{"data.profile", PredicateLt, 1, false},
+		{"data.tier", "unknown", "gold", false},
+	} {
+		predicate := NewSocketPredicate(test.field, test.operator, test.value)
+		if match := predicate.Match(socket); match != test.match {
+			t.Errorf("%s: expected %v, got %v", predicate, test.match, match)
+		}
+	}
+}
+
+func TestSocketPredicateDecoded(t *testing.T) {
+	socket := &persistedSocketDetails{&SessionToPersist{Sid: "a", Data: map[string]any{"profile": map[string]any{"role": "admin"}}}}
+
+	// the predicates received from the other servers are not built with NewSocketPredicate
+	var predicate *SocketPredicate
+	if err := json.Unmarshal([]byte(`{"field":"data.profile.role","op":"eq","value":"admin"}`), &predicate); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if !predicate.Match(socket) {
+			t.Fatalf("expected %s to match", predicate)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package socket
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -126,6 +127,20 @@ type (
 		_anyOutgoingListeners *types.Slice[events.Listener]
 
 		canJoin atomic.Bool
+		// rooms joined before the connection, whose history is replayed upon connection
+		pendingReplays *types.Slice[Room]
+		// the last broadcast which targeted this socket in each dedup slot of the adapter, used to deliver a packet
+		// only once when the socket is in several of the targeted rooms
+		broadcastEpochs [adapterDedupSlots]atomic.Uint64
+		// the binary streams opened by the server or by the client
+		streams *socketStreams
+		// whether the socket holds a slot of the namespace, see [WithMaxSockets]
+		slotReserved atomic.Bool
+		// the context of the middlewares of the namespace, see [Namespace.UseContext]
+		middlewareCtx context.Context
+		// closed upon disconnection, which releases the pending calls (see [Socket.Call])
+		closed     chan struct{}
+		closedOnce sync.Once
 	}
 )
 
@@ -138,7 +153,10 @@ func MakeSocket() *Socket {
 		fns:                   types.NewSlice[func([]any, func(error))](),
 		_anyListeners:         types.NewSlice[events.Listener](),
 		_anyOutgoingListeners: types.NewSlice[events.Listener](),
+		pendingReplays:        types.NewSlice[Room](),
+		closed:                make(chan struct{}),
 	}
+	s.streams = newSocketStreams(s)
 	s.flags.Store(&BroadcastFlags{})
 	s.canJoin.Store(true)
 
@@ -239,7 +257,7 @@ func (s *Socket) Construct(nsp Namespace, client *Client, auth any, previousSess
 			id, _ := utils.Base64Id().GenerateId()
 			s.id = SocketId(id) // don't reuse the Engine.IO id because it's sensitive information
 		}
-		if s.server.Opts().GetRawConnectionStateRecovery() != nil {
+		if nsp.Options().GetRawConnectionStateRecovery() != nil {
 			id, _ := utils.Base64Id().GenerateId()
 			s.pid = PrivateSessionId(id)
 		}
@@ -285,6 +303,15 @@ func (s *Socket) Emit(ev string, args ...any) error {
 	if SOCKET_RESERVED_EVENTS.Has(ev) {
 		return errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
 	}
+	flags := *s.flags.Load()
+	s.flags.Store(&BroadcastFlags{})
+	s.emit(ev, args, &flags)
+	return nil
+}
+
+// Emits an event with the given flags rather than the ones set on the socket, returns the id of the acknowledgement
+// if the last argument is an ack callback.
+func (s *Socket) emit(ev string, args []any, flags *BroadcastFlags) *uint64 {
 	data := append([]any{ev}, args...)
 	data_len := len(data)
 	packet := &parser.Packet{
@@ -296,25 +323,27 @@ func (s *Socket) Emit(ev string, args ...any) error {
 		id := s.nsp.Ids()
 		socket_log.Debug("emitting packet with ack id %d", id)
 		packet.Data = data[:data_len-1]
-		s.registerAckCallback(id, fn)
+		s.registerAckCallback(id, fn, flags.Timeout)
 		packet.Id = &id
 	}
-	flags := *s.flags.Load()
-	s.flags.Store(&BroadcastFlags{})
+	if packet.Id != nil {
+		// the acknowledgement of a discarded event would never be received
+		flags.Coalesce = nil
+	}
 
-	if s.nsp.Server().Opts().GetRawConnectionStateRecovery() != nil {
+	if s.nsp.Options().GetRawConnectionStateRecovery() != nil {
 		// this ensures the packet is stored and can be transmitted upon reconnection
 		s.adapter.Broadcast(packet, &BroadcastOptions{
 			Rooms:  types.NewSet(Room(s.id)),
 			Except: types.NewSet[Room](),
-			Flags:  &flags,
+			Flags:  flags,
 		})
 	} else {
 		s.notifyOutgoingListeners(packet)
-		s.packet(packet, &flags)
+		s.packet(packet, flags)
 	}
 
-	return nil
+	return packet.Id
 }
 
 // Emits an event and waits for an acknowledgement
@@ -347,13 +376,48 @@ func (s *Socket) EmitWithAck(ev string, args ...any) func(func([]any, error)) {
 	}
 }
 
-func (s *Socket) registerAckCallback(id uint64, ack func([]any, error)) {
-	timeout := s.flags.Load().Timeout
+// Calls a method of the client, which answers with an acknowledgement called with `(error, result)`. The result is
+// decoded into `resp` (if not nil), and the error is returned as an [*RpcError].
+//
+//	io.On("connection", func(clients ...any) {
+//		client := clients[0].(*socket.Socket)
+//		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//		defer cancel()
+//
+//		var resp Location
+//		if err := client.Call(ctx, "location:get", &LocationRequest{Precise: true}, &resp); err != nil {
+//			// ...
+//		}
+//	})
+//
+// The call fails with the [RpcCodeTimeout] code once the deadline of the context is reached, and with the
+// [RpcCodeDisconnected] code if the socket disconnects in the meantime. It is retried upon timeout if the method has a
+// [RpcRetryPolicy], see [Rpc.SetRetryPolicy].
+func (s *Socket) Call(ctx context.Context, method string, req any, resp any) error {
+	return s.nsp.Rpc().Call(ctx, s, method, req, resp)
+}
+
+// Returns a context which is cancelled once the socket disconnects, or once the returned function is called.
+func (s *Socket) lifetimeContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-s.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (s *Socket) registerAckCallback(id uint64, ack func([]any, error), timeout *time.Duration) {
 	if timeout == nil {
 		s.acks.Store(id, ack)
 		return
 	}
 	timer := utils.SetTimeout(func() {
+		defer s.recoverPanic("ack")
+
 		socket_log.Debug("event with ack id %d has timed out after %d ms", id, *timeout/time.Millisecond)
 		s.acks.Delete(id)
 		ack(nil, errors.New("operation has timed out"))
@@ -474,14 +538,108 @@ func (s *Socket) packet(packet *parser.Packet, opts *BroadcastFlags) {
 //		socket.Join([]Room{"room-101", "room-102"}...)
 //	})
 //
+// The rooms which are full or rejected by the join guard of the namespace are not joined, see [Socket.JoinE] to get
+// the reason.
+//
 // Param: Room - a `Room`, or a `Room` slice to expand
 func (s *Socket) Join(rooms ...Room) {
+	s.JoinE(rooms...)
+}
+
+// Joins a room, and returns why the rooms which were not joined were rejected.
+//
+//	io.On("connection", func(clients ...any) {
+//		socket := clients[0].(*socket.Socket)
+//		// the room may be full, or the join guard of the namespace may reject it
+//		if err := socket.JoinE("room-103"); errors.Is(err, socket.ErrRoomFull) {
+//			// ...
+//		}
+//	})
+//
+// Param: Room - a `Room`, or a `Room` slice to expand
+//
+// Return: a [JoinError] for each rejected room (the other rooms are joined), joined with [errors.Join]
+func (s *Socket) JoinE(rooms ...Room) error {
 	if !s.canJoin.Load() {
-		return
+		return nil
 	}
 
 	socket_log.Debug("join room %s", rooms)
-	s.adapter.AddAll(s.id, types.NewSet(rooms...))
+	allowed := types.NewSet[Room]()
+	joined := []Room{}
+	errs := []error{}
+	for _, room := range rooms {
+		// the private room of the socket is always allowed
+		if room != Room(s.id) {
+			if err := checkJoin(s.nsp, s, room); err != nil {
+				socket_log.Debug("join room %s rejected: %v", room, err)
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if !allowed.Has(room) && !s.Rooms().Has(room) {
+			joined = append(joined, room)
+		}
+		allowed.Add(room)
+	}
+	if allowed.Len() > 0 {
+		s.adapter.AddAll(s.id, allowed)
+	}
+	// the capacity of the rooms is checked again by the adapter, along with the addition
+	socketRooms := s.Rooms()
+	for _, room := range allowed.Keys() {
+		if !socketRooms.Has(room) {
+			errs = append(errs, &JoinError{Room: room, Err: ErrRoomFull})
+		}
+	}
+	for _, room := range joined {
+		if !socketRooms.Has(room) {
+			continue
+		}
+		if opts, ok := s.nsp.RoomHistory().Options(room); ok && opts.ReplayOnJoin {
+			if !s.Connected() {
+				// the history is replayed once the CONNECT packet is sent
+				s.pendingReplays.Push(room)
+				continue
+			}
+			if err := s.ReplayRoom(room, time.Time{}); err != nil {
+				socket_log.Debug("error while replaying the history of room %s: %v", room, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Emits the retained events of a room to this socket, in order. See [Namespace.RoomHistory].
+//
+//	io.On("connection", func(clients ...any) {
+//		socket := clients[0].(*socket.Socket)
+//		socket.On("resync", func(args ...any) {
+//			// the events emitted to the "general" room during the last minute
+//			socket.ReplayRoom("general", time.Now().Add(-time.Minute))
+//		})
+//	})
+//
+// Param: room - the room
+//
+// Param: since - only the events emitted after this time are replayed (the zero time replays all retained events)
+func (s *Socket) ReplayRoom(room Room, since time.Time) error {
+	entries, err := s.nsp.RoomHistory().Range(room, since)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Matches(s) {
+			continue
+		}
+		packet := &parser.Packet{
+			Type: parser.EVENT,
+			Data: entry.Data,
+		}
+		s.notifyOutgoingListeners(packet)
+		s.packet(packet, nil)
+	}
+	return nil
 }
 
 // Leaves a room.
@@ -502,6 +660,24 @@ func (s *Socket) Leave(room Room) {
 	s.adapter.Del(s.id, room)
 }
 
+// Leaves the rooms matching a pattern, where `*` matches any sequence of characters and `?` matches a single
+// character.
+//
+//	io.On("connection", func(clients ...any) {
+//		socket := clients[0].(*socket.Socket)
+//		// leave all the "doc:..." rooms
+//		socket.LeavePattern("doc:*")
+//	})
+//
+// Param: pattern - a pattern, or a pattern slice to expand
+func (s *Socket) LeavePattern(pattern ...string) {
+	for _, room := range s.Rooms().Keys() {
+		if matchAnyRoomPattern(pattern, room) {
+			s.Leave(room)
+		}
+	}
+}
+
 // Leave all rooms.
 func (s *Socket) leaveAll() {
 	s.adapter.DelAll(s.id)
@@ -530,10 +706,23 @@ func (s *Socket) _onconnect() {
 			},
 		}, nil)
 	}
+
+	rooms := s.pendingReplays.AllAndClear()
+	if s.recovered {
+		// the missed packets were already transmitted
+		return
+	}
+	for _, room := range rooms {
+		if err := s.ReplayRoom(room, time.Time{}); err != nil {
+			socket_log.Debug("error while replaying the history of room %s: %v", room, err)
+		}
+	}
 }
 
 // Called with each packet. Called by `Client`.
 func (s *Socket) _onpacket(packet *parser.Packet) {
+	defer s.recoverPanic(packetEvent(packet))
+
 	socket_log.Debug("got packet %v", packet)
 	switch packet.Type {
 	case parser.EVENT:
@@ -586,10 +775,10 @@ func (s *Socket) ack(id uint64) func([]any, error) {
 // Called upon ack packet.
 func (s *Socket) onack(packet *parser.Packet) {
 	if packet.Id != nil {
-		if ack, ok := s.acks.Load(*packet.Id); ok {
+		// the callback is removed first, so that a panicking callback is not kept
+		if ack, ok := s.acks.LoadAndDelete(*packet.Id); ok {
 			socket_log.Debug("calling ack %d with %v", *packet.Id, packet.Data)
 			ack(packet.Data.([]any), nil)
-			s.acks.Delete(*packet.Id)
 		} else {
 			socket_log.Debug("bad ack %d", *packet.Id)
 		}
@@ -622,24 +811,35 @@ func (s *Socket) _onclose(args ...any) *Socket {
 		return s
 	}
 	socket_log.Debug("closing socket - reason %v", args[0])
-	s.EmitReserved("disconnecting", args...)
+	s.emitClosing("disconnecting", args...)
 
-	if s.server.Opts().GetRawConnectionStateRecovery() != nil && RECOVERABLE_DISCONNECT_REASONS.Has(args[0].(string)) {
+	if s.nsp.Options().GetRawConnectionStateRecovery() != nil && RECOVERABLE_DISCONNECT_REASONS.Has(args[0].(string)) {
 		socket_log.Debug("connection state recovery is enabled for sid %s", s.id)
 		s.adapter.PersistSession(&SessionToPersist{
-			Sid:   s.id,
-			Pid:   s.pid,
-			Rooms: types.NewSet(s.Rooms().Keys()...),
-			Data:  s.Data(),
+			Sid:       s.id,
+			Pid:       s.pid,
+			Rooms:     types.NewSet(s.Rooms().Keys()...),
+			Data:      s.Data(),
+			Handshake: s.handshake,
 		})
 	}
 	s._cleanup()
 	s.client._remove(s)
 	s.connected.Store(false)
-	s.EmitReserved("disconnect", args...)
+	s.closedOnce.Do(func() { close(s.closed) })
+	s.streams.close(fmt.Sprint(args[0]))
+	s.emitClosing("disconnect", args...)
 	return nil
 }
 
+// Emits "disconnecting" or "disconnect", a panicking listener must not prevent the cleanup of the socket nor the
+// closing of the other sockets of the client.
+func (s *Socket) emitClosing(ev string, args ...any) {
+	defer s.recoverPanic(ev)
+
+	s.EmitReserved(ev, args...)
+}
+
 // Makes the socket leave all the rooms it was part of and prevents it from joining any other room
 func (s *Socket) _cleanup() {
 	s.leaveAll()
@@ -674,14 +874,22 @@ func (s *Socket) Disconnect(status bool) *Socket {
 	if status {
 		s.client._disconnect()
 	} else {
-		s.packet(&parser.Packet{
-			Type: parser.DISCONNECT,
-		}, nil)
-		s._onclose("server namespace disconnect")
+		s.disconnect("server namespace disconnect")
 	}
 	return s
 }
 
+// Sends a DISCONNECT packet and closes the socket with the given reason.
+func (s *Socket) disconnect(reason string) {
+	if !s.Connected() {
+		return
+	}
+	s.packet(&parser.Packet{
+		Type: parser.DISCONNECT,
+	}, nil)
+	s._onclose(reason)
+}
+
 // Sets the compress flag.
 //
 //	io.On("connection", func(clients ...any) {
@@ -708,6 +916,24 @@ func (s *Socket) Volatile() *Socket {
 	return s
 }
 
+// Sets a modifier for a subsequent event emission that at most one event emitted with the given key is delivered per
+// window: the first event is delivered right away, and only the last of the following events is delivered once the
+// window expires, the previous ones being discarded. The events emitted with an acknowledgement are never coalesced.
+//
+//	io.On("connection", func(clients ...any) {
+//		socket := clients[0].(*socket.Socket)
+//		// at most one "position" event every 50ms
+//		socket.Coalesce("position", 50*time.Millisecond).Emit("position", x, y)
+//	})
+//
+// Param: key - the coalescing key
+//
+// Param: window - the window
+func (s *Socket) Coalesce(key string, window time.Duration) *Socket {
+	s.flags.Load().Coalesce = &CoalesceOptions{Key: key, Window: window}
+	return s
+}
+
 // Sets a modifier for a subsequent event emission that the event data will only be broadcast to every sockets but the
 // sender.
 //
@@ -756,11 +982,20 @@ func (s *Socket) dispatch(event []any) {
 	socket_log.Debug("dispatching an event %v", event)
 	s.run(event, func(err error) {
 		go func(err error) {
+			ev, _ := event[0].(string)
+			defer s.recoverPanic(ev)
+
 			if err != nil {
 				s._onerror(err)
 				return
 			}
 			if s.Connected() {
+				if s.nsp.Rpc().handle(s, event) {
+					return
+				}
+				if s.streams.handle(event) {
+					return
+				}
 				s.EmitUntyped(event[0].(string), event[1:]...)
 			} else {
 				socket_log.Debug("ignore packet received after disconnection")
@@ -802,28 +1037,60 @@ func (s *Socket) Use(fn func([]any, func(error))) *Socket {
 // Pparam: fn - last fn call in the middleware
 func (s *Socket) run(event []any, fn func(error)) {
 	fns := s.fns.All()
-	if length := len(fns); length > 0 {
-		var run func(i int)
-		run = func(i int) {
-			fns[i](event, func(err error) {
-				// upon error, short-circuit
-				if err != nil {
-					fn(err)
-					return
-				}
-				// if no middleware left, summon callback
-				if i >= length-1 {
-					fn(nil)
-					return
-				}
-				// go on to next
-				run(i + 1)
-			})
-		}
-		run(0)
-	} else {
+	length := len(fns)
+	if length == 0 {
 		fn(nil)
+		return
+	}
+
+	var done atomic.Bool
+	var timer *utils.Timer
+	finish := func(err error) {
+		if !done.CompareAndSwap(false, true) {
+			return
+		}
+		if timer != nil {
+			utils.ClearTimeout(timer)
+		}
+		fn(err)
+	}
+	if timeout := s.nsp.EventMiddlewareTimeout(); timeout > 0 {
+		timer = utils.SetTimeout(func() {
+			socket_log.Debug("middlewares of event %v have timed out after %d ms", event[0], timeout/time.Millisecond)
+			// the timer is not cleared, since it expired
+			if done.CompareAndSwap(false, true) {
+				fn(ErrMiddlewareTimeout)
+			}
+		}, timeout)
+	}
+
+	var run func(i int)
+	run = func(i int) {
+		var called atomic.Bool
+		fns[i](event, func(err error) {
+			if !called.CompareAndSwap(false, true) {
+				socket_log.Warning("next called more than once by middleware #%d of socket %s - ignoring", i, s.id)
+				return
+			}
+			if done.Load() {
+				socket_log.Debug("next called after the middleware timeout - ignoring")
+				return
+			}
+			// upon error, short-circuit
+			if err != nil {
+				finish(err)
+				return
+			}
+			// if no middleware left, summon callback
+			if i >= length-1 {
+				finish(nil)
+				return
+			}
+			// go on to next
+			run(i + 1)
+		})
 	}
+	run(0)
 }
 
 // Whether the socket is currently disconnected
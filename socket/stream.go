@@ -0,0 +1,560 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	_types "github.com/zishang520/engine.io-go-parser/types"
+	"github.com/zishang520/engine.io/v2/log"
+	"github.com/zishang520/engine.io/v2/types"
+)
+
+var stream_log = log.NewLog("socket.io:stream")
+
+// The events of the binary streams, which are exchanged as regular events:
+//
+//   - "socket.io:stream:open" [id, event, meta] opens a stream, the receiver of a stream opened by a client
+//     acknowledges it with [nil, {"chunkSize": ..., "maxInFlight": ...}] or with [{"message": ...}] if it is rejected
+//   - "socket.io:stream:chunk" [id, seq, data] sends the chunk with the given sequence number (starting at 1), which is
+//     acknowledged once consumed by the receiver
+//   - "socket.io:stream:end" [id, count] ends a stream, once the given number of chunks are consumed
+//   - "socket.io:stream:abort" [id, reason] is sent by the sender of a stream to abort it
+//   - "socket.io:stream:cancel" [id, reason] is sent by the receiver of a stream to cancel it
+//
+// The ids are chosen by the sender of the stream, the streams opened by each side being distinct.
+const (
+	STREAM_EVENT_PREFIX = "socket.io:stream:"
+	STREAM_OPEN_EVENT   = STREAM_EVENT_PREFIX + "open"
+	STREAM_CHUNK_EVENT  = STREAM_EVENT_PREFIX + "chunk"
+	STREAM_END_EVENT    = STREAM_EVENT_PREFIX + "end"
+	STREAM_ABORT_EVENT  = STREAM_EVENT_PREFIX + "abort"
+	STREAM_CANCEL_EVENT = STREAM_EVENT_PREFIX + "cancel"
+)
+
+var (
+	// Returned by a stream which was cancelled by its receiver, aborted by its sender or interrupted by the
+	// disconnection of the socket. The error includes the reason.
+	ErrStreamCancelled = errors.New("stream cancelled")
+	// Returned when writing to a closed stream.
+	ErrStreamClosed = errors.New("stream closed")
+)
+
+type (
+	// Called with each stream opened by the client for the event, see [Socket.OnStream].
+	StreamHandler func(*StreamReader)
+
+	// The streams of a socket.
+	socketStreams struct {
+		socket *Socket
+
+		ids      atomic.Uint64
+		writers  *types.Map[string, *StreamWriter]
+		readers  *types.Map[string, *StreamReader]
+		handlers *types.Map[string, StreamHandler]
+	}
+
+	// A stream sent to the client, see [Socket.OpenStream].
+	StreamWriter struct {
+		streams *socketStreams
+
+		id          string
+		chunkSize   int
+		maxInFlight int
+
+		mu       sync.Mutex
+		cond     *sync.Cond
+		seq      uint64
+		inFlight int
+		closed   bool
+		err      error
+	}
+
+	// A stream received from the client, see [Socket.OnStream].
+	StreamReader struct {
+		streams *socketStreams
+
+		id          string
+		event       string
+		meta        any
+		maxInFlight int
+
+		mu   sync.Mutex
+		cond *sync.Cond
+		// the chunks which were received but not yet read, by sequence number
+		chunks map[uint64]*streamChunk
+		// the sequence number of the chunk being read
+		seq uint64
+		// the number of chunks of the stream, once ended
+		count *uint64
+		err   error
+	}
+
+	streamChunk struct {
+		data []byte
+		ack  func([]any, error)
+	}
+)
+
+func newSocketStreams(socket *Socket) *socketStreams {
+	return &socketStreams{
+		socket:   socket,
+		writers:  &types.Map[string, *StreamWriter]{},
+		readers:  &types.Map[string, *StreamReader]{},
+		handlers: &types.Map[string, StreamHandler]{},
+	}
+}
+
+// Opens a binary stream to the client, the data written to the stream is split into chunks which are sent as they
+// are written. A write blocks while the maximum number of chunks are waiting for an acknowledgement of the client (see
+// [Streaming]), and closing the stream waits for the acknowledgement of all the chunks.
+//
+//	io.On("connection", func(clients ...any) {
+//		client := clients[0].(*socket.Socket)
+//		stream := client.OpenStream("download", map[string]any{"name": "report.pdf"})
+//		defer stream.Close()
+//		if _, err := io.Copy(stream, file); err != nil {
+//			stream.Cancel(err.Error())
+//		}
+//	})
+func (s *Socket) OpenStream(ev string, meta any) *StreamWriter {
+	opts := s.server.opts.Streaming()
+	w := &StreamWriter{
+		streams:     s.streams,
+		id:          strconv.FormatUint(s.streams.ids.Add(1), 10),
+		chunkSize:   opts.ChunkSize(),
+		maxInFlight: opts.MaxInFlight(),
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	if !s.Connected() {
+		w.err = fmt.Errorf("%w: %s", ErrStreamCancelled, "socket disconnected")
+		return w
+	}
+	s.streams.writers.Store(w.id, w)
+	stream_log.Debug("opening stream %s (%s) to socket %s", w.id, ev, s.id)
+	if err := s.Emit(STREAM_OPEN_EVENT, w.id, ev, meta); err != nil {
+		w.fail(err)
+	}
+	return w
+}
+
+// Registers the handler of the streams opened by the client for the given event, which is called on a new goroutine
+// for each stream. The reader is cancelled if it was neither read until the end nor cancelled when the handler
+// returns.
+//
+//	io.On("connection", func(clients ...any) {
+//		client := clients[0].(*socket.Socket)
+//		client.OnStream("upload", func(stream *socket.StreamReader) {
+//			if _, err := io.Copy(file, stream); err != nil {
+//				// the client aborted the upload or disconnected
+//			}
+//		})
+//	})
+func (s *Socket) OnStream(ev string, handler StreamHandler) *Socket {
+	if handler == nil {
+		s.streams.handlers.Delete(ev)
+	} else {
+		s.streams.handlers.Store(ev, handler)
+	}
+	return s
+}
+
+// Handles the events of the streams, returns whether the event was a stream event.
+func (ss *socketStreams) handle(event []any) bool {
+	ev, ok := event[0].(string)
+	if !ok || !strings.HasPrefix(ev, STREAM_EVENT_PREFIX) {
+		return false
+	}
+
+	args := event[1:]
+	var ack func([]any, error)
+	if l := len(args); l > 0 {
+		if fn, ok := args[l-1].(func([]any, error)); ok {
+			ack = fn
+			args = args[:l-1]
+		}
+	}
+	if len(args) == 0 {
+		stream_log.Debug("ignoring %s without stream id", ev)
+		return true
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		stream_log.Debug("ignoring %s with invalid stream id %v", ev, args[0])
+		return true
+	}
+
+	switch ev {
+	case STREAM_OPEN_EVENT:
+		ss.onopen(id, args[1:], ack)
+	case STREAM_CHUNK_EVENT:
+		if r, ok := ss.readers.Load(id); ok {
+			r.onchunk(args[1:], ack)
+		}
+	case STREAM_END_EVENT:
+		if r, ok := ss.readers.Load(id); ok {
+			r.onend(args[1:])
+		}
+	case STREAM_ABORT_EVENT:
+		if r, ok := ss.readers.Load(id); ok {
+			r.fail(fmt.Errorf("%w: %s", ErrStreamCancelled, streamReason(args[1:])))
+		}
+	case STREAM_CANCEL_EVENT:
+		if w, ok := ss.writers.Load(id); ok {
+			w.fail(fmt.Errorf("%w: %s", ErrStreamCancelled, streamReason(args[1:])))
+		}
+	default:
+		stream_log.Debug("ignoring unknown stream event %s", ev)
+	}
+	return true
+}
+
+func (ss *socketStreams) onopen(id string, args []any, ack func([]any, error)) {
+	reject := func(message string) {
+		stream_log.Debug("rejecting stream %s: %s", id, message)
+		if ack != nil {
+			ack([]any{map[string]any{"message": message}}, nil)
+		}
+	}
+
+	if len(args) == 0 {
+		reject("invalid stream event")
+		return
+	}
+	ev, ok := args[0].(string)
+	if !ok {
+		reject("invalid stream event")
+		return
+	}
+	handler, ok := ss.handlers.Load(ev)
+	if !ok {
+		reject("unknown stream event")
+		return
+	}
+
+	opts := ss.socket.server.opts.Streaming()
+	r := &StreamReader{
+		streams:     ss,
+		id:          id,
+		event:       ev,
+		maxInFlight: opts.MaxInFlight(),
+		chunks:      map[uint64]*streamChunk{},
+		seq:         1,
+	}
+	r.cond = sync.NewCond(&r.mu)
+	if len(args) > 1 {
+		r.meta = args[1]
+	}
+	if _, loaded := ss.readers.LoadOrStore(id, r); loaded {
+		reject("duplicate stream id")
+		return
+	}
+
+	stream_log.Debug("stream %s (%s) opened by socket %s", id, ev, ss.socket.id)
+	if ack != nil {
+		ack([]any{nil, map[string]any{
+			"chunkSize":   opts.ChunkSize(),
+			"maxInFlight": opts.MaxInFlight(),
+		}}, nil)
+	}
+
+	go func() {
+		defer ss.socket.recoverPanic(ev)
+		defer r.Cancel("stream handler returned")
+		handler(r)
+	}()
+}
+
+// Interrupts the streams upon disconnection.
+func (ss *socketStreams) close(reason string) {
+	err := fmt.Errorf("%w: %s", ErrStreamCancelled, reason)
+	ss.writers.Range(func(_ string, w *StreamWriter) bool {
+		w.fail(err)
+		return true
+	})
+	ss.readers.Range(func(_ string, r *StreamReader) bool {
+		r.fail(err)
+		return true
+	})
+}
+
+func streamReason(args []any) string {
+	if len(args) > 0 {
+		if reason, ok := args[0].(string); ok {
+			return reason
+		}
+	}
+	return "unknown reason"
+}
+
+// Returns the data of a chunk.
+func streamChunkData(data any) ([]byte, bool) {
+	switch tdata := data.(type) {
+	case []byte:
+		return tdata, true
+	case _types.BufferInterface:
+		return tdata.Bytes(), true
+	case io.Reader:
+		rdata, err := io.ReadAll(tdata)
+		return rdata, err == nil
+	}
+	return nil, false
+}
+
+// The id of the stream, which is unique among the streams opened by the server to the socket.
+func (w *StreamWriter) Id() string {
+	return w.id
+}
+
+// Sends the data, split into chunks of the configured size.
+func (w *StreamWriter) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		size := min(len(p)-n, w.chunkSize)
+		if err := w.send(p[n : n+size]); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+func (w *StreamWriter) send(data []byte) error {
+	w.mu.Lock()
+	for w.err == nil && !w.closed && w.inFlight >= w.maxInFlight {
+		w.cond.Wait()
+	}
+	if w.err != nil {
+		defer w.mu.Unlock()
+		return w.err
+	}
+	if w.closed {
+		w.mu.Unlock()
+		return ErrStreamClosed
+	}
+	w.inFlight++
+	w.seq++
+	seq := w.seq
+	w.mu.Unlock()
+
+	// the chunk may be kept (e.g. by the connection state recovery), while the caller may reuse the buffer
+	chunk := append([]byte{}, data...)
+	if err := w.streams.socket.Emit(STREAM_CHUNK_EVENT, w.id, seq, chunk, func([]any, error) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.inFlight--
+		w.cond.Broadcast()
+	}); err != nil {
+		w.fail(err)
+		return err
+	}
+	return nil
+}
+
+// Waits for the acknowledgement of the chunks which were sent, then ends the stream.
+func (w *StreamWriter) Close() error {
+	w.mu.Lock()
+	if w.err != nil {
+		defer w.mu.Unlock()
+		return w.err
+	}
+	if w.closed {
+		w.mu.Unlock()
+		return ErrStreamClosed
+	}
+	w.closed = true
+	for w.err == nil && w.inFlight > 0 {
+		w.cond.Wait()
+	}
+	err, count := w.err, w.seq
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w.streams.writers.Delete(w.id)
+	stream_log.Debug("ending stream %s after %d chunks", w.id, count)
+	return w.streams.socket.Emit(STREAM_END_EVENT, w.id, count)
+}
+
+// Aborts the stream, the pending writes fail with [ErrStreamCancelled].
+func (w *StreamWriter) Cancel(reason string) {
+	if w.fail(fmt.Errorf("%w: %s", ErrStreamCancelled, reason)) {
+		w.streams.socket.Emit(STREAM_ABORT_EVENT, w.id, reason)
+	}
+}
+
+// Stops the stream with the given error, returns false if it was already stopped.
+func (w *StreamWriter) fail(err error) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return false
+	}
+	stream_log.Debug("stream %s stopped: %v", w.id, err)
+	w.err = err
+	w.cond.Broadcast()
+	w.streams.writers.Delete(w.id)
+	return true
+}
+
+// The id of the stream, which is chosen by the client.
+func (r *StreamReader) Id() string {
+	return r.id
+}
+
+// The event of the stream.
+func (r *StreamReader) Event() string {
+	return r.event
+}
+
+// The metadata sent by the client along with the stream.
+func (r *StreamReader) Meta() any {
+	return r.meta
+}
+
+// The socket which opened the stream.
+func (r *StreamReader) Socket() *Socket {
+	return r.streams.socket
+}
+
+// Reads the data of the stream, the chunks being acknowledged once read. It returns [io.EOF] once the stream is ended
+// and read.
+func (r *StreamReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if chunk, ok := r.chunks[r.seq]; ok {
+			n := copy(p, chunk.data)
+			chunk.data = chunk.data[n:]
+			if len(chunk.data) == 0 {
+				delete(r.chunks, r.seq)
+				r.seq++
+				if chunk.ack != nil {
+					chunk.ack([]any{}, nil)
+				}
+			}
+			if n > 0 {
+				return n, nil
+			}
+			// an empty chunk
+			continue
+		}
+		if r.count != nil && r.seq > *r.count {
+			r.streams.readers.Delete(r.id)
+			return 0, io.EOF
+		}
+		r.cond.Wait()
+	}
+}
+
+// Cancels the stream, the client stops sending it. It has no effect once the stream was read until the end.
+func (r *StreamReader) Cancel(reason string) {
+	r.mu.Lock()
+	ended := r.count != nil && r.seq > *r.count
+	r.mu.Unlock()
+	if ended {
+		return
+	}
+	if r.fail(fmt.Errorf("%w: %s", ErrStreamCancelled, reason)) {
+		r.streams.socket.Emit(STREAM_CANCEL_EVENT, r.id, reason)
+	}
+}
+
+func (r *StreamReader) onchunk(args []any, ack func([]any, error)) {
+	if len(args) < 2 {
+		r.Cancel("invalid chunk")
+		return
+	}
+	seq, ok := streamSeq(args[0])
+	if !ok {
+		r.Cancel("invalid chunk")
+		return
+	}
+	data, ok := streamChunkData(args[1])
+	if !ok {
+		r.Cancel("invalid chunk")
+		return
+	}
+	if len(data) > r.streams.socket.server.opts.Streaming().ChunkSize() {
+		r.Cancel("chunk too large")
+		return
+	}
+
+	r.mu.Lock()
+	if r.err != nil {
+		r.mu.Unlock()
+		return
+	}
+	// the chunks may be dispatched out of order, they are reordered with their sequence number
+	if _, exists := r.chunks[seq]; exists || seq < r.seq || (r.count != nil && seq > *r.count) {
+		r.mu.Unlock()
+		r.Cancel("invalid chunk sequence")
+		return
+	}
+	if len(r.chunks) >= r.maxInFlight {
+		r.mu.Unlock()
+		r.Cancel("too many chunks in flight")
+		return
+	}
+	r.chunks[seq] = &streamChunk{data: data, ack: ack}
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+func (r *StreamReader) onend(args []any) {
+	count, ok := uint64(0), false
+	if len(args) > 0 {
+		count, ok = streamSeq(args[0])
+	}
+	if !ok {
+		r.Cancel("invalid end of stream")
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count == nil {
+		r.count = &count
+		r.cond.Broadcast()
+	}
+}
+
+// Stops the stream with the given error, returns false if it was already stopped.
+func (r *StreamReader) fail(err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return false
+	}
+	stream_log.Debug("stream %s stopped: %v", r.id, err)
+	r.err = err
+	r.chunks = map[uint64]*streamChunk{}
+	r.cond.Broadcast()
+	r.streams.readers.Delete(r.id)
+	return true
+}
+
+// Returns the sequence number (or the number of chunks) sent by the client.
+func streamSeq(value any) (uint64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return uint64(v), v >= 0 && v == float64(uint64(v))
+	}
+	return frameUint(normalizeFrameData(value))
+}
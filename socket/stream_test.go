@@ -0,0 +1,323 @@
+package socket
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/zishang520/engine.io-server-go-fasthttp/v2/engine"
+	"github.com/zishang520/socket.io-go-parser/v2/parser"
+)
+
+// A connection which is not open, so that the packets are dropped once notified to the outgoing listeners.
+type streamTestConn struct {
+	engine.Socket
+}
+
+func (streamTestConn) ReadyState() string {
+	return "closed"
+}
+
+// Returns a connected socket, with the events it emits.
+func newStreamTestSocket(chunkSize, maxInFlight int) (*Socket, chan []any) {
+	streaming := &Streaming{}
+	streaming.SetChunkSize(chunkSize)
+	streaming.SetMaxInFlight(maxInFlight)
+	opts := DefaultServerOptions()
+	opts.SetStreaming(streaming)
+	io := NewServer(nil, opts)
+
+	s := MakeSocket()
+	s.server = io
+	s.nsp = io.Of("/", nil)
+	s.client = &Client{conn: streamTestConn{}}
+	s.id = "a"
+	s.connected.Store(true)
+
+	outgoing := make(chan []any, 100)
+	s.OnAnyOutgoing(func(args ...any) {
+		outgoing <- args
+	})
+	return s, outgoing
+}
+
+func expectStreamEvent(t *testing.T, outgoing chan []any, ev string) []any {
+	t.Helper()
+	select {
+	case args := <-outgoing:
+		if args[0] != ev {
+			t.Fatalf("expected %s, got %v", ev, args)
+		}
+		return args[1:]
+	case <-time.After(time.Second):
+		t.Fatalf("expected %s", ev)
+	}
+	return nil
+}
+
+func expectNoStreamEvent(t *testing.T, outgoing chan []any) {
+	t.Helper()
+	select {
+	case args := <-outgoing:
+		t.Fatalf("unexpected event %v", args)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Acknowledges the oldest chunk sent by the server, like the client would.
+func ackStreamChunk(t *testing.T, s *Socket) {
+	t.Helper()
+	ids := s.Acks().Keys()
+	if len(ids) == 0 {
+		t.Fatal("expected a chunk waiting for an acknowledgement")
+	}
+	id := ids[0]
+	for _, i := range ids {
+		id = min(id, i)
+	}
+	s.onack(&parser.Packet{Type: parser.ACK, Id: &id, Data: []any{}})
+}
+
+func TestStreamWriterFlowControl(t *testing.T) {
+	s, outgoing := newStreamTestSocket(4, 2)
+
+	w := s.OpenStream("download", "report.pdf")
+	if args := expectStreamEvent(t, outgoing, STREAM_OPEN_EVENT); args[0] != w.Id() || args[1] != "download" || args[2] != "report.pdf" {
+		t.Fatalf("unexpected open event %v", args)
+	}
+
+	written := make(chan int, 1)
+	go func() {
+		n, err := w.Write([]byte("hello world!"))
+		if err != nil {
+			t.Error(err)
+		}
+		written <- n
+	}()
+
+	// the third chunk waits for the acknowledgement of the first one
+	for seq := uint64(1); seq <= 2; seq++ {
+		if args := expectStreamEvent(t, outgoing, STREAM_CHUNK_EVENT); args[1] != seq || len(args[2].([]byte)) != 4 {
+			t.Fatalf("unexpected chunk %v", args)
+		}
+	}
+	expectNoStreamEvent(t, outgoing)
+	ackStreamChunk(t, s)
+	if args := expectStreamEvent(t, outgoing, STREAM_CHUNK_EVENT); args[1] != uint64(3) || string(args[2].([]byte)) != "rld!" {
+		t.Fatalf("unexpected chunk %v", args)
+	}
+	if n := <-written; n != 12 {
+		t.Fatalf("expected 12 bytes to be written, got %d", n)
+	}
+
+	// the stream ends once all the chunks are acknowledged
+	closed := make(chan error, 1)
+	go func() {
+		closed <- w.Close()
+	}()
+	ackStreamChunk(t, s)
+	expectNoStreamEvent(t, outgoing)
+	ackStreamChunk(t, s)
+	if args := expectStreamEvent(t, outgoing, STREAM_END_EVENT); args[0] != w.Id() || args[1] != uint64(3) {
+		t.Fatalf("unexpected end event %v", args)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("!")); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("expected ErrStreamClosed, got %v", err)
+	}
+}
+
+func TestStreamWriterCancellation(t *testing.T) {
+	t.Run("cancelled by the client", func(t *testing.T) {
+		s, outgoing := newStreamTestSocket(4, 1)
+		w := s.OpenStream("download", nil)
+		expectStreamEvent(t, outgoing, STREAM_OPEN_EVENT)
+
+		written := make(chan error, 1)
+		go func() {
+			_, err := w.Write([]byte("hello world!"))
+			written <- err
+		}()
+		expectStreamEvent(t, outgoing, STREAM_CHUNK_EVENT)
+
+		// the blocked write is released
+		s.streams.handle([]any{STREAM_CANCEL_EVENT, w.Id(), "disk full"})
+		if err := <-written; !errors.Is(err, ErrStreamCancelled) {
+			t.Fatalf("expected ErrStreamCancelled, got %v", err)
+		}
+		if err := w.Close(); !errors.Is(err, ErrStreamCancelled) {
+			t.Fatalf("expected ErrStreamCancelled, got %v", err)
+		}
+		expectNoStreamEvent(t, outgoing)
+	})
+
+	t.Run("aborted by the server", func(t *testing.T) {
+		s, outgoing := newStreamTestSocket(4, 1)
+		w := s.OpenStream("download", nil)
+		expectStreamEvent(t, outgoing, STREAM_OPEN_EVENT)
+
+		w.Cancel("file deleted")
+		if args := expectStreamEvent(t, outgoing, STREAM_ABORT_EVENT); args[0] != w.Id() || args[1] != "file deleted" {
+			t.Fatalf("unexpected abort event %v", args)
+		}
+		if _, err := w.Write([]byte("hello")); !errors.Is(err, ErrStreamCancelled) {
+			t.Fatalf("expected ErrStreamCancelled, got %v", err)
+		}
+		// the stream is aborted only once
+		w.Cancel("file deleted")
+		expectNoStreamEvent(t, outgoing)
+	})
+
+	t.Run("disconnection", func(t *testing.T) {
+		s, outgoing := newStreamTestSocket(4, 1)
+		w := s.OpenStream("download", nil)
+		expectStreamEvent(t, outgoing, STREAM_OPEN_EVENT)
+
+		closed := make(chan error, 1)
+		go func() {
+			w.Write([]byte("hello"))
+			closed <- w.Close()
+		}()
+		expectStreamEvent(t, outgoing, STREAM_CHUNK_EVENT)
+
+		s.streams.close("transport close")
+		if err := <-closed; !errors.Is(err, ErrStreamCancelled) {
+			t.Fatalf("expected ErrStreamCancelled, got %v", err)
+		}
+	})
+}
+
+// Opens a stream like the client would, returns the reader passed to the handler.
+func openTestStreamReader(t *testing.T, s *Socket) (*StreamReader, func()) {
+	t.Helper()
+	readers := make(chan *StreamReader, 1)
+	done := make(chan struct{})
+	s.OnStream("upload", func(r *StreamReader) {
+		readers <- r
+		<-done
+	})
+
+	var response []any
+	s.streams.handle([]any{STREAM_OPEN_EVENT, "1", "upload", "photo.png", func(args []any, _ error) {
+		response = args
+	}})
+	if len(response) != 2 || response[0] != nil {
+		t.Fatalf("unexpected response %v", response)
+	}
+
+	select {
+	case r := <-readers:
+		return r, func() { close(done) }
+	case <-time.After(time.Second):
+		t.Fatal("expected the handler to be called")
+	}
+	return nil, nil
+}
+
+func TestStreamReaderFlowControl(t *testing.T) {
+	s, outgoing := newStreamTestSocket(8, 4)
+	r, done := openTestStreamReader(t, s)
+	defer done()
+	if r.Event() != "upload" || r.Meta() != "photo.png" {
+		t.Fatalf("unexpected stream %s %v", r.Event(), r.Meta())
+	}
+
+	acks := make([]bool, 3)
+	ack := func(seq int) func([]any, error) {
+		return func([]any, error) {
+			acks[seq] = true
+		}
+	}
+	// the chunks are reordered with their sequence number
+	s.streams.handle([]any{STREAM_CHUNK_EVENT, "1", float64(2), []byte("world"), ack(2)})
+	s.streams.handle([]any{STREAM_CHUNK_EVENT, "1", float64(1), []byte("hello "), ack(1)})
+	s.streams.handle([]any{STREAM_END_EVENT, "1", float64(2)})
+
+	// a chunk is acknowledged once read
+	p := make([]byte, 6)
+	if n, err := r.Read(p); err != nil || string(p[:n]) != "hello " {
+		t.Fatalf("unexpected read %q %v", p[:n], err)
+	}
+	if !acks[1] || acks[2] {
+		t.Fatalf("unexpected acknowledgements %v", acks)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil || string(rest) != "world" {
+		t.Fatalf("unexpected read %q %v", rest, err)
+	}
+	if !acks[2] {
+		t.Fatal("expected the last chunk to be acknowledged")
+	}
+
+	// the cancellation has no effect once the stream is read
+	r.Cancel("done")
+	expectNoStreamEvent(t, outgoing)
+}
+
+func TestStreamReaderCancellation(t *testing.T) {
+	t.Run("too many chunks in flight", func(t *testing.T) {
+		s, outgoing := newStreamTestSocket(8, 2)
+		r, done := openTestStreamReader(t, s)
+		defer done()
+
+		for seq := 1; seq <= 3; seq++ {
+			s.streams.handle([]any{STREAM_CHUNK_EVENT, "1", float64(seq), []byte("chunk")})
+		}
+		if args := expectStreamEvent(t, outgoing, STREAM_CANCEL_EVENT); args[0] != "1" || args[1] != "too many chunks in flight" {
+			t.Fatalf("unexpected cancel event %v", args)
+		}
+		if _, err := r.Read(make([]byte, 8)); !errors.Is(err, ErrStreamCancelled) {
+			t.Fatalf("expected ErrStreamCancelled, got %v", err)
+		}
+	})
+
+	t.Run("chunk too large", func(t *testing.T) {
+		s, outgoing := newStreamTestSocket(4, 2)
+		r, done := openTestStreamReader(t, s)
+		defer done()
+
+		s.streams.handle([]any{STREAM_CHUNK_EVENT, "1", float64(1), []byte("hello")})
+		if args := expectStreamEvent(t, outgoing, STREAM_CANCEL_EVENT); args[1] != "chunk too large" {
+			t.Fatalf("unexpected cancel event %v", args)
+		}
+		if _, err := r.Read(make([]byte, 8)); !errors.Is(err, ErrStreamCancelled) {
+			t.Fatalf("expected ErrStreamCancelled, got %v", err)
+		}
+	})
+
+	t.Run("aborted by the client", func(t *testing.T) {
+		s, outgoing := newStreamTestSocket(8, 2)
+		r, done := openTestStreamReader(t, s)
+		defer done()
+
+		read := make(chan error, 1)
+		go func() {
+			_, err := r.Read(make([]byte, 8))
+			read <- err
+		}()
+		s.streams.handle([]any{STREAM_ABORT_EVENT, "1", "user cancelled"})
+		if err := <-read; !errors.Is(err, ErrStreamCancelled) {
+			t.Fatalf("expected ErrStreamCancelled, got %v", err)
+		}
+		// the client is not notified of its own abort
+		r.Cancel("done")
+		expectNoStreamEvent(t, outgoing)
+	})
+
+	t.Run("cancelled by the server", func(t *testing.T) {
+		s, outgoing := newStreamTestSocket(8, 2)
+		r, done := openTestStreamReader(t, s)
+		defer done()
+
+		r.Cancel("quota exceeded")
+		if args := expectStreamEvent(t, outgoing, STREAM_CANCEL_EVENT); args[0] != "1" || args[1] != "quota exceeded" {
+			t.Fatalf("unexpected cancel event %v", args)
+		}
+		// the chunks sent in the meantime are ignored
+		s.streams.handle([]any{STREAM_CHUNK_EVENT, "1", float64(1), []byte("hello")})
+		expectNoStreamEvent(t, outgoing)
+	})
+}
@@ -0,0 +1,150 @@
+package socket
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	wheelTimer struct {
+		// the number of remaining revolutions of the wheel before the timer expires
+		rounds int
+		fn     func()
+		// the slot of the timer, -1 once expired or stopped
+		slot int
+	}
+
+	// A hashed timer wheel: the timers are spread over the slots of the wheel according to their expiry, and a single
+	// goroutine advances the wheel every tick, instead of having one runtime timer per scheduled function.
+	//
+	// The timers expire with a precision of one tick.
+	timerWheel struct {
+		mu     sync.Mutex
+		tick   time.Duration
+		slots  []map[*wheelTimer]struct{}
+		cursor int
+		// the time of the last tick
+		now time.Time
+
+		running bool
+		stop    chan struct{}
+		done    chan struct{}
+	}
+)
+
+func newTimerWheel(tick time.Duration, slots int) *timerWheel {
+	w := &timerWheel{
+		tick:  tick,
+		slots: make([]map[*wheelTimer]struct{}, slots),
+	}
+	for i := range w.slots {
+		w.slots[i] = map[*wheelTimer]struct{}{}
+	}
+	return w
+}
+
+// Schedules a function, which is called on the goroutine of the wheel once the given time is reached.
+func (w *timerWheel) Add(at time.Time, fn func()) *wheelTimer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.running {
+		w.start()
+	}
+
+	ticks := int((at.Sub(w.now) + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	t := &wheelTimer{
+		rounds: (ticks - 1) / len(w.slots),
+		fn:     fn,
+		slot:   (w.cursor + ticks) % len(w.slots),
+	}
+	w.slots[t.slot][t] = struct{}{}
+	return t
+}
+
+// Stops a timer, returns whether it was pending.
+func (w *timerWheel) Remove(t *wheelTimer) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t.slot < 0 {
+		return false
+	}
+	delete(w.slots[t.slot], t)
+	t.slot = -1
+	return true
+}
+
+// Must be called with the lock held.
+func (w *timerWheel) start() {
+	w.running = true
+	w.now = time.Now()
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.run(w.stop, w.done)
+}
+
+func (w *timerWheel) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, fn := range w.advance(now) {
+				fn()
+			}
+		}
+	}
+}
+
+// Advances the wheel up to the given time (several slots if ticks were missed), returns the expired functions.
+func (w *timerWheel) advance(now time.Time) (expired []func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for !w.now.Add(w.tick).After(now) {
+		w.now = w.now.Add(w.tick)
+		w.cursor = (w.cursor + 1) % len(w.slots)
+		for t := range w.slots[w.cursor] {
+			if t.rounds > 0 {
+				t.rounds--
+				continue
+			}
+			delete(w.slots[w.cursor], t)
+			t.slot = -1
+			expired = append(expired, t.fn)
+		}
+	}
+	return expired
+}
+
+// Stops the wheel, and returns the functions of the pending timers.
+func (w *timerWheel) Stop() (pending []func()) {
+	w.mu.Lock()
+	if !w.running {
+		w.mu.Unlock()
+		return nil
+	}
+	w.running = false
+	close(w.stop)
+	done := w.done
+	for _, slot := range w.slots {
+		for t := range slot {
+			delete(slot, t)
+			t.slot = -1
+			pending = append(pending, t.fn)
+		}
+	}
+	w.mu.Unlock()
+
+	<-done
+	return pending
+}